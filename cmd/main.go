@@ -2,27 +2,48 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
 	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/potatowhite/books/file-service/config"
 	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/consumer/kafka"
 	"github.com/potatowhite/books/file-service/db"
 	"github.com/potatowhite/books/file-service/graph"
+	eventhandler "github.com/potatowhite/books/file-service/handler"
 	"github.com/potatowhite/books/file-service/handler/users"
+	"github.com/potatowhite/books/file-service/health"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/metrics"
+	"github.com/potatowhite/books/file-service/pkg/archive"
+	"github.com/potatowhite/books/file-service/pkg/importer"
 	"github.com/potatowhite/books/file-service/pkg/repository"
 	"github.com/potatowhite/books/file-service/pkg/resolver"
+	"github.com/potatowhite/books/file-service/pkg/rest"
+	"github.com/potatowhite/books/file-service/pkg/rpc"
 	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	"github.com/potatowhite/books/file-service/pkg/webdav"
+	"github.com/potatowhite/books/file-service/schema"
+	"github.com/potatowhite/books/file-service/tracing"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 	"gorm.io/gorm"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"syscall"
+	"time"
 )
 
 var (
-	logger = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
+	logger = logging.For("main")
 )
 
 func main() {
@@ -32,83 +53,275 @@ func main() {
 
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		fatal("failed to load config", err)
 	}
 
+	if err := logging.Init(cfg.Logging); err != nil {
+		fatal("failed to initialize logging", err)
+	}
+	logger.Info("loaded config", "database", cfg.Database, "server", cfg.Server, "broker", cfg.Policy.Broker)
+
 	if port != "" {
 		cfg.Server.Port = port
 	}
 
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		fatal("failed to initialize tracing", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	database, err := db.InitDB(cfg)
 	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+		fatal("failed to connect to database", err)
 	}
 
 	defer db.CloseDB(database)
 
-	folderRepo, fileRepo := initRepository(database)
-	folderSvc, fileSvc := initService(folderRepo, fileRepo)
+	timeouts := repository.NewTimeouts(cfg.Database.Timeout.Default, cfg.Database.Timeout.Operations)
+	naming := service.NewNamingPolicy(cfg.Naming)
+	folderRepo, fileRepo, tagRepo := initRepository(database, timeouts, naming)
+	transactor := repository.NewTransactor(database)
+	metadata := service.NewMetadataPolicy(cfg.Metadata)
 
-	userConsumer, err := initUserConsumer(cfg, fileSvc, folderSvc)
-	defer userConsumer.Close()
+	blobs, err := storage.NewLocalBlobStore(cfg.Storage.Dir)
+	if err != nil {
+		fatal("failed to open blob storage", err)
+	}
 
-	server := initGraphqlServer(folderSvc, fileSvc)
-	startServer(server, cfg.Server.Port)
+	folderSvc, fileSvc, tagSvc := initService(folderRepo, fileRepo, tagRepo, naming, metadata, blobs, transactor)
 
-}
+	bulkSvc := service.NewBulkService(folderSvc, fileSvc, naming, blobs, transactor)
+	searchSvc := service.NewSearchService(repository.NewSearchRepository(database, timeouts), folderSvc)
+	archiveSvc := archive.NewService(folderSvc, fileSvc, blobs)
+	davHandler := webdav.Handler("/webdav", folderSvc, fileSvc, blobs, naming, transactor)
 
-func initUserConsumer(cfg *config.Config, fileSvc service.FileService, folderSvc service.FolderService) (consumer.Consumer, error) {
-	userConsumer, err := consumer.NewConsumer(cfg.Policy.Users.BootStrapServers, cfg.Policy.Users.GroupId, &users.UserEventHandler{
-		FileSvc:   fileSvc,
-		FolderSvc: folderSvc,
-	})
+	restHandler, err := rest.Handler(folderSvc, fileSvc)
+	if err != nil {
+		fatal("failed to create REST API", err)
+	}
+
+	// running imports are stopped before the database closes
+	importSvc := importer.NewService(folderSvc, fileSvc, blobs, cfg.Import)
+	defer importSvc.Close()
+
+	// the in-memory broker replaces Kafka for local development
+	var memoryBroker *consumer.MemoryBroker
+	if cfg.Policy.Broker == "memory" {
+		memoryBroker = consumer.NewMemoryBroker()
+	}
+
+	eventProducer, err := initProducer(cfg, memoryBroker)
+	if err != nil {
+		fatal("failed to create producer", err)
+	}
+	defer eventProducer.Close()
 
+	schemas, err := schema.LoadRegistry(cfg.Schema.Dir)
+	if err != nil {
+		fatal("failed to load event schemas", err)
+	}
+
+	registry := initHandlerRegistry(cfg, schemas, fileSvc, folderSvc, tagSvc, eventProducer)
+
+	eventConsumer, err := initConsumer(cfg, memoryBroker, registry)
+	if err != nil {
+		fatal("failed to create consumer", err)
+	}
+
+	serviceHealth := initHealth(database, cfg.Storage.Dir, eventConsumer)
+
+	server := initGraphqlServer(folderSvc, fileSvc, bulkSvc, importSvc, tagSvc, searchSvc, cfg.Import.MaxArchiveBytes)
+	httpServer := initHttpServer(server, restHandler, archiveSvc, davHandler, serviceHealth, cfg.Server.Port)
+	grpcServer := rpc.NewServer(folderSvc, fileSvc, serviceHealth)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// a failing server or consumer shuts the whole service down
+	failures := make(chan error, 3)
+	go func() {
+		if err := eventConsumer.Run(); err != nil {
+			failures <- fmt.Errorf("consumer failed: %v", err)
+		}
+	}()
 	go func() {
-		if err := userConsumer.Run(); err != nil {
-			log.Fatalf("failed to run Kafka consumer: %v", err)
+		if err := startServer(httpServer); err != nil {
+			failures <- fmt.Errorf("http server failed: %v", err)
 		}
 	}()
+	go func() {
+		if err := grpcServer.Serve(cfg.Grpc.Port); err != nil {
+			failures <- fmt.Errorf("grpc server failed: %v", err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		logger.Info("received shutdown signal")
+	case err := <-failures:
+		logger.Error("shutting down", "error", err)
+	}
+
+	shutdown(httpServer, grpcServer, serviceHealth, eventConsumer, time.Duration(cfg.Server.ShutdownTimeout)*time.Second)
+
+	// the deferred producer and database pool are closed last
+}
+
+// fatal() logs the error that keeps the service from starting and exits
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// shutdown() stops accepting requests, drains the in-flight ones until the timeout
+// and then stops the consumer after its current messages
+func shutdown(httpServer *http.Server, grpcServer *rpc.Server, serviceHealth *health.Health, eventConsumer consumer.Consumer, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	serviceHealth.ShutDown()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error("failed to drain http server", "error", err)
+	}
+	grpcServer.Shutdown(ctx)
+
+	eventConsumer.Close()
+	logger.Info("shutdown complete")
+}
+
+// initHandlerRegistry() registers the handler of every consumed topic, add new topics here
+func initHandlerRegistry(cfg *config.Config, schemas *schema.Registry, fileSvc service.FileService, folderSvc service.FolderService, tagSvc service.TagService, producer consumer.Producer) *eventhandler.Registry {
+	registry := eventhandler.NewRegistry()
+	registry.SetDeadLetter(consumer.DeadLetterTo(producer, cfg.Policy.DeadLetter.Topic))
+
+	userHandler := &users.UserEventHandler{
+		FileSvc:    fileSvc,
+		FolderSvc:  folderSvc,
+		TagSvc:     tagSvc,
+		Schemas:    schemas,
+		Producer:   producer,
+		EventTopic: cfg.Policy.Events.Topic,
+	}
+	users.RegisterUpcasters(schemas)
+	registry.Register(cfg.Policy.Users.Topic, userHandler, userHandler.EventTypes()...)
+
+	return registry
+}
 
-	return userConsumer, err
+func initProducer(cfg *config.Config, memoryBroker *consumer.MemoryBroker) (consumer.Producer, error) {
+	if memoryBroker != nil {
+		return memoryBroker.Producer(), nil
+	}
+
+	return kafka.NewProducer(cfg.Policy.Events.BootStrapServers, cfg.Policy.Events.Timeout)
 }
 
-func initRepository(db *gorm.DB) (folderRepo repository.FolderRepository, fileRepo repository.FileRepository) {
-	folderRepo = repository.NewFolderRepository(db)
-	fileRepo = repository.NewFileRepository(db)
+func initConsumer(cfg *config.Config, memoryBroker *consumer.MemoryBroker, registry *eventhandler.Registry) (consumer.Consumer, error) {
+	var eventConsumer consumer.Consumer
+	if memoryBroker != nil {
+		eventConsumer = memoryBroker.NewConsumer(registry)
+	} else {
+		kafkaConsumer, err := kafka.NewConsumer(cfg.Policy.Consumer.BootStrapServers, cfg.Policy.Consumer.GroupId,
+			cfg.Policy.Consumer.Concurrency, cfg.Policy.Consumer.BufferSize, registry)
+		if err != nil {
+			return nil, err
+		}
+		eventConsumer = kafkaConsumer
+	}
+
+	return eventConsumer, nil
+}
+
+func initRepository(db *gorm.DB, timeouts repository.Timeouts, naming *service.NamingPolicy) (folderRepo repository.FolderRepository, fileRepo repository.FileRepository, tagRepo repository.TagRepository) {
+	folderRepo = repository.NewFolderRepository(db, timeouts, naming.Key)
+	fileRepo = repository.NewFileRepository(db, timeouts, naming.Key)
+	tagRepo = repository.NewTagRepository(db, timeouts)
 	return
 }
 
-func initService(folderRepo repository.FolderRepository, fileRepo repository.FileRepository) (folderSvc service.FolderService, fileSvc service.FileService) {
-	folderSvc = service.NewFolderService(folderRepo)
-	fileSvc = service.NewFileService(fileRepo)
+func initService(folderRepo repository.FolderRepository, fileRepo repository.FileRepository, tagRepo repository.TagRepository, naming *service.NamingPolicy, metadata *service.MetadataPolicy, blobs storage.BlobStore, tx repository.Transactor) (folderSvc service.FolderService, fileSvc service.FileService, tagSvc service.TagService) {
+	folderSvc = service.NewFolderService(folderRepo, naming, metadata, blobs, tx)
+	fileSvc = service.NewFileService(fileRepo, naming, metadata, blobs, tx)
+	tagSvc = service.NewTagService(tagRepo, tx)
 	return
 }
 
-func initGraphqlServer(folderSvc service.FolderService, fileSvc service.FileService) *handler.Server {
-	resolver := resolver.NewResolver(folderSvc, fileSvc)
+func initGraphqlServer(folderSvc service.FolderService, fileSvc service.FileService, bulkSvc service.BulkService, importSvc importer.Service, tagSvc service.TagService, searchSvc service.SearchService, maxUploadBytes int64) *handler.Server {
+	resolver := resolver.NewResolver(folderSvc, fileSvc, bulkSvc, importSvc, tagSvc, searchSvc)
 	schema := graph.NewExecutableSchema(graph.Config{Resolvers: resolver})
-	server := handler.NewDefaultServer(schema)
+
+	// the transports of handler.NewDefaultServer, with uploads as large as an imported archive may be
+	server := handler.New(schema)
+	server.AddTransport(transport.Websocket{KeepAlivePingInterval: 10 * time.Second})
+	server.AddTransport(transport.Options{})
+	server.AddTransport(transport.GET{})
+	server.AddTransport(transport.POST{})
+	server.AddTransport(transport.MultipartForm{MaxUploadSize: maxUploadBytes + 1<<20, MaxMemory: 32 << 20})
+	server.Use(extension.Introspection{})
+	server.Use(extension.AutomaticPersistedQuery{Cache: lru.New(100)})
+	server.Use(metrics.GraphqlExtension{})
+	server.Use(tracing.GraphqlExtension{})
+	server.Use(logging.GraphqlExtension{})
 	server.AroundResponses(func(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
 		res := next(ctx)
 		if len(res.Errors) > 0 {
 			op := graphql.GetOperationContext(ctx)
 			rawQuery := normalizeQuery(op.RawQuery)
-			logger.Printf("Failed operation %s with query: %s", op.OperationName, rawQuery)
+			logger.ErrorContext(ctx, "failed operation", "operation", op.OperationName, "query", rawQuery)
 		}
 		return res
 	})
 
+	server.SetErrorPresenter(presentError)
 	server.SetQueryCache(lru.New(1000))
 
 	return server
 }
 
-func startServer(server *handler.Server, port string) {
-	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", server)
+// presentError() tells clients which errors they can resolve themselves
+func presentError(ctx context.Context, err error) *gqlerror.Error {
+	presented := graphql.DefaultErrorPresenter(ctx, err)
+	if code := util.ErrorCode(err); code != "" {
+		if presented.Extensions == nil {
+			presented.Extensions = map[string]interface{}{}
+		}
+		presented.Extensions["code"] = code
+	}
+	return presented
+}
+
+func initHealth(database *gorm.DB, blobDir string, eventConsumer consumer.Consumer) *health.Health {
+	serviceHealth := health.New()
+	serviceHealth.AddLivenessCheck("consumer", consumer.LivenessCheck(eventConsumer))
+	serviceHealth.AddReadinessCheck("postgres", db.HealthCheck(database))
+	serviceHealth.AddReadinessCheck("storage", storage.HealthCheck(blobDir))
+	serviceHealth.AddReadinessCheck("broker", consumer.ReadinessCheck(eventConsumer))
+	return serviceHealth
+}
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+func initHttpServer(server *handler.Server, restHandler http.Handler, archiveSvc archive.Service, davHandler http.Handler, serviceHealth *health.Health, port string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/", playground.Handler("GraphQL playground", "/query"))
+	mux.Handle("/query", server)
+	mux.Handle("/api/v1/", restHandler)
+	mux.Handle("/archive", archive.Handler(archiveSvc))
+	mux.Handle("/webdav/", davHandler)
+	mux.Handle("/healthz", serviceHealth.LivenessHandler())
+	mux.Handle("/readyz", serviceHealth.ReadinessHandler())
+	mux.Handle("/metrics", metrics.Handler())
+
+	return &http.Server{Addr: ":" + port, Handler: tracing.Middleware(logging.Middleware(mux))}
+}
+
+// startServer() blocks until the server fails or is shut down
+func startServer(httpServer *http.Server) error {
+	logger.Info("connect to http://localhost" + httpServer.Addr + "/ for GraphQL playground")
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func normalizeQuery(query string) string {
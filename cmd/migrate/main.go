@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/db"
+	"github.com/potatowhite/books/file-service/logging"
+	"gorm.io/gorm"
+	"os"
+	"time"
+)
+
+var (
+	logger = logging.For("migrate")
+)
+
+const usage = "usage: migrate up|down|status"
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("failed to load config", err)
+	}
+
+	if err := logging.Init(cfg.Logging); err != nil {
+		fatal("failed to initialize logging", err)
+	}
+
+	database, err := db.OpenForMigration(cfg)
+	if err != nil {
+		fatal("failed to connect to database", err)
+	}
+	defer db.CloseDB(database)
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "up":
+		err = db.MigrateUp(ctx, database)
+	case "down":
+		err = db.MigrateDown(ctx, database)
+	case "status":
+		err = printStatus(ctx, database)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		db.CloseDB(database)
+		fatal("migration failed", err)
+	}
+}
+
+// printStatus() lists every known migration and when it was applied
+func printStatus(ctx context.Context, database *gorm.DB) error {
+	migrations, err := db.MigrationStatus(ctx, database)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		applied := "pending"
+		if migration.AppliedAt != nil {
+			applied = migration.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d  %-40s %s\n", migration.Version, migration.Name, applied)
+	}
+	return nil
+}
+
+// fatal() logs the error that stops the migration and exits
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -3,7 +3,7 @@ package config
 import (
 	"bytes"
 	"github.com/spf13/viper"
-	"log"
+	"log/slog"
 	"strings"
 )
 
@@ -15,20 +15,142 @@ type Database struct {
 	Username string
 	Password string
 	Dbname   string
+	Timeout  Timeout
+}
+
+// LogValue() keeps the password out of the logs
+func (d Database) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("host", d.Host),
+		slog.Any("port", d.Port),
+		slog.String("username", d.Username),
+		slog.String("password", "[REDACTED]"),
+		slog.String("dbname", d.Dbname),
+	)
+}
+
+// Timeout of database operations in milliseconds, 0 disables the timeout
+type Timeout struct {
+	// Default applies to every repository operation without its own entry
+	Default int
+	// Operations maps repository method names to their timeout
+	Operations map[string]int
+}
+
+// Grpc serves the file tree to other backend services, on its own port
+type Grpc struct {
+	Port string
 }
 
 type Server struct {
 	Port string
+	// ShutdownTimeout is the number of seconds in-flight requests get to finish on shutdown
+	ShutdownTimeout int
 }
 
 type Config struct {
 	Database Database
 	Server   Server
 	Policy   Policy
+	Schema   Schema
+	Tracing  Tracing
+	Logging  Logging
+	Naming   Naming
+	Storage  Storage
+	Import   Import
+	Grpc     Grpc
+	Metadata Metadata
+}
+
+// Import limits archives imported into the folder tree, guarding against archive bombs
+type Import struct {
+	// MaxArchiveBytes is the largest accepted upload
+	MaxArchiveBytes int64
+	// MaxExtractedBytes is the largest total size of the extracted files
+	MaxExtractedBytes int64
+	// MaxEntries is the largest number of files and folders in an archive
+	MaxEntries int
+	// MaxRatio is the largest ratio of extracted to compressed size of a zip entry
+	MaxRatio int64
+}
+
+// Metadata limits the metadata document of every folder and file
+type Metadata struct {
+	// MaxBytes is the largest size of a document encoded as JSON
+	MaxBytes int
+	// MaxKeys is the largest number of top-level keys of a document
+	MaxKeys int
+}
+
+type Storage struct {
+	// Dir holds the contents of files
+	Dir string
+}
+
+// Naming is the policy applied to the names of folders and files
+type Naming struct {
+	// MaxLength is the maximum number of characters of a name, 0 means unlimited
+	MaxLength int
+	// ForbiddenCharacters may not appear anywhere in a name, control characters are always forbidden
+	ForbiddenCharacters string
+	// ReservedNames may not be used with or without an extension, compared case-insensitively
+	ReservedNames []string
+	// CaseInsensitive rejects names differing from a sibling only in case
+	CaseInsensitive bool
+}
+
+type Logging struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is json or text
+	Format string
+}
+
+type Tracing struct {
+	// Exporter is otlp, stdout or none
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+type Schema struct {
+	// Dir holds the event schemas as <subject>/v<version>.json
+	Dir string
 }
 
 type Policy struct {
-	Users KafkaConfig
+	// Broker is either kafka or memory, memory keeps all messages inside the process
+	Broker string
+	// Consumer is shared by all consumed topics, each topic is configured in its own section
+	Consumer KafkaConfig
+	Users    KafkaConfig
+	Events   KafkaConfig
+	// DeadLetter receives consumed messages whose payload violates its schema or whose handler kept failing
+	DeadLetter KafkaConfig
+}
+
+// applyLegacyConsumer() reads the consumer settings from policy.users, where they were kept before all topics
+// shared policy.consumer. Settings of policy.consumer take precedence.
+func (p *Policy) applyLegacyConsumer() {
+	legacy := []struct {
+		key     string
+		current *string
+		old     string
+	}{
+		{"bootstrapServers", &p.Consumer.BootStrapServers, p.Users.BootStrapServers},
+		{"groupID", &p.Consumer.GroupId, p.Users.GroupId},
+	}
+	for _, setting := range legacy {
+		if *setting.current == "" && setting.old != "" {
+			slog.Warn("policy.users." + setting.key + " is deprecated, use policy.consumer." + setting.key)
+			*setting.current = setting.old
+		}
+	}
+	if p.Consumer.Timeout == 0 {
+		p.Consumer.Timeout = p.Users.Timeout
+	}
 }
 
 type KafkaConfig struct {
@@ -36,6 +158,10 @@ type KafkaConfig struct {
 	BootStrapServers string
 	GroupId          string
 	Timeout          int
+	// Concurrency is the number of lanes per partition, messages with the same key share a lane
+	Concurrency int
+	// BufferSize is the number of messages buffered per partition before fetching is paused
+	BufferSize int
 }
 
 func MustLoad() *Config {
@@ -58,7 +184,7 @@ func Load() (*Config, error) {
 	}
 
 	host := viper.GetString("database.host")
-	log.Printf("host from config: %s", host)
+	slog.Debug("database host from environment", "host", host)
 
 	// if host is empty, use from config.yaml
 	if host == "" {
@@ -74,5 +200,6 @@ func Load() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, err
 	}
+	config.Policy.applyLegacyConsumer()
 	return &config, nil
 }
@@ -0,0 +1,24 @@
+package config
+
+import "testing"
+
+func TestLegacyConsumerSettings(t *testing.T) {
+	policy := Policy{Users: KafkaConfig{Topic: "users", BootStrapServers: "kafka:9092", GroupId: "file_service", Timeout: 1}}
+	policy.applyLegacyConsumer()
+
+	if policy.Consumer.BootStrapServers != "kafka:9092" || policy.Consumer.GroupId != "file_service" || policy.Consumer.Timeout != 1 {
+		t.Fatalf("expected the settings of policy.users, got %+v", policy.Consumer)
+	}
+}
+
+func TestConsumerSettingsWinOverLegacyOnes(t *testing.T) {
+	policy := Policy{
+		Consumer: KafkaConfig{BootStrapServers: "new:9092", GroupId: "new", Timeout: 2},
+		Users:    KafkaConfig{BootStrapServers: "old:9092", GroupId: "old", Timeout: 1},
+	}
+	policy.applyLegacyConsumer()
+
+	if policy.Consumer.BootStrapServers != "new:9092" || policy.Consumer.GroupId != "new" || policy.Consumer.Timeout != 2 {
+		t.Fatalf("expected the settings of policy.consumer, got %+v", policy.Consumer)
+	}
+}
@@ -0,0 +1,132 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/health"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/tracing"
+	"time"
+)
+
+var (
+	logger = logging.For("consumer")
+)
+
+// Message is a broker independent representation of a consumed or produced message
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+// Router decides which topics are consumed and what happens with every message
+type Router interface {
+	Topics() []string
+	Dispatch(msg *Message) error
+}
+
+// Status describes a running consumer for health checks
+type Status struct {
+	// Alive is false once the consumer stopped or did not poll for a while
+	Alive bool
+	// Connected is false once the broker cannot be reached
+	Connected bool
+	// Assigned is the number of partitions currently consumed
+	Assigned int
+}
+
+// failed messages are dispatched again after a backoff doubling up to the maximum
+const (
+	minRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+// Deliver() dispatches the message until the router accepts it, backing off between attempts.
+// It returns false when quit is closed first, the message has then to be left uncommitted.
+func Deliver(router Router, msg *Message, quit <-chan struct{}) bool {
+	backoff := minRetryBackoff
+	for {
+		err := router.Dispatch(msg)
+		if err == nil {
+			return true
+		}
+		logger.Error("failed to handle message, retrying", "topic", msg.Topic, "partition", msg.Partition,
+			"offset", msg.Offset, "backoff", backoff.String(), "error", err)
+
+		select {
+		case <-quit:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+type Consumer interface {
+	Run() error
+	Close()
+	Status() Status
+}
+
+// WithTrace() returns a copy of the message carrying the trace and correlation id of ctx in its headers
+func WithTrace(ctx context.Context, msg *Message) *Message {
+	traced := *msg
+	traced.Headers = make(map[string]string, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		traced.Headers[k] = v
+	}
+	tracing.Inject(ctx, traced.Headers)
+	if correlationID := logging.CorrelationID(ctx); correlationID != "" {
+		traced.Headers[logging.CorrelationIDHeader] = correlationID
+	}
+	return &traced
+}
+
+// LivenessCheck() fails when the consumer is wedged or stopped
+func LivenessCheck(c Consumer) health.Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		status := c.Status()
+		if !status.Alive {
+			return nil, errors.New("consumer is not polling")
+		}
+		return nil, nil
+	}
+}
+
+// ReadinessCheck() fails when the consumer lost its brokers
+func ReadinessCheck(c Consumer) health.Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		status := c.Status()
+		details := map[string]interface{}{"assignedPartitions": status.Assigned}
+		if !status.Connected {
+			return details, errors.New("all brokers are down")
+		}
+		return details, nil
+	}
+}
+
+type Producer interface {
+	// Produce() sends the message with the trace of ctx added to its headers
+	Produce(ctx context.Context, msg *Message) error
+	Close()
+}
+
+// DeadLetterTo() forwards rejected messages unchanged to topic, adding the original topic and the cause as headers
+func DeadLetterTo(p Producer, topic string) func(ctx context.Context, msg *Message, cause error) error {
+	return func(ctx context.Context, msg *Message, cause error) error {
+		headers := make(map[string]string, len(msg.Headers)+2)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers["originalTopic"] = msg.Topic
+		headers["error"] = cause.Error()
+
+		return p.Produce(ctx, &Message{Topic: topic, Key: msg.Key, Value: msg.Value, Headers: headers})
+	}
+}
@@ -0,0 +1,66 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeliverRetriesUntilTheRouterAccepts(t *testing.T) {
+	var attempts int
+	router := &funcRouter{dispatch: func(msg *Message) error {
+		if attempts++; attempts < 3 {
+			return errors.New("database is down")
+		}
+		return nil
+	}}
+
+	if !Deliver(router, &Message{Topic: "users"}, make(chan struct{})) {
+		t.Fatal("expected the message to be delivered")
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestDeliverGivesUpWhenStopped(t *testing.T) {
+	router := &funcRouter{dispatch: func(msg *Message) error {
+		return errors.New("database is down")
+	}}
+
+	quit := make(chan struct{})
+	delivered := make(chan bool)
+	go func() {
+		delivered <- Deliver(router, &Message{Topic: "users"}, quit)
+	}()
+	close(quit)
+
+	select {
+	case ok := <-delivered:
+		if ok {
+			t.Fatal("a failing message was reported as delivered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deliver() kept retrying after quit")
+	}
+}
+
+func TestMemoryConsumerRetriesFailedMessages(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	var attempts int
+	done := make(chan struct{})
+	runConsumer(t, broker.NewConsumer(&funcRouter{topics: []string{"users"}, dispatch: func(msg *Message) error {
+		if attempts++; attempts == 1 {
+			return errors.New("database is down")
+		}
+		close(done)
+		return nil
+	}}))
+
+	if err := broker.Producer().Produce(context.Background(), &Message{Topic: "users"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, done)
+}
@@ -0,0 +1,300 @@
+package kafka
+
+import (
+	"fmt"
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	logger = logging.For("consumer.kafka")
+)
+
+type kafkaConsumer struct {
+	consumer   *confluent.Consumer
+	router     consumer.Router
+	workerPool map[string]*worker
+	// rebalancing holds back the workers while partitions are assigned or handed over
+	rebalancing sync.RWMutex
+
+	// lanes per partition and messages buffered per partition before it is paused
+	concurrency int
+	bufferSize  int
+
+	// stopping asks Run() to return, stopped is closed once it did
+	running   int32
+	stopping  chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	// observed by health checks from other goroutines
+	lastPoll    int64
+	assigned    int32
+	brokersDown int32
+}
+
+// a consumer that did not poll for this long is considered wedged
+const maxPollInterval = 30 * time.Second
+
+func (c *kafkaConsumer) Status() consumer.Status {
+	lastPoll := time.Unix(0, atomic.LoadInt64(&c.lastPoll))
+
+	var stopped bool
+	select {
+	case <-c.stopped:
+		stopped = true
+	default:
+	}
+
+	return consumer.Status{
+		Alive:     atomic.LoadInt32(&c.running) == 1 && !stopped && time.Since(lastPoll) < maxPollInterval,
+		Connected: atomic.LoadInt32(&c.brokersDown) == 0,
+		Assigned:  int(atomic.LoadInt32(&c.assigned)),
+	}
+}
+
+// Close() stops polling, lets the workers finish their current message, commits the processed offsets
+// and leaves the group
+func (c *kafkaConsumer) Close() {
+	c.closeOnce.Do(func() {
+		logger.Info("closing Kafka consumer")
+
+		close(c.stopping)
+		if atomic.LoadInt32(&c.running) == 1 {
+			<-c.stopped
+		}
+
+		c.stopWorkers()
+		c.commit()
+
+		// unasign partitions
+		c.consumer.Unassign()
+
+		c.consumer.Close()
+		logger.Info("Kafka consumer closed")
+	})
+}
+
+// NewConsumer() subscribes to every topic of the router and dispatches the messages through it.
+// Each partition is processed by concurrency lanes keyed by message key, with up to bufferSize messages buffered.
+func NewConsumer(bootstrapServers string, groupId string, concurrency int, bufferSize int, router consumer.Router) (consumer.Consumer, error) {
+	client, err := confluent.NewConsumer(&confluent.ConfigMap{
+		"bootstrap.servers":               bootstrapServers,
+		"group.id":                        groupId,
+		"auto.offset.reset":               "earliest",
+		"go.application.rebalance.enable": true,
+		// offsets are stored by the workers once a message has been processed
+		"enable.auto.offset.store": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_kafkaConsumer := &kafkaConsumer{
+		consumer:    client,
+		router:      router,
+		workerPool:  make(map[string]*worker),
+		concurrency: concurrency,
+		bufferSize:  bufferSize,
+		stopping:    make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	if err := client.SubscribeTopics(router.Topics(), rebalanceCb(_kafkaConsumer)); err != nil {
+		return nil, err
+	}
+
+	return _kafkaConsumer, nil
+}
+
+func rebalanceCb(svcConsumer *kafkaConsumer) confluent.RebalanceCb {
+	logger.Debug("Kafka consumer rebalance callback")
+
+	return func(c *confluent.Consumer, e confluent.Event) error {
+		switch ev := e.(type) {
+		case confluent.AssignedPartitions:
+			svcConsumer.rebalancing.Lock()
+			// make workers for each partition
+			for _, partition := range ev.Partitions {
+				id := workerId(partition)
+				worker := newWorker(id, svcConsumer.router, &svcConsumer.rebalancing, svcConsumer.concurrency, svcConsumer.bufferSize,
+					svcConsumer.pauseFn(partition), svcConsumer.resumeFn(partition), svcConsumer.storeFn(partition))
+				worker.start()
+				svcConsumer.workerPool[id] = worker
+			}
+
+			c.Assign(ev.Partitions)
+			atomic.AddInt32(&svcConsumer.assigned, int32(len(ev.Partitions)))
+			svcConsumer.brokersUp()
+			logger.Info("Kafka consumer assigned partitions", "partitions", fmt.Sprint(ev.Partitions))
+			svcConsumer.rebalancing.Unlock()
+		case confluent.RevokedPartitions:
+			logger.Info("Kafka consumer revoked partitions", "partitions", fmt.Sprint(ev.Partitions))
+
+			ids := make([]string, len(ev.Partitions))
+			for i, partition := range ev.Partitions {
+				ids[i] = workerId(partition)
+			}
+
+			// hand over the partitions with everything processed so far committed
+			svcConsumer.revoke(ids, func() {
+				svcConsumer.commit()
+				c.Unassign()
+			})
+			atomic.AddInt32(&svcConsumer.assigned, -int32(len(ev.Partitions)))
+		}
+
+		return nil
+	}
+}
+
+// revoke() stops the workers of the partitions after their current message and then hands them over.
+// The workers are stopped before the rebalance lock is taken, lanes waiting for it would never finish otherwise.
+func (c *kafkaConsumer) revoke(ids []string, handOver func()) {
+	for _, id := range ids {
+		if worker := c.workerPool[id]; worker != nil {
+			worker.stop()
+			<-worker.done
+			delete(c.workerPool, id)
+		}
+	}
+
+	c.rebalancing.Lock()
+	defer c.rebalancing.Unlock()
+	handOver()
+}
+
+func (c *kafkaConsumer) Run() error {
+	atomic.StoreInt32(&c.running, 1)
+	defer close(c.stopped)
+
+	for {
+		select {
+		case <-c.stopping:
+			return nil
+		default:
+		}
+
+		atomic.StoreInt64(&c.lastPoll, time.Now().UnixNano())
+		ev := c.consumer.Poll(100)
+		if ev == nil {
+			continue
+		}
+
+		switch e := ev.(type) {
+		case *confluent.Message:
+			c.brokersUp()
+			if c.router != nil {
+				// Find the worker for this message's partition
+				id := workerId(e.TopicPartition)
+				worker := c.workerPool[id]
+
+				if worker != nil {
+					worker.dispatch(fromKafkaMessage(e))
+					continue
+				}
+
+				// the message stays uncommitted, the consumer assigned the partition next reads it again
+				logger.Warn("no worker found for partition, leaving message uncommitted", "partition", id,
+					"offset", int64(e.TopicPartition.Offset))
+			}
+		case confluent.PartitionEOF:
+			continue
+		case confluent.Error:
+			logger.Error("consumer error", "error", e.Error(), "code", e.Code().String())
+			// the client reconnects by itself, the service is only not ready meanwhile
+			if e.Code() == confluent.ErrAllBrokersDown {
+				atomic.StoreInt32(&c.brokersDown, 1)
+			}
+		default:
+			logger.Debug("ignored event", "event", e.String())
+		}
+	}
+}
+
+// brokersUp() marks the brokers reachable again once messages or assignments arrive
+func (c *kafkaConsumer) brokersUp() {
+	if atomic.CompareAndSwapInt32(&c.brokersDown, 1, 0) {
+		logger.Info("Kafka brokers reachable again")
+	}
+}
+
+// pauseFn() stops fetching from the partition while its worker is full
+func (c *kafkaConsumer) pauseFn(partition confluent.TopicPartition) func() {
+	return func() {
+		if err := c.consumer.Pause([]confluent.TopicPartition{partition}); err != nil {
+			logger.Error("failed to pause partition", "partition", workerId(partition), "error", err)
+		}
+	}
+}
+
+// resumeFn() continues fetching from the partition once its worker drained
+func (c *kafkaConsumer) resumeFn(partition confluent.TopicPartition) func() {
+	return func() {
+		if err := c.consumer.Resume([]confluent.TopicPartition{partition}); err != nil {
+			logger.Error("failed to resume partition", "partition", workerId(partition), "error", err)
+		}
+	}
+}
+
+// storeFn() records the offset to commit for the partition
+func (c *kafkaConsumer) storeFn(partition confluent.TopicPartition) func(offset int64) {
+	return func(offset int64) {
+		tp := partition
+		tp.Offset = confluent.Offset(offset)
+		if _, err := c.consumer.StoreOffsets([]confluent.TopicPartition{tp}); err != nil {
+			logger.Error("failed to store offset", "partition", workerId(partition), "offset", offset, "error", err)
+		}
+	}
+}
+
+// commit() synchronously commits the stored offsets
+func (c *kafkaConsumer) commit() {
+	if _, err := c.consumer.Commit(); err != nil {
+		if kafkaErr, ok := err.(confluent.Error); ok && kafkaErr.Code() == confluent.ErrNoOffset {
+			return
+		}
+		logger.Error("failed to commit offsets", "error", err)
+	}
+}
+
+// workerId() identifies a partition across all subscribed topics
+func workerId(tp confluent.TopicPartition) string {
+	var topic string
+	if tp.Topic != nil {
+		topic = *tp.Topic
+	}
+	return fmt.Sprintf("%s[%d]", topic, tp.Partition)
+}
+
+func fromKafkaMessage(msg *confluent.Message) *consumer.Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	var topic string
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+
+	return &consumer.Message{
+		Topic:     topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+	}
+}
+
+func (c *kafkaConsumer) stopWorkers() {
+	for _, w := range c.workerPool {
+		w.stop()
+		<-w.done
+	}
+}
@@ -0,0 +1,19 @@
+package kafka
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestBrokersUpMakesTheConsumerReadyAgain(t *testing.T) {
+	c := &kafkaConsumer{stopping: make(chan struct{}), stopped: make(chan struct{})}
+	atomic.StoreInt32(&c.brokersDown, 1)
+	if c.Status().Connected {
+		t.Fatal("expected the consumer to be disconnected while the brokers are down")
+	}
+
+	c.brokersUp()
+	if !c.Status().Connected {
+		t.Fatal("expected the consumer to be connected once the brokers are back")
+	}
+}
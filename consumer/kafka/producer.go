@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/potatowhite/books/file-service/consumer"
+)
+
+type kafkaProducer struct {
+	producer *confluent.Producer
+	timeout  int
+}
+
+func NewProducer(bootstrapServers string, timeout int) (consumer.Producer, error) {
+	producer, err := confluent.NewProducer(&confluent.ConfigMap{
+		"bootstrap.servers": bootstrapServers,
+		"acks":              "all",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaProducer{producer: producer, timeout: timeout}, nil
+}
+
+// Produce() sends a message and waits for the broker to acknowledge it
+func (p *kafkaProducer) Produce(ctx context.Context, msg *consumer.Message) error {
+	deliveryChan := make(chan confluent.Event, 1)
+
+	if err := p.producer.Produce(toKafkaMessage(consumer.WithTrace(ctx, msg)), deliveryChan); err != nil {
+		return err
+	}
+
+	ev := <-deliveryChan
+	delivered, ok := ev.(*confluent.Message)
+	if !ok {
+		return fmt.Errorf("unexpected delivery event: %v", ev)
+	}
+
+	return delivered.TopicPartition.Error
+}
+
+func (p *kafkaProducer) Close() {
+	logger.Info("closing Kafka producer")
+
+	// wait for outstanding messages before closing
+	p.producer.Flush(p.timeout * 1000)
+	p.producer.Close()
+}
+
+func toKafkaMessage(msg *consumer.Message) *confluent.Message {
+	headers := make([]confluent.Header, 0, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers = append(headers, confluent.Header{Key: k, Value: []byte(v)})
+	}
+
+	topic := msg.Topic
+	return &confluent.Message{
+		TopicPartition: confluent.TopicPartition{Topic: &topic, Partition: confluent.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/metrics"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultConcurrency = 4
+	defaultBufferSize  = 100
+)
+
+// worker processes the messages of one partition.
+// Messages are spread over lanes by key, so messages of the same key are still handled in order
+// while a slow key only blocks its own lane.
+type worker struct {
+	id     string
+	router consumer.Router
+	lanes  []chan *consumer.Message
+
+	// pause and resume are called when the buffered messages of the partition reach capacity and when they drained
+	mu       sync.Mutex
+	pending  int
+	capacity int
+	paused   bool
+	pause    func()
+	resume   func()
+
+	// inflight holds the offsets in dispatch order, store receives the offset to commit
+	// once every message before it has been processed. Buffered messages are only safe because
+	// the consumer disables the automatic offset store, which would commit them on fetch.
+	inflight  []int64
+	processed map[int64]bool
+	store     func(offset int64)
+
+	// rebalancing is held by the consumer while it assigns or hands over partitions
+	rebalancing *sync.RWMutex
+
+	lanesWg  sync.WaitGroup
+	quit     chan struct{}
+	done     chan bool
+	stopOnce sync.Once
+}
+
+func (w *worker) start() {
+	for _, lane := range w.lanes {
+		w.lanesWg.Add(1)
+		go func(lane chan *consumer.Message) {
+			defer w.lanesWg.Done()
+			for msg := range lane {
+				// wait for a rebalance in progress, which may stop this worker
+				w.rebalancing.RLock()
+				w.rebalancing.RUnlock()
+
+				// after stop the buffered messages and a failing one are left uncommitted, they are redelivered later
+				if w.stopped() || !consumer.Deliver(w.router, msg, w.quit) {
+					continue
+				}
+				metrics.ConsumedMessages.WithLabelValues(msg.Topic, strconv.Itoa(int(msg.Partition))).Inc()
+				w.release(msg.Offset)
+			}
+		}(lane)
+	}
+
+	go func() {
+		w.lanesWg.Wait()
+		close(w.done)
+	}()
+
+	logger.Info("worker started", "worker", w.id, "lanes", len(w.lanes))
+}
+
+// dispatch() queues the message on the lane of its key, pausing the partition when the buffer is full
+func (w *worker) dispatch(msg *consumer.Message) {
+	w.mu.Lock()
+	w.pending++
+	metrics.WorkerQueueDepth.WithLabelValues(w.id).Set(float64(w.pending))
+	w.inflight = append(w.inflight, msg.Offset)
+	if w.pending >= w.capacity && !w.paused {
+		w.paused = true
+		w.pause()
+		logger.Warn("worker paused", "worker", w.id, "pending", w.pending)
+	}
+	w.mu.Unlock()
+
+	w.lanes[laneOf(msg.Key, len(w.lanes))] <- msg
+}
+
+// release() marks a message as processed, stores the committable offset
+// and resumes the partition once half of the buffer drained
+func (w *worker) release(offset int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending--
+	metrics.WorkerQueueDepth.WithLabelValues(w.id).Set(float64(w.pending))
+	w.processed[offset] = true
+
+	// the committed offset is the one after the last message processed without gaps
+	committable := int64(-1)
+	for len(w.inflight) > 0 && w.processed[w.inflight[0]] {
+		committable = w.inflight[0] + 1
+		delete(w.processed, w.inflight[0])
+		w.inflight = w.inflight[1:]
+	}
+	if committable >= 0 {
+		w.store(committable)
+	}
+
+	if w.paused && w.pending <= w.capacity/2 {
+		w.paused = false
+		w.resume()
+		logger.Info("worker resumed", "worker", w.id, "pending", w.pending)
+	}
+}
+
+func (w *worker) stopped() bool {
+	select {
+	case <-w.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop() lets every lane finish its current message and drops the buffered ones
+func (w *worker) stop() {
+	// close if not closed
+	w.stopOnce.Do(func() {
+		close(w.quit)
+		for _, lane := range w.lanes {
+			close(lane)
+		}
+		metrics.WorkerQueueDepth.DeleteLabelValues(w.id)
+		logger.Info("worker stopped", "worker", w.id)
+	})
+}
+
+func laneOf(key []byte, lanes int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(lanes))
+}
+
+func newWorker(id string, router consumer.Router, rebalancing *sync.RWMutex, concurrency int, bufferSize int, pause func(), resume func(), store func(offset int64)) *worker {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	// every lane can hold the whole buffer, so dispatching does not block before the partition is paused
+	lanes := make([]chan *consumer.Message, concurrency)
+	for i := range lanes {
+		lanes[i] = make(chan *consumer.Message, bufferSize)
+	}
+
+	return &worker{
+		id:          id,
+		router:      router,
+		rebalancing: rebalancing,
+		lanes:       lanes,
+		capacity:    bufferSize,
+		pause:       pause,
+		resume:      resume,
+		processed:   make(map[int64]bool),
+		store:       store,
+		quit:        make(chan struct{}),
+		done:        make(chan bool),
+	}
+}
@@ -0,0 +1,335 @@
+package kafka
+
+import (
+	"errors"
+	"github.com/potatowhite/books/file-service/consumer"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedRouter holds every message until its offset is released
+type gatedRouter struct {
+	mu      sync.Mutex
+	gates   map[int64]chan struct{}
+	handled []*consumer.Message
+	entered chan int64
+}
+
+func newGatedRouter() *gatedRouter {
+	return &gatedRouter{gates: make(map[int64]chan struct{}), entered: make(chan int64, 1000)}
+}
+
+func (r *gatedRouter) gate(offset int64) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gates[offset] == nil {
+		r.gates[offset] = make(chan struct{})
+	}
+	return r.gates[offset]
+}
+
+// release() lets the message of offset finish
+func (r *gatedRouter) release(offset int64) {
+	close(r.gate(offset))
+}
+
+func (r *gatedRouter) Topics() []string {
+	return []string{"users"}
+}
+
+func (r *gatedRouter) Dispatch(msg *consumer.Message) error {
+	r.entered <- msg.Offset
+	<-r.gate(msg.Offset)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handled = append(r.handled, msg)
+	return nil
+}
+
+// recorder collects the calls a worker makes to its partition
+type recorder struct {
+	mu      sync.Mutex
+	pauses  int
+	resumes int
+	stored  []int64
+}
+
+func (r *recorder) pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pauses++
+}
+
+func (r *recorder) resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resumes++
+}
+
+func (r *recorder) store(offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stored = append(r.stored, offset)
+}
+
+func (r *recorder) snapshot() (pauses int, resumes int, stored []int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pauses, r.resumes, append([]int64(nil), r.stored...)
+}
+
+func startWorker(t *testing.T, router consumer.Router, concurrency int, bufferSize int, rec *recorder) *worker {
+	t.Helper()
+	w := newWorker("users[0]", router, &sync.RWMutex{}, concurrency, bufferSize, rec.pause, rec.resume, rec.store)
+	w.start()
+	t.Cleanup(func() {
+		w.stop()
+		<-w.done
+	})
+	return w
+}
+
+func message(offset int64, key string) *consumer.Message {
+	return &consumer.Message{Topic: "users", Offset: offset, Key: []byte(key)}
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWorkerKeepsTheOrderOfEachKey(t *testing.T) {
+	router := newGatedRouter()
+	rec := &recorder{}
+	w := startWorker(t, router, 4, 1000, rec)
+
+	const count = 300
+	for offset := int64(0); offset < count; offset++ {
+		router.release(offset)
+		w.dispatch(message(offset, strconv.Itoa(int(offset%7))))
+	}
+	eventually(t, func() bool {
+		_, _, stored := rec.snapshot()
+		return len(stored) > 0 && stored[len(stored)-1] == count
+	})
+
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	last := make(map[string]int64)
+	for _, msg := range router.handled {
+		if previous, ok := last[string(msg.Key)]; ok && previous > msg.Offset {
+			t.Fatalf("key %s handled offset %d after %d", msg.Key, msg.Offset, previous)
+		}
+		last[string(msg.Key)] = msg.Offset
+	}
+}
+
+func TestWorkerStoresOnlyContiguousOffsets(t *testing.T) {
+	router := newGatedRouter()
+	rec := &recorder{}
+	w := startWorker(t, router, 4, 100, rec)
+
+	// keys on different lanes, so the later messages can finish first
+	keys := distinctLaneKeys(3, 4)
+	for i, key := range keys {
+		w.dispatch(message(int64(10+i), key))
+	}
+	for i := 0; i < len(keys); i++ {
+		<-router.entered
+	}
+
+	router.release(11)
+	router.release(12)
+	eventually(t, func() bool {
+		router.mu.Lock()
+		defer router.mu.Unlock()
+		return len(router.handled) == 2
+	})
+	if _, _, stored := rec.snapshot(); len(stored) != 0 {
+		t.Fatalf("stored %v while offset 10 is still being processed", stored)
+	}
+
+	router.release(10)
+	eventually(t, func() bool {
+		_, _, stored := rec.snapshot()
+		return len(stored) == 1
+	})
+	if _, _, stored := rec.snapshot(); stored[0] != 13 {
+		t.Fatalf("expected the offset after the last processed message, got %v", stored)
+	}
+}
+
+func TestWorkerPausesWhenFullAndResumesAtHalf(t *testing.T) {
+	router := newGatedRouter()
+	rec := &recorder{}
+	w := startWorker(t, router, 1, 4, rec)
+
+	for offset := int64(0); offset < 3; offset++ {
+		w.dispatch(message(offset, "a"))
+	}
+	if pauses, _, _ := rec.snapshot(); pauses != 0 {
+		t.Fatalf("paused below capacity")
+	}
+	w.dispatch(message(3, "a"))
+	if pauses, _, _ := rec.snapshot(); pauses != 1 {
+		t.Fatalf("expected a pause at capacity, got %d", pauses)
+	}
+
+	// 4 pending, resuming once no more than half of the buffer is left
+	router.release(0)
+	eventually(t, func() bool {
+		_, _, stored := rec.snapshot()
+		return len(stored) == 1
+	})
+	if _, resumes, _ := rec.snapshot(); resumes != 0 {
+		t.Fatalf("resumed with 3 messages pending")
+	}
+
+	router.release(1)
+	eventually(t, func() bool {
+		_, resumes, _ := rec.snapshot()
+		return resumes == 1
+	})
+	router.release(2)
+	router.release(3)
+	eventually(t, func() bool {
+		_, _, stored := rec.snapshot()
+		return len(stored) == 4
+	})
+	if pauses, resumes, _ := rec.snapshot(); pauses != 1 || resumes != 1 {
+		t.Fatalf("expected one pause and one resume, got %d and %d", pauses, resumes)
+	}
+}
+
+// distinctLaneKeys() returns count keys that fall on different lanes
+func distinctLaneKeys(count int, lanes int) []string {
+	var keys []string
+	used := make(map[int]bool)
+	for i := 0; len(keys) < count; i++ {
+		key := strconv.Itoa(i)
+		if lane := laneOf([]byte(key), lanes); !used[lane] {
+			used[lane] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestRevokeWaitsForTheMessageOfALane(t *testing.T) {
+	router := newGatedRouter()
+	rec := &recorder{}
+	c := &kafkaConsumer{workerPool: make(map[string]*worker)}
+	w := newWorker("users[0]", router, &c.rebalancing, 1, 100, rec.pause, rec.resume, rec.store)
+	w.start()
+	c.workerPool[w.id] = w
+
+	// the lane holds offset 0, offset 1 is buffered behind it
+	w.dispatch(message(0, "a"))
+	w.dispatch(message(1, "a"))
+	<-router.entered
+
+	revoked := make(chan struct{})
+	handedOver := make(chan struct{})
+	go func() {
+		c.revoke([]string{w.id}, func() { close(handedOver) })
+		close(revoked)
+	}()
+
+	select {
+	case <-revoked:
+		t.Fatal("revoked while a message was being processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	router.release(0)
+	select {
+	case <-revoked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("revoke did not finish after the message was processed")
+	}
+	<-handedOver
+
+	if _, _, stored := rec.snapshot(); len(stored) != 1 || stored[0] != 1 {
+		t.Fatalf("expected only offset 0 to be committed, got %v", stored)
+	}
+	if len(router.entered) != 0 {
+		t.Fatal("a buffered message was dispatched after the revoke")
+	}
+	if len(c.workerPool) != 0 {
+		t.Fatal("the worker of the revoked partition was kept")
+	}
+}
+
+func TestRevokeWhileALaneWaitsForARebalance(t *testing.T) {
+	router := newGatedRouter()
+	rec := &recorder{}
+	c := &kafkaConsumer{workerPool: make(map[string]*worker)}
+	w := newWorker("users[0]", router, &c.rebalancing, 1, 100, rec.pause, rec.resume, rec.store)
+	w.start()
+	c.workerPool[w.id] = w
+
+	// an assignment is in progress, the lane waits with its message for it to finish
+	c.rebalancing.Lock()
+	w.dispatch(message(0, "a"))
+
+	revoked := make(chan struct{})
+	go func() {
+		c.revoke([]string{w.id}, func() {})
+		close(revoked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.rebalancing.Unlock()
+
+	select {
+	case <-revoked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("revoke and the waiting lane deadlocked")
+	}
+	if len(router.entered) != 0 {
+		t.Fatal("the lane dispatched a message of a revoked partition")
+	}
+	if _, _, stored := rec.snapshot(); len(stored) != 0 {
+		t.Fatalf("committed %v for an unprocessed message", stored)
+	}
+}
+
+func TestWorkerLeavesFailedMessagesUncommitted(t *testing.T) {
+	rec := &recorder{}
+	failing := make(chan struct{}, 100)
+	router := &failingRouter{failed: failing}
+	w := newWorker("users[0]", router, &sync.RWMutex{}, 1, 100, rec.pause, rec.resume, rec.store)
+	w.start()
+
+	w.dispatch(message(0, "a"))
+	<-failing
+	<-failing
+
+	w.stop()
+	<-w.done
+	if _, _, stored := rec.snapshot(); len(stored) != 0 {
+		t.Fatalf("committed %v for a message that never succeeded", stored)
+	}
+}
+
+// failingRouter fails every message, as a handler would while its database is down
+type failingRouter struct {
+	failed chan struct{}
+}
+
+func (r *failingRouter) Topics() []string {
+	return []string{"users"}
+}
+
+func (r *failingRouter) Dispatch(msg *consumer.Message) error {
+	r.failed <- struct{}{}
+	return errors.New("database is down")
+}
@@ -0,0 +1,183 @@
+package consumer
+
+import (
+	"context"
+	"github.com/potatowhite/books/file-service/metrics"
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryBroker is an in-process broker for tests and local development.
+// Every topic has a single partition, messages are delivered in order to every subscribed consumer.
+// Publishing never blocks, so handlers may publish to the topics they consume.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	offsets   map[string]int64
+	consumers []*memoryConsumer
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{offsets: make(map[string]int64)}
+}
+
+// Producer() returns a producer publishing to this broker
+func (b *MemoryBroker) Producer() Producer {
+	return &memoryProducer{broker: b}
+}
+
+// NewConsumer() returns a consumer receiving every message published to the topics of the router
+func (b *MemoryBroker) NewConsumer(router Router) Consumer {
+	c := &memoryConsumer{
+		broker:  b,
+		router:  router,
+		topics:  make(map[string]bool),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, topic := range router.Topics() {
+		c.topics[topic] = true
+	}
+
+	b.mu.Lock()
+	b.consumers = append(b.consumers, c)
+	b.mu.Unlock()
+
+	return c
+}
+
+func (b *MemoryBroker) publish(msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// copy so that consumers cannot observe later changes of the caller
+	published := *msg
+	published.Offset = b.offsets[msg.Topic]
+	b.offsets[msg.Topic]++
+
+	for _, c := range b.consumers {
+		if c.topics[msg.Topic] {
+			delivered := published
+			c.enqueue(&delivered)
+		}
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) unsubscribe(c *memoryConsumer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, subscribed := range b.consumers {
+		if subscribed == c {
+			b.consumers = append(b.consumers[:i], b.consumers[i+1:]...)
+			return
+		}
+	}
+}
+
+type memoryProducer struct {
+	broker *MemoryBroker
+}
+
+func (p *memoryProducer) Produce(ctx context.Context, msg *Message) error {
+	return p.broker.publish(WithTrace(ctx, msg))
+}
+
+func (p *memoryProducer) Close() {}
+
+type memoryConsumer struct {
+	broker *MemoryBroker
+	router Router
+	topics map[string]bool
+
+	// queue is unbounded, notify tells Run() that it is not empty
+	mu     sync.Mutex
+	queue  []*Message
+	notify chan struct{}
+
+	done      chan struct{}
+	running   int32
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *memoryConsumer) Status() Status {
+	var stopped bool
+	select {
+	case <-c.stopped:
+		stopped = true
+	default:
+	}
+
+	return Status{
+		Alive:     atomic.LoadInt32(&c.running) == 1 && !stopped,
+		Connected: true,
+		Assigned:  len(c.topics),
+	}
+}
+
+func (c *memoryConsumer) enqueue(msg *Message) {
+	c.mu.Lock()
+	c.queue = append(c.queue, msg)
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next() takes the oldest queued message, or returns nil when the queue is empty
+func (c *memoryConsumer) next() *Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.queue) == 0 {
+		return nil
+	}
+	msg := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	return msg
+}
+
+// Run() dispatches messages until the consumer is closed
+func (c *memoryConsumer) Run() error {
+	atomic.StoreInt32(&c.running, 1)
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case <-c.notify:
+		}
+
+		for msg := c.next(); msg != nil; msg = c.next() {
+			if !Deliver(c.router, msg, c.done) {
+				return nil
+			}
+			metrics.ConsumedMessages.WithLabelValues(msg.Topic, "0").Inc()
+
+			select {
+			case <-c.done:
+				return nil
+			default:
+			}
+		}
+	}
+}
+
+func (c *memoryConsumer) Close() {
+	c.closeOnce.Do(func() {
+		logger.Info("closing memory consumer")
+		c.broker.unsubscribe(c)
+		close(c.done)
+
+		// wait for the message being handled
+		if atomic.LoadInt32(&c.running) == 1 {
+			<-c.stopped
+		}
+	})
+}
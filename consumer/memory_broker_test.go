@@ -0,0 +1,128 @@
+package consumer
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// funcRouter dispatches every message of its topics to a function
+type funcRouter struct {
+	topics   []string
+	dispatch func(msg *Message) error
+}
+
+func (r *funcRouter) Topics() []string {
+	return r.topics
+}
+
+func (r *funcRouter) Dispatch(msg *Message) error {
+	return r.dispatch(msg)
+}
+
+// runConsumer() runs the consumer until the test ends
+func runConsumer(t *testing.T, c Consumer) {
+	t.Helper()
+	go c.Run()
+	t.Cleanup(c.Close)
+}
+
+func waitFor(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for messages")
+	}
+}
+
+func TestMemoryBrokerDeliversInOrder(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	var received []int64
+	done := make(chan struct{})
+	runConsumer(t, broker.NewConsumer(&funcRouter{topics: []string{"users"}, dispatch: func(msg *Message) error {
+		received = append(received, msg.Offset)
+		if len(received) == 3 {
+			close(done)
+		}
+		return nil
+	}}))
+
+	for i := 0; i < 3; i++ {
+		if err := broker.Producer().Produce(context.Background(), &Message{Topic: "users", Key: []byte("1")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a topic nobody consumes is dropped
+	if err := broker.Producer().Produce(context.Background(), &Message{Topic: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, done)
+	for i, offset := range received {
+		if offset != int64(i) {
+			t.Fatalf("expected offsets 0, 1, 2, got %v", received)
+		}
+	}
+}
+
+func TestMemoryBrokerHandlerPublishesToItsOwnTopic(t *testing.T) {
+	broker := NewMemoryBroker()
+	producer := broker.Producer()
+
+	// far more messages than any channel buffer, published from inside the handler
+	const fanOut = 5000
+	var mu sync.Mutex
+	var received int
+	done := make(chan struct{})
+	runConsumer(t, broker.NewConsumer(&funcRouter{topics: []string{"events"}, dispatch: func(msg *Message) error {
+		if msg.Headers["fanOut"] == "true" {
+			for i := 0; i < fanOut; i++ {
+				if err := producer.Produce(context.Background(), &Message{Topic: "events", Value: []byte(strconv.Itoa(i))}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if received++; received == fanOut {
+			close(done)
+		}
+		return nil
+	}}))
+
+	if err := producer.Produce(context.Background(), &Message{Topic: "events", Headers: map[string]string{"fanOut": "true"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, done)
+}
+
+func TestMemoryBrokerCopiesHeaders(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	received := make(chan *Message, 1)
+	runConsumer(t, broker.NewConsumer(&funcRouter{topics: []string{"users"}, dispatch: func(msg *Message) error {
+		received <- msg
+		return nil
+	}}))
+
+	headers := map[string]string{"eventType": "UserCreatedEvent"}
+	if err := broker.Producer().Produce(context.Background(), &Message{Topic: "users", Headers: headers}); err != nil {
+		t.Fatal(err)
+	}
+	headers["eventType"] = "changed"
+
+	select {
+	case msg := <-received:
+		if msg.Headers["eventType"] != "UserCreatedEvent" {
+			t.Fatalf("expected the published headers, got %v", msg.Headers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+}
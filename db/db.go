@@ -1,47 +1,111 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"github.com/potatowhite/books/file-service/config"
-	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/health"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/metrics"
+	"github.com/potatowhite/books/file-service/tracing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"log"
 )
 
+var (
+	logger = logging.For("db")
+)
+
+// InitDB() connects to the database and refuses to start the service against a schema that is not migrated
 func InitDB(cfg *config.Config) (*gorm.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	dsn := "host=%s port=%v user=%s dbname=%s password=%s sslmode=disable"
-	dsn = fmt.Sprintf(dsn, cfg.Database.Host, cfg.Database.Port, cfg.Database.Username, cfg.Database.Dbname, cfg.Database.Password)
+	if err := CheckMigrated(context.Background(), db); err != nil {
+		CloseDB(db)
+		return nil, err
+	}
 
-	log.Printf("dsn: %s", dsn)
+	return db, nil
+}
+
+// Open() connects to the database without checking its schema
+func Open(cfg *config.Config) (*gorm.DB, error) {
+	// server side backstop for statements that outlive the timeout of their context
+	return open(cfg, cfg.Database.Timeout.Default)
+}
+
+// OpenForMigration() connects to the database without a statement timeout,
+// index builds and waiting for the migration lock take as long as they take
+func OpenForMigration(cfg *config.Config) (*gorm.DB, error) {
+	return open(cfg, 0)
+}
+
+// open() connects with statementTimeout milliseconds as the statement timeout of every session, 0 disables it
+func open(cfg *config.Config, statementTimeout int) (*gorm.DB, error) {
+	dsn := "host=%s port=%v user=%s dbname=%s password=%s sslmode=disable statement_timeout=%d"
+	dsn = fmt.Sprintf(dsn, cfg.Database.Host, cfg.Database.Port, cfg.Database.Username, cfg.Database.Dbname, cfg.Database.Password, statementTimeout)
+
+	// the password is redacted by the logger
+	logger.Info("connecting to database", "dsn", dsn)
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
-	// auto migrate
-	autoMigration(err, db)
+	if err := instrument(db, cfg.Database.Dbname); err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
 
+// instrument() records query durations, pool statistics and a span per query
+func instrument(db *gorm.DB, dbName string) error {
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		return err
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		return err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	return metrics.RegisterDBStats(sqlDB, dbName)
+}
+
+// HealthCheck() pings the connection pool and reports its usage
+func HealthCheck(database *gorm.DB) health.Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		sqlDB, err := database.DB()
+		if err != nil {
+			return nil, err
+		}
+
+		stats := sqlDB.Stats()
+		details := map[string]interface{}{
+			"openConnections": stats.OpenConnections,
+			"inUse":           stats.InUse,
+			"idle":            stats.Idle,
+		}
+
+		return details, sqlDB.PingContext(ctx)
+	}
+}
+
 func CloseDB(database *gorm.DB) {
 	func(con *gorm.DB) {
 		sqlDB, err := con.DB()
 		if err != nil {
-			log.Fatal(err)
+			logger.Error("failed to get database pool", "error", err)
+			return
 		}
 		sqlDB.Close()
 	}(database)
 }
-
-func autoMigration(err error, db *gorm.DB) error {
-	db.Migrator().DropTable(&entity.Folder{}, &entity.File{})
-	err = db.AutoMigrate(&entity.Folder{}, &entity.File{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrations are stored as migrations/<version>_<name>.up.sql with an optional matching .down.sql.
+// Applied migrations are never edited, changes to the schema always go into a new version.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFile = regexp.MustCompile(`^([0-9]+)_(.+)\.(up|down)\.sql$`)
+
+// migrationLock is the key of the advisory lock held while migrating, so concurrent instances migrate one at a time
+const migrationLock = 7318450123
+
+// Migration is one version of the schema
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+
+	up   string
+	down string
+}
+
+// Migrations() returns every known migration in version order
+func Migrations() ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrateUp() applies every pending migration, each in its own transaction
+func MigrateUp(ctx context.Context, database *gorm.DB) error {
+	return withMigrationLock(ctx, database, func(conn *sql.Conn, migrations []*Migration) error {
+		for _, migration := range migrations {
+			if migration.AppliedAt != nil {
+				continue
+			}
+
+			logger.Info("applying migration", "version", migration.Version, "name", migration.Name)
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+					migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown() reverts the most recently applied migration
+func MigrateDown(ctx context.Context, database *gorm.DB) error {
+	return withMigrationLock(ctx, database, func(conn *sql.Conn, migrations []*Migration) error {
+		var last *Migration
+		for _, migration := range migrations {
+			if migration.AppliedAt != nil {
+				last = migration
+			}
+		}
+		if last == nil {
+			logger.Info("no migration to revert")
+			return nil
+		}
+		if last.down == "" {
+			return fmt.Errorf("migration %d_%s cannot be reverted, it has no down script", last.Version, last.Name)
+		}
+
+		logger.Info("reverting migration", "version", last.Version, "name", last.Name)
+		err := inTx(ctx, conn, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, last.down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", last.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revert migration %d_%s: %w", last.Version, last.Name, err)
+		}
+		return nil
+	})
+}
+
+// MigrationStatus() returns every known migration with the time it was applied, if it was
+func MigrationStatus(ctx context.Context, database *gorm.DB) ([]*Migration, error) {
+	var status []*Migration
+	err := withMigrationLock(ctx, database, func(conn *sql.Conn, migrations []*Migration) error {
+		status = migrations
+		return nil
+	})
+	return status, err
+}
+
+// CheckMigrated() fails when a known migration has not been applied to the database.
+// It only reads, without waiting for the migration lock or creating schema_migrations.
+func CheckMigrated(ctx context.Context, database *gorm.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := database.DB()
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := sqlDB.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		applied, err := appliedMigrations(ctx, sqlDB)
+		if err != nil {
+			return err
+		}
+		markApplied(migrations, applied)
+	}
+
+	for _, migration := range migrations {
+		if migration.AppliedAt == nil {
+			return fmt.Errorf("database schema is not migrated, migration %d_%s is pending; run `migrate up` first",
+				migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// withMigrationLock() runs fn on a single connection holding the migration lock,
+// with the known migrations marked as applied from schema_migrations
+func withMigrationLock(ctx context.Context, database *gorm.DB, fn func(conn *sql.Conn, migrations []*Migration) error) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := database.DB()
+	if err != nil {
+		return err
+	}
+
+	// advisory locks belong to the session, so lock and migrate on the same connection
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock); err != nil {
+			logger.Error("failed to release migration lock", "error", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    bigint PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	markApplied(migrations, applied)
+	return fn(conn, migrations)
+}
+
+// markApplied() sets the time each of the migrations was applied at
+func markApplied(migrations []*Migration, applied map[int]time.Time) {
+	known := make(map[int]bool, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if appliedAt, ok := applied[migration.Version]; ok {
+			migration.AppliedAt = &appliedAt
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			logger.Warn("database has a migration unknown to this build", "version", version)
+		}
+	}
+}
+
+// queryer is a connection or a pool
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, conn queryer) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrationsAreOrderedAndComplete(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Fatalf("expected version %d, got %d_%s", i+1, migration.Version, migration.Name)
+		}
+		if migration.up == "" || migration.down == "" {
+			t.Fatalf("migration %d_%s is missing a script", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestMarkApplied(t *testing.T) {
+	migrations := []*Migration{{Version: 1}, {Version: 2}}
+	appliedAt := time.Now()
+
+	// version 3 was applied by a newer build
+	markApplied(migrations, map[int]time.Time{1: appliedAt, 3: appliedAt})
+
+	if migrations[0].AppliedAt == nil || !migrations[0].AppliedAt.Equal(appliedAt) {
+		t.Fatal("expected version 1 to be applied")
+	}
+	if migrations[1].AppliedAt != nil {
+		t.Fatal("expected version 2 to be pending")
+	}
+}
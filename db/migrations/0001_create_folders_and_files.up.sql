@@ -0,0 +1,34 @@
+-- matches the tables previously created by gorm's AutoMigrate, so existing databases are adopted as they are
+CREATE TABLE IF NOT EXISTS folders (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name       text   NOT NULL,
+    parent_id  bigint,
+    user_id    bigint NOT NULL,
+    CONSTRAINT fk_folders_parent FOREIGN KEY (parent_id) REFERENCES folders (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_folders_deleted_at ON folders (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_folders_parent_id ON folders (parent_id);
+CREATE INDEX IF NOT EXISTS idx_folders_user_id ON folders (user_id);
+
+CREATE TABLE IF NOT EXISTS files (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name       text   NOT NULL,
+    folder_id  bigint NOT NULL,
+    type       text,
+    extension  text,
+    size       bigint,
+    modified   text,
+    user_id    bigint NOT NULL,
+    CONSTRAINT fk_files_folder FOREIGN KEY (folder_id) REFERENCES folders (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_files_folder_id ON files (folder_id);
+CREATE INDEX IF NOT EXISTS idx_files_user_id ON files (user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_folders_unique_root;
+DROP INDEX IF EXISTS idx_files_unique_name;
+DROP INDEX IF EXISTS idx_folders_unique_name;
@@ -0,0 +1,17 @@
+-- names duplicated among live siblings are made unique first, the oldest row keeps the name and the others get their id appended
+UPDATE folders SET name = folders.name || ' (' || folders.id || ')'
+FROM (SELECT id, row_number() OVER (PARTITION BY user_id, parent_id, name ORDER BY id) AS position
+      FROM folders WHERE deleted_at IS NULL AND parent_id IS NOT NULL) AS duplicates
+WHERE folders.id = duplicates.id AND duplicates.position > 1;
+
+UPDATE files SET name = files.name || ' (' || files.id || ')'
+FROM (SELECT id, row_number() OVER (PARTITION BY user_id, folder_id, name ORDER BY id) AS position
+      FROM files WHERE deleted_at IS NULL) AS duplicates
+WHERE files.id = duplicates.id AND duplicates.position > 1;
+
+-- names are unique among the live siblings of a folder, soft-deleted rows do not block reusing a name
+CREATE UNIQUE INDEX idx_folders_unique_name ON folders (user_id, parent_id, name) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_files_unique_name ON files (user_id, folder_id, name) WHERE deleted_at IS NULL;
+
+-- the root folder is the only folder without a parent
+CREATE UNIQUE INDEX idx_folders_unique_root ON folders (user_id) WHERE parent_id IS NULL AND deleted_at IS NULL;
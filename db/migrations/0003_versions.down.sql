@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN version;
+ALTER TABLE folders DROP COLUMN version;
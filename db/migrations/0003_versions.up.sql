@@ -0,0 +1,3 @@
+-- incremented by every update, clients send the version they read to detect concurrent changes
+ALTER TABLE folders ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE files ADD COLUMN version bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS folder_tags;
+DROP TABLE IF EXISTS file_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,28 @@
+-- the tag catalogue of every user, names are unique per user ignoring case
+CREATE TABLE tags (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    name       text   NOT NULL,
+    color      text   NOT NULL DEFAULT '',
+    user_id    bigint NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_tags_unique_name ON tags (user_id, lower(name));
+
+-- links disappear with their tag or with an item that is removed permanently
+CREATE TABLE file_tags (
+    tag_id  bigint NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    file_id bigint NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    PRIMARY KEY (tag_id, file_id)
+);
+
+CREATE INDEX idx_file_tags_file_id ON file_tags (file_id);
+
+CREATE TABLE folder_tags (
+    tag_id    bigint NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    folder_id bigint NOT NULL REFERENCES folders (id) ON DELETE CASCADE,
+    PRIMARY KEY (tag_id, folder_id)
+);
+
+CREATE INDEX idx_folder_tags_folder_id ON folder_tags (folder_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_files_metadata;
+DROP INDEX IF EXISTS idx_folders_metadata;
+ALTER TABLE files DROP COLUMN metadata;
+ALTER TABLE folders DROP COLUMN metadata;
@@ -0,0 +1,6 @@
+-- a free-form document of integrating apps, the GIN index serves containment (@>) and key (?) lookups
+ALTER TABLE folders ADD COLUMN metadata jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE files ADD COLUMN metadata jsonb NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_folders_metadata ON folders USING GIN (metadata);
+CREATE INDEX idx_files_metadata ON files USING GIN (metadata);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_files_name_trgm;
+DROP INDEX IF EXISTS idx_folders_name_trgm;
+-- the pg_trgm extension is left installed, other schemas may use it
@@ -0,0 +1,5 @@
+-- trigram indexes serve the substring (ILIKE) and fuzzy (<%) name matching of search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_folders_name_trgm ON folders USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_files_name_trgm ON files USING GIN (name gin_trgm_ops);
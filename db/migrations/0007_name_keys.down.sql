@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN name_key;
+ALTER TABLE folders DROP COLUMN name_key;
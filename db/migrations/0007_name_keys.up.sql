@@ -0,0 +1,14 @@
+-- the name as the naming policy compares it, lower-cased when names are case-insensitive,
+-- so names differing only in case collide in the database also when written concurrently.
+-- Existing names are compared case-sensitively until rewritten, see the readme before enabling naming.caseInsensitive.
+ALTER TABLE folders ADD COLUMN name_key text;
+ALTER TABLE files ADD COLUMN name_key text;
+
+UPDATE folders SET name_key = name;
+UPDATE files SET name_key = name;
+
+ALTER TABLE folders ALTER COLUMN name_key SET NOT NULL;
+ALTER TABLE files ALTER COLUMN name_key SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_folders_unique_name_key ON folders (user_id, parent_id, name_key) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_files_unique_name_key ON files (user_id, folder_id, name_key) WHERE deleted_at IS NULL;
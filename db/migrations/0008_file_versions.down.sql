@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN replaced_by_id;
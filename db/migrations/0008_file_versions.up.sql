@@ -0,0 +1,4 @@
+-- a file replaced by the REPLACE conflict strategy stays soft-deleted as a prior version of the file replacing it
+ALTER TABLE files ADD COLUMN replaced_by_id bigint REFERENCES files (id) ON DELETE SET NULL;
+
+CREATE INDEX idx_files_replaced_by_id ON files (replaced_by_id) WHERE replaced_by_id IS NOT NULL;
@@ -2,6 +2,44 @@
 
 package model
 
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type BulkItemResult struct {
+	ID    string   `json:"id"`
+	Kind  ItemKind `json:"kind"`
+	Ok    bool     `json:"ok"`
+	Error *string  `json:"error"`
+	// the code of the error, as in the extensions of GraphQL errors
+	Code *string `json:"code"`
+	// the file after a move, or its copy
+	File *File `json:"file"`
+	// the folder after a move, or its copy
+	Folder *Folder `json:"folder"`
+}
+
+type BulkItems struct {
+	FileIds   []string `json:"fileIds"`
+	FolderIds []string `json:"folderIds"`
+}
+
+type BulkResult struct {
+	// every item was applied
+	Ok bool `json:"ok"`
+	// the outcome of every item, files first
+	Items []*BulkItemResult `json:"items"`
+}
+
+// the version an item of a bulk operation was read at
+type ExpectedVersion struct {
+	ID      string   `json:"id"`
+	Kind    ItemKind `json:"kind"`
+	Version int      `json:"version"`
+}
+
 type File struct {
 	ID        string  `json:"id"`
 	Name      string  `json:"name"`
@@ -12,6 +50,12 @@ type File struct {
 	Modified  *string `json:"modified"`
 	Path      *string `json:"path"`
 	UserID    string  `json:"userId"`
+	Version   int     `json:"version"`
+	// a free-form document of integrating apps
+	Metadata map[string]interface{} `json:"metadata"`
+	Tags     []*Tag                 `json:"tags"`
+	// the files this file replaced, the most recently replaced first
+	Versions []*File `json:"versions"`
 }
 
 type Folder struct {
@@ -20,4 +64,367 @@ type Folder struct {
 	ParentID *string `json:"parentId"`
 	Path     *string `json:"path"`
 	UserID   string  `json:"userId"`
+	Version  int     `json:"version"`
+	// a free-form document of integrating apps
+	Metadata map[string]interface{} `json:"metadata"`
+	Tags     []*Tag                 `json:"tags"`
+}
+
+type ImportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type ImportJob struct {
+	ID       string          `json:"id"`
+	FolderID string          `json:"folderId"`
+	Status   ImportJobStatus `json:"status"`
+	// the reason of a failed import
+	Error            *string `json:"error"`
+	EntriesProcessed int     `json:"entriesProcessed"`
+	FilesImported    int     `json:"filesImported"`
+	// folders created or merged into
+	FoldersImported int              `json:"foldersImported"`
+	BytesExtracted  int              `json:"bytesExtracted"`
+	Failures        []*ImportFailure `json:"failures"`
+	StartedAt       string           `json:"startedAt"`
+	FinishedAt      *string          `json:"finishedAt"`
+}
+
+// A condition on a top-level key of the metadata document
+type MetadataFilter struct {
+	Key string           `json:"key"`
+	Op  MetadataOperator `json:"op"`
+	// ignored by EXISTS, a null value of EQ matches keys holding null
+	Value interface{} `json:"value"`
+}
+
+// Narrows a search, null fields do not filter; extensions, types and sizes only match files
+type SearchFilters struct {
+	// null searches both kinds
+	Kinds []ItemKind `json:"kinds"`
+	// extensions without the dot, ignoring case
+	Extensions []string `json:"extensions"`
+	// MIME type prefixes such as image/ or application/pdf
+	Types   []string `json:"types"`
+	MinSize *int     `json:"minSize"`
+	MaxSize *int     `json:"maxSize"`
+	// RFC 3339 timestamps, updatedBefore is exclusive
+	UpdatedAfter  *string `json:"updatedAfter"`
+	UpdatedBefore *string `json:"updatedBefore"`
+}
+
+type SearchHit struct {
+	Kind ItemKind `json:"kind"`
+	// the path from the root folder, as Folder.path
+	Path string `json:"path"`
+	// higher is more relevant, 0 without a query
+	Score  float64 `json:"score"`
+	File   *File   `json:"file"`
+	Folder *Folder `json:"folder"`
+}
+
+type SearchResult struct {
+	Hits []*SearchHit `json:"hits"`
+	// more hits follow at offset plus the number of hits
+	HasMore bool `json:"hasMore"`
+}
+
+type Tag struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// #rrggbb, null when no colour was chosen
+	Color  *string `json:"color"`
+	UserID string  `json:"userId"`
+}
+
+type TaggedItems struct {
+	Folders []*Folder `json:"folders"`
+	Files   []*File   `json:"files"`
+}
+
+type BulkMode string
+
+const (
+	// apply every item or none of them
+	BulkModeAtomic BulkMode = "ATOMIC"
+	// apply as many items as possible, each item on its own
+	BulkModeBestEffort BulkMode = "BEST_EFFORT"
+)
+
+var AllBulkMode = []BulkMode{
+	BulkModeAtomic,
+	BulkModeBestEffort,
+}
+
+func (e BulkMode) IsValid() bool {
+	switch e {
+	case BulkModeAtomic, BulkModeBestEffort:
+		return true
+	}
+	return false
+}
+
+func (e BulkMode) String() string {
+	return string(e)
+}
+
+func (e *BulkMode) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = BulkMode(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid BulkMode", str)
+	}
+	return nil
+}
+
+func (e BulkMode) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// What to do when an item of the same name already exists
+type ConflictStrategy string
+
+const (
+	// fail with a CONFLICT error
+	ConflictStrategyFail ConflictStrategy = "FAIL"
+	// store the new item as name (1), name (2), ...
+	ConflictStrategyRename ConflictStrategy = "RENAME"
+	// replace the existing item; a file is kept as a prior version, a folder is deleted with its contents
+	ConflictStrategyReplace ConflictStrategy = "REPLACE"
+	// store the new item under the name and move the existing one to name (1), name (2), ...
+	ConflictStrategyKeepBoth ConflictStrategy = "KEEP_BOTH"
+)
+
+var AllConflictStrategy = []ConflictStrategy{
+	ConflictStrategyFail,
+	ConflictStrategyRename,
+	ConflictStrategyReplace,
+	ConflictStrategyKeepBoth,
+}
+
+func (e ConflictStrategy) IsValid() bool {
+	switch e {
+	case ConflictStrategyFail, ConflictStrategyRename, ConflictStrategyReplace, ConflictStrategyKeepBoth:
+		return true
+	}
+	return false
+}
+
+func (e ConflictStrategy) String() string {
+	return string(e)
+}
+
+func (e *ConflictStrategy) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ConflictStrategy(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ConflictStrategy", str)
+	}
+	return nil
+}
+
+func (e ConflictStrategy) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ImportJobStatus string
+
+const (
+	ImportJobStatusRunning   ImportJobStatus = "RUNNING"
+	ImportJobStatusCompleted ImportJobStatus = "COMPLETED"
+	// the archive was imported but some entries failed
+	ImportJobStatusCompletedWithErrors ImportJobStatus = "COMPLETED_WITH_ERRORS"
+	// the import stopped, entries imported before stay in place
+	ImportJobStatusFailed ImportJobStatus = "FAILED"
+)
+
+var AllImportJobStatus = []ImportJobStatus{
+	ImportJobStatusRunning,
+	ImportJobStatusCompleted,
+	ImportJobStatusCompletedWithErrors,
+	ImportJobStatusFailed,
+}
+
+func (e ImportJobStatus) IsValid() bool {
+	switch e {
+	case ImportJobStatusRunning, ImportJobStatusCompleted, ImportJobStatusCompletedWithErrors, ImportJobStatusFailed:
+		return true
+	}
+	return false
+}
+
+func (e ImportJobStatus) String() string {
+	return string(e)
+}
+
+func (e *ImportJobStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ImportJobStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ImportJobStatus", str)
+	}
+	return nil
+}
+
+func (e ImportJobStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ItemKind string
+
+const (
+	ItemKindFile   ItemKind = "FILE"
+	ItemKindFolder ItemKind = "FOLDER"
+)
+
+var AllItemKind = []ItemKind{
+	ItemKindFile,
+	ItemKindFolder,
+}
+
+func (e ItemKind) IsValid() bool {
+	switch e {
+	case ItemKindFile, ItemKindFolder:
+		return true
+	}
+	return false
+}
+
+func (e ItemKind) String() string {
+	return string(e)
+}
+
+func (e *ItemKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ItemKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ItemKind", str)
+	}
+	return nil
+}
+
+func (e ItemKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type MetadataOperator string
+
+const (
+	MetadataOperatorEq MetadataOperator = "EQ"
+	// the key is present with any value
+	MetadataOperatorExists MetadataOperator = "EXISTS"
+	// the ranges compare numbers with numbers and strings with strings
+	MetadataOperatorGt  MetadataOperator = "GT"
+	MetadataOperatorGte MetadataOperator = "GTE"
+	MetadataOperatorLt  MetadataOperator = "LT"
+	MetadataOperatorLte MetadataOperator = "LTE"
+)
+
+var AllMetadataOperator = []MetadataOperator{
+	MetadataOperatorEq,
+	MetadataOperatorExists,
+	MetadataOperatorGt,
+	MetadataOperatorGte,
+	MetadataOperatorLt,
+	MetadataOperatorLte,
+}
+
+func (e MetadataOperator) IsValid() bool {
+	switch e {
+	case MetadataOperatorEq, MetadataOperatorExists, MetadataOperatorGt, MetadataOperatorGte, MetadataOperatorLt, MetadataOperatorLte:
+		return true
+	}
+	return false
+}
+
+func (e MetadataOperator) String() string {
+	return string(e)
+}
+
+func (e *MetadataOperator) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = MetadataOperator(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid MetadataOperator", str)
+	}
+	return nil
+}
+
+func (e MetadataOperator) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Ties are ordered by name, folders first
+type SearchOrder string
+
+const (
+	// exact names, then prefixes, then substrings, each by similarity; the name without a query
+	SearchOrderRelevance     SearchOrder = "RELEVANCE"
+	SearchOrderNameAsc       SearchOrder = "NAME_ASC"
+	SearchOrderNameDesc      SearchOrder = "NAME_DESC"
+	SearchOrderUpdatedAtAsc  SearchOrder = "UPDATED_AT_ASC"
+	SearchOrderUpdatedAtDesc SearchOrder = "UPDATED_AT_DESC"
+	// folders come after all files
+	SearchOrderSizeAsc  SearchOrder = "SIZE_ASC"
+	SearchOrderSizeDesc SearchOrder = "SIZE_DESC"
+)
+
+var AllSearchOrder = []SearchOrder{
+	SearchOrderRelevance,
+	SearchOrderNameAsc,
+	SearchOrderNameDesc,
+	SearchOrderUpdatedAtAsc,
+	SearchOrderUpdatedAtDesc,
+	SearchOrderSizeAsc,
+	SearchOrderSizeDesc,
+}
+
+func (e SearchOrder) IsValid() bool {
+	switch e {
+	case SearchOrderRelevance, SearchOrderNameAsc, SearchOrderNameDesc, SearchOrderUpdatedAtAsc, SearchOrderUpdatedAtDesc, SearchOrderSizeAsc, SearchOrderSizeDesc:
+		return true
+	}
+	return false
+}
+
+func (e SearchOrder) String() string {
+	return string(e)
+}
+
+func (e *SearchOrder) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SearchOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SearchOrder", str)
+	}
+	return nil
+}
+
+func (e SearchOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
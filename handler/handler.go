@@ -1,8 +1,69 @@
 package handler
 
-import "github.com/confluentinc/confluent-kafka-go/kafka"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/schema"
+	"strconv"
+)
+
+// EventTypeHeader is the message header carrying the type of the event
+const EventTypeHeader = "eventType"
+
+// DeadLetterFunc receives messages that could not be handled because of a failure no retry can fix
+type DeadLetterFunc func(ctx context.Context, msg *consumer.Message, cause error) error
+
+// PermanentError marks a handler failure that would fail again on every attempt
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent() marks err as a failure that no retry can fix, its message is dead-lettered right away
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// Retryable() reports whether the failure may pass on another attempt.
+// Schema violations and permanent errors never do.
+func Retryable(err error) bool {
+	var permanent *PermanentError
+	return !errors.As(err, &permanent) && !schema.IsViolation(err)
+}
 
 type Handler interface {
-	Topic() string
-	HandleMessage(message *kafka.Message) error
+	// HandleMessage() receives a context carrying the trace found in the message headers
+	HandleMessage(ctx context.Context, message *consumer.Message) error
+}
+
+// EventType() returns the value of the eventType header or an empty string
+func EventType(msg *consumer.Message) string {
+	return msg.Headers[EventTypeHeader]
+}
+
+// SchemaVersion() returns the value of the schemaVersion header.
+// Messages without it are version 0, published before events carried a schema version.
+func SchemaVersion(msg *consumer.Message) int {
+	if version, err := strconv.Atoi(msg.Headers[schema.VersionHeader]); err == nil {
+		return version
+	}
+	return 0
+}
+
+// correlationID() returns the correlation id of the producer, or identifies the message itself
+func correlationID(msg *consumer.Message) string {
+	if correlationID := msg.Headers[logging.CorrelationIDHeader]; correlationID != "" {
+		return correlationID
+	}
+	return fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset)
 }
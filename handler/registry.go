@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/metrics"
+	"github.com/potatowhite/books/file-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
+)
+
+var (
+	logger = logging.For("handler")
+)
+
+// anyEventType routes every event type of a topic that has no dedicated handler
+const anyEventType = "*"
+
+// Registry routes consumed messages to handlers by topic and eventType header
+type Registry struct {
+	routes     map[string]map[string]Handler
+	deadLetter DeadLetterFunc
+}
+
+func NewRegistry() *Registry {
+	return &Registry{routes: make(map[string]map[string]Handler)}
+}
+
+// Register() adds a handler for the given event types of a topic.
+// Without event types the handler receives every event of the topic that no other handler claims.
+func (r *Registry) Register(topic string, h Handler, eventTypes ...string) {
+	if _, ok := r.routes[topic]; !ok {
+		r.routes[topic] = make(map[string]Handler)
+	}
+
+	if len(eventTypes) == 0 {
+		eventTypes = []string{anyEventType}
+	}
+
+	for _, eventType := range eventTypes {
+		r.routes[topic][eventType] = h
+	}
+}
+
+// SetDeadLetter() routes messages that fail on every attempt, see Retryable(), to deadLetter instead of failing them
+func (r *Registry) SetDeadLetter(deadLetter DeadLetterFunc) {
+	r.deadLetter = deadLetter
+}
+
+// Topics() returns all topics that have at least one handler, sorted by name
+func (r *Registry) Topics() []string {
+	topics := make([]string, 0, len(r.routes))
+	for topic := range r.routes {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Dispatch() hands the message to the handler registered for its topic and event type.
+// Event types without a handler are skipped. An error means the message was neither handled nor dead-lettered
+// and must not be committed, the consumer dispatches it again after a backoff.
+func (r *Registry) Dispatch(msg *consumer.Message) error {
+	topic := msg.Topic
+
+	handlers, ok := r.routes[topic]
+	if !ok {
+		return fmt.Errorf("no handler registered for topic %s", topic)
+	}
+
+	eventType := EventType(msg)
+	h, ok := handlers[eventType]
+	if !ok {
+		h, ok = handlers[anyEventType]
+	}
+	if !ok {
+		// other services publish event types to shared topics that this service does not consume
+		logger.Warn("skipping message without handler", "topic", topic, "eventType", eventType, "offset", msg.Offset)
+		return nil
+	}
+
+	// continue the trace and the correlation of the producer
+	ctx := logging.WithCorrelationID(context.Background(), correlationID(msg))
+	ctx, span := tracing.Start(tracing.Extract(ctx, msg.Headers), "consume "+topic+" "+eventType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingSourceName(topic),
+			attribute.String("messaging.event_type", eventType),
+		),
+	)
+	defer span.End()
+
+	err := h.HandleMessage(ctx, msg)
+	if err != nil {
+		metrics.HandlerFailures.WithLabelValues(topic, eventType).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	// a transient failure, such as a database that is down, is left to the retries of the consumer
+	if err != nil && !Retryable(err) && r.deadLetter != nil {
+		logger.WarnContext(ctx, "routing message to dead letter", "topic", topic, "offset", msg.Offset, "error", err)
+		return r.deadLetter(ctx, msg, err)
+	}
+
+	return err
+}
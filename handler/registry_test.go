@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/schema"
+	"testing"
+	"time"
+)
+
+// handlerFunc adapts a function to a Handler
+type handlerFunc func(ctx context.Context, msg *consumer.Message) error
+
+func (f handlerFunc) HandleMessage(ctx context.Context, msg *consumer.Message) error {
+	return f(ctx, msg)
+}
+
+// deadLetters collects the messages routed to the dead letter, failing with err
+type deadLetters struct {
+	messages []*consumer.Message
+	causes   []error
+	err      error
+}
+
+func (d *deadLetters) send(ctx context.Context, msg *consumer.Message, cause error) error {
+	d.messages = append(d.messages, msg)
+	d.causes = append(d.causes, cause)
+	return d.err
+}
+
+func newTestRegistry(h Handler, eventTypes ...string) (*Registry, *deadLetters) {
+	registry := NewRegistry()
+	dead := &deadLetters{}
+	registry.SetDeadLetter(dead.send)
+	registry.Register("users", h, eventTypes...)
+	return registry, dead
+}
+
+func event(eventType string) *consumer.Message {
+	return &consumer.Message{Topic: "users", Headers: map[string]string{EventTypeHeader: eventType}}
+}
+
+func TestDispatchRoutesByEventType(t *testing.T) {
+	var handled []string
+	registry := NewRegistry()
+	registry.Register("users", handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		handled = append(handled, "created")
+		return nil
+	}), "UserCreatedEvent")
+	registry.Register("users", handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		handled = append(handled, "any")
+		return nil
+	}))
+
+	for _, eventType := range []string{"UserCreatedEvent", "UserRenamedEvent"} {
+		if err := registry.Dispatch(event(eventType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(handled) != 2 || handled[0] != "created" || handled[1] != "any" {
+		t.Fatalf("expected the dedicated and then the catch-all handler, got %v", handled)
+	}
+	if err := registry.Dispatch(&consumer.Message{Topic: "orders"}); err == nil {
+		t.Fatal("expected an error for a topic without handlers")
+	}
+}
+
+func TestDispatchSkipsEventTypesWithoutHandler(t *testing.T) {
+	registry, dead := newTestRegistry(handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		t.Fatal("unexpected call")
+		return nil
+	}), "UserCreatedEvent")
+
+	if err := registry.Dispatch(event("UserRenamedEvent")); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead.messages) != 0 {
+		t.Fatal("a message of another service was dead-lettered")
+	}
+}
+
+func TestDispatchLeavesTransientFailuresToTheConsumer(t *testing.T) {
+	var attempts int
+	registry, dead := newTestRegistry(handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		if attempts++; attempts < 3 {
+			return errors.New("database is down")
+		}
+		return nil
+	}))
+
+	// the consumer retries a failed message until it passes, however long the database is down
+	if !consumer.Deliver(registry, event("UserDeletedEvent"), make(chan struct{})) {
+		t.Fatal("expected the message to be delivered")
+	}
+	if attempts != 3 || len(dead.messages) != 0 {
+		t.Fatalf("expected 3 attempts without dead letter, got %d and %d", attempts, len(dead.messages))
+	}
+}
+
+func TestDispatchStopsRetryingOnQuit(t *testing.T) {
+	registry, dead := newTestRegistry(handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		return errors.New("database is down")
+	}))
+
+	quit := make(chan struct{})
+	delivered := make(chan bool)
+	go func() {
+		delivered <- consumer.Deliver(registry, event("UserDeletedEvent"), quit)
+	}()
+	close(quit)
+
+	select {
+	case ok := <-delivered:
+		if ok {
+			t.Fatal("expected the message to be left undelivered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retries kept waiting after quit")
+	}
+	if len(dead.messages) != 0 {
+		t.Fatalf("a transient failure was dead-lettered: %v", dead.causes)
+	}
+}
+
+func TestDispatchDeadLettersPermanentFailures(t *testing.T) {
+	var attempts int
+	registry, dead := newTestRegistry(handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		attempts++
+		return Permanent(errors.New("unknown event"))
+	}))
+
+	msg := event("UserDeletedEvent")
+	if err := registry.Dispatch(msg); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("a permanent failure was retried %d times", attempts-1)
+	}
+	if len(dead.messages) != 1 || dead.messages[0] != msg {
+		t.Fatalf("expected the message to be dead-lettered, got %v", dead.messages)
+	}
+}
+
+func TestDispatchFailsWhenTheDeadLetterFails(t *testing.T) {
+	registry, dead := newTestRegistry(handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		return Permanent(errors.New("unknown event"))
+	}))
+	dead.err = errors.New("broker is down")
+
+	// the consumer must not commit a message that went nowhere
+	if err := registry.Dispatch(event("UserDeletedEvent")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDispatchWithoutDeadLetterReturnsTheFailure(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("users", handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		return Permanent(errors.New("unknown event"))
+	}))
+
+	if err := registry.Dispatch(event("UserDeletedEvent")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: errors.New("database is down"), retryable: true},
+		{err: fmt.Errorf("failed: %w", context.DeadlineExceeded), retryable: true},
+		{err: fmt.Errorf("failed: %w", Permanent(errors.New("unknown event")))},
+		{err: fmt.Errorf("failed to decode: %w", &schema.ViolationError{Subject: "UserDeletedEvent", Err: errors.New("missing userID")})},
+	}
+	for _, test := range tests {
+		if Retryable(test.err) != test.retryable {
+			t.Errorf("expected Retryable(%v) to be %v", test.err, test.retryable)
+		}
+	}
+}
+
+func TestDispatchDeadLettersViolationsWithoutRetrying(t *testing.T) {
+	var attempts int
+	registry, dead := newTestRegistry(handlerFunc(func(ctx context.Context, msg *consumer.Message) error {
+		attempts++
+		return fmt.Errorf("failed to decode: %w", &schema.ViolationError{Subject: "UserDeletedEvent", Version: 1, Err: errors.New("missing userID")})
+	}))
+
+	if err := registry.Dispatch(event("UserDeletedEvent")); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("a violation was retried %d times", attempts-1)
+	}
+	if len(dead.causes) != 1 || !schema.IsViolation(dead.causes[0]) {
+		t.Fatalf("expected the violation to be dead-lettered, got %v", dead.causes)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	tests := map[string]int{"": 0, "1": 1, "2": 2, "two": 0}
+	for header, expected := range tests {
+		msg := &consumer.Message{Headers: map[string]string{}}
+		if header != "" {
+			msg.Headers[schema.VersionHeader] = header
+		}
+		if version := SchemaVersion(msg); version != expected {
+			t.Fatalf("expected version %d for %q, got %d", expected, header, version)
+		}
+	}
+}
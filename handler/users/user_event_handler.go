@@ -1,94 +1,250 @@
 package users
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/handler"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/repository"
 	"github.com/potatowhite/books/file-service/pkg/service"
-	"log"
-	"os"
+	"github.com/potatowhite/books/file-service/schema"
+	"math"
 	"strconv"
+	"time"
 )
 
 var (
-	// logger with thread id
-	logger = log.New(os.Stdout, "handler", log.LstdFlags|log.Lshortfile)
+	logger = logging.For("handler.users")
 )
 
 type UserEventHandler struct {
 	FileSvc   service.FileService
 	FolderSvc service.FolderService
+	TagSvc    service.TagService
+
+	// Schemas validates consumed payloads and the events this handler produces
+	Schemas *schema.Registry
+
+	// Producer and EventTopic are used to announce that a users data has been erased
+	Producer   consumer.Producer
+	EventTopic string
 }
 
-// Topic() returns the topic that this users is subscribed to
-func (h *UserEventHandler) Topic() string {
-	return "users"
+const (
+	UserCreatedEvent     = "UserCreatedEvent"
+	UserDeletedEvent     = "UserDeletedEvent"
+	UserDataDeletedEvent = "UserDataDeletedEvent"
+)
+
+// EventTypes() returns the event types this users handler understands
+func (h *UserEventHandler) EventTypes() []string {
+	return []string{UserCreatedEvent, UserDeletedEvent}
 }
 
 // HandleMessage() handles a message from the topic that this users is subscribed to
-func (h *UserEventHandler) HandleMessage(msg *kafka.Message) error {
+func (h *UserEventHandler) HandleMessage(ctx context.Context, msg *consumer.Message) error {
 	// extract eventType from header
-	var eventType string
-	for _, header := range msg.Headers {
-		if string(header.Key) == "eventType" {
-			eventType = string(header.Value)
-		}
+	eventType := handler.EventType(msg)
+
+	if eventType != UserCreatedEvent && eventType != UserDeletedEvent {
+		return handler.Permanent(fmt.Errorf("unknown users event type: %s", eventType))
+	}
+
+	// producers before versioned schemas often sent no payload at all, or left the userID to the key
+	version, payload := handler.SchemaVersion(msg), msg.Value
+	if version == 0 {
+		payload = withKeyAsUserID(payload, msg.Key)
+	}
+
+	// decode and validate the payload before touching any data
+	var event UserEvent
+	if err := h.Schemas.Decode(eventType, version, payload, &event); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", eventType, err)
 	}
 
-	// extract userID from key
-	userID := string(msg.Key)
+	// the key is the partitioning userID, it has to agree with the payload, which the schema requires to carry one
+	userID, key := event.UserID, string(msg.Key)
+	if key != "" && key != userID {
+		return fmt.Errorf("failed to decode %s: %w", eventType,
+			&schema.ViolationError{Subject: eventType, Version: version, Err: fmt.Errorf("message key %s does not match userID %s", key, userID)})
+	}
+	ctx = logging.WithUserID(ctx, userID)
 
 	// Handle the event based on its type
 	switch eventType {
-	case "UserCreatedEvent":
-		if err := h.createRootFolder(userID); err != nil {
+	case UserCreatedEvent:
+		if err := h.createRootFolder(ctx, userID); err != nil {
 			return fmt.Errorf("failed to create root folder for users %s: %v", userID, err)
 		}
-
-		payload := string(msg.Value)
-		logger.Printf("payload: %s", payload)
-	case "UserDeletedEvent":
-		if err := h.deleteAllFolders(userID); err != nil {
-			return fmt.Errorf("failed to delete folders for users %s: %v", userID, err)
+	case UserDeletedEvent:
+		if err := h.deleteUserData(ctx, userID); err != nil {
+			return fmt.Errorf("failed to delete data for users %s: %v", userID, err)
 		}
-		if err := h.deleteAllFiles(userID); err != nil {
-			return fmt.Errorf("failed to delete files for users %s: %v", userID, err)
-		}
-	default:
-		return fmt.Errorf("unknown users event type: %s", eventType)
 	}
 
 	return nil
 }
 
+// RegisterUpcasters() adds the conversion of the users events published before versioned schemas
+func RegisterUpcasters(schemas *schema.Registry) {
+	for _, eventType := range []string{UserCreatedEvent, UserDeletedEvent} {
+		schemas.RegisterUpcaster(eventType, 0, upcastV0)
+	}
+}
+
+// withKeyAsUserID() completes a payload published before versioned schemas with the userID of the message key,
+// which the latest schema requires in the payload. Payloads that are no object are left to the schema.
+func withKeyAsUserID(payload []byte, key []byte) []byte {
+	if len(bytes.TrimSpace(payload)) == 0 {
+		payload = []byte("{}")
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(payload, &object); err != nil || object == nil || len(key) == 0 {
+		return payload
+	}
+	if _, ok := object["userID"]; ok {
+		return payload
+	}
+
+	object["userID"] = string(key)
+	completed, err := json.Marshal(object)
+	if err != nil {
+		return payload
+	}
+	return completed
+}
+
+// upcastV0() turns a numeric userID into a string
+func upcastV0(payload map[string]interface{}) (map[string]interface{}, error) {
+	if id, ok := payload["userID"].(float64); ok {
+		if id < 0 || id != math.Trunc(id) || id > 1<<53 {
+			return nil, fmt.Errorf("userID %v is not an exact id", id)
+		}
+		payload["userID"] = strconv.FormatInt(int64(id), 10)
+	}
+	return payload, nil
+}
+
 type UserEvent struct {
 	UserID    string `json:"userID"`
 	EventType string `json:"eventType"`
 }
 
-func (h *UserEventHandler) createRootFolder(userID string) error {
+// UserDataDeleted is published as UserDataDeletedEvent once all data of a deleted users has been erased
+type UserDataDeleted struct {
+	UserID         string    `json:"userID"`
+	DeletedFolders int64     `json:"deletedFolders"`
+	DeletedFiles   int64     `json:"deletedFiles"`
+	CompletedAt    time.Time `json:"completedAt"`
+}
+
+func (h *UserEventHandler) createRootFolder(ctx context.Context, userID string) error {
 	// make userID to uint(not unit64)
 	userIDUInt, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
-		logger.Printf("Failed to convert userID to uint64: %v", err)
+		logger.ErrorContext(ctx, "failed to convert userID to uint64", "error", err)
 		return err
 	}
 
 	// create root folder
-	folder, err := h.FolderSvc.CreateRootFolder(uint(userIDUInt))
-	if err != nil {
+	folder, err := h.FolderSvc.CreateRootFolder(ctx, uint(userIDUInt))
+	if errors.Is(err, repository.ErrConflict) {
+		// a redelivered event, the root folder was created before
+		return nil
+	} else if err != nil {
 		return err
 	}
-	// logging folder info and userID
-	logger.Printf("Created root folder for users %s: %v\n", userID, folder)
+	logger.InfoContext(ctx, "created root folder", "folder_id", folder.ID)
 	return nil
 }
 
-func (h *UserEventHandler) deleteAllFolders(userID string) error {
-	logger.Printf("Deleting all folders for users %s\n", userID)
+// deleteUserData() permanently erases everything stored for the users and publishes a completion event.
+// Every step is idempotent, so a retried, redelivered or replayed dead-lettered event resumes an interrupted erasure.
+func (h *UserEventHandler) deleteUserData(ctx context.Context, userID string) error {
+	userIDUInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to convert userID to uint64", "error", err)
+		return err
+	}
+
+	// files reference their folder, so they have to go first
+	deletedFiles, err := h.deleteAllFiles(ctx, uint(userIDUInt))
+	if err != nil {
+		return err
+	}
+
+	deletedFolders, err := h.deleteAllFolders(ctx, uint(userIDUInt))
+	if err != nil {
+		return err
+	}
+
+	if err := h.deleteAllTags(ctx, uint(userIDUInt)); err != nil {
+		return err
+	}
+
+	return h.publishDataDeleted(ctx, UserDataDeleted{
+		UserID:         userID,
+		DeletedFolders: deletedFolders,
+		DeletedFiles:   deletedFiles,
+		CompletedAt:    time.Now().UTC(),
+	})
+}
+
+func (h *UserEventHandler) deleteAllFolders(ctx context.Context, userID uint) (int64, error) {
+	logger.InfoContext(ctx, "deleting all folders")
+	deleted, err := h.FolderSvc.DeleteAllFolders(ctx, userID)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to delete folders: %v", err)
+	}
+
+	logger.InfoContext(ctx, "deleted all folders", "deleted", deleted)
+	return deleted, nil
+}
+
+func (h *UserEventHandler) deleteAllFiles(ctx context.Context, userID uint) (int64, error) {
+	logger.InfoContext(ctx, "deleting all files")
+	deleted, err := h.FileSvc.DeleteAllFiles(ctx, userID)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to delete files: %v", err)
+	}
+
+	logger.InfoContext(ctx, "deleted all files", "deleted", deleted)
+	return deleted, nil
+}
+
+func (h *UserEventHandler) deleteAllTags(ctx context.Context, userID uint) error {
+	deleted, err := h.TagSvc.DeleteAllTags(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tags: %v", err)
+	}
+
+	logger.InfoContext(ctx, "deleted all tags", "deleted", deleted)
 	return nil
 }
 
-func (h *UserEventHandler) deleteAllFiles(userID string) error {
-	logger.Printf("Deleting all files for users %s\n", userID)
+func (h *UserEventHandler) publishDataDeleted(ctx context.Context, event UserDataDeleted) error {
+	if h.Producer == nil {
+		logger.WarnContext(ctx, "no producer configured, skipping UserDataDeletedEvent")
+		return nil
+	}
+
+	payload, version, err := h.Schemas.Encode(UserDataDeletedEvent, event)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		handler.EventTypeHeader: UserDataDeletedEvent,
+		schema.VersionHeader:    strconv.Itoa(version),
+	}
+	if err := h.Producer.Produce(ctx, &consumer.Message{Topic: h.EventTopic, Key: []byte(event.UserID), Value: payload, Headers: headers}); err != nil {
+		return fmt.Errorf("failed to publish UserDataDeletedEvent: %v", err)
+	}
+
 	return nil
 }
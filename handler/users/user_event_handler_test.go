@@ -0,0 +1,316 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/potatowhite/books/file-service/consumer"
+	"github.com/potatowhite/books/file-service/handler"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/schema"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	usersTopic      = "users"
+	eventsTopic     = "file-service"
+	deadLetterTopic = "file-service.dlq"
+)
+
+// fakeServices records the calls the handler makes to its services, failing the first ones
+type fakeServices struct {
+	mu       sync.Mutex
+	calls    []string
+	failures int
+}
+
+func (f *fakeServices) record(call string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("database is down")
+	}
+	return nil
+}
+
+func (f *fakeServices) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// the embedded interfaces panic on every call the handler is not expected to make
+type fakeFolders struct {
+	service.FolderService
+	*fakeServices
+}
+
+type fakeFiles struct {
+	service.FileService
+	*fakeServices
+}
+
+type fakeTags struct {
+	service.TagService
+	*fakeServices
+}
+
+func (f fakeFolders) CreateRootFolder(ctx context.Context, userId uint) (*entity.Folder, error) {
+	if err := f.record("CreateRootFolder"); err != nil {
+		return nil, err
+	}
+	folder := &entity.Folder{UserId: userId}
+	folder.ID = 1
+	return folder, nil
+}
+
+func (f fakeFiles) DeleteAllFiles(ctx context.Context, userId uint) (int64, error) {
+	return 3, f.record("DeleteAllFiles")
+}
+
+func (f fakeFolders) DeleteAllFolders(ctx context.Context, userId uint) (int64, error) {
+	return 2, f.record("DeleteAllFolders")
+}
+
+func (f fakeTags) DeleteAllTags(ctx context.Context, userId uint) (int64, error) {
+	return 1, f.record("DeleteAllTags")
+}
+
+// collector is a router gathering the messages of the topics it is given
+type collector struct {
+	topics   []string
+	messages chan *consumer.Message
+}
+
+func (c *collector) Topics() []string {
+	return c.topics
+}
+
+func (c *collector) Dispatch(msg *consumer.Message) error {
+	c.messages <- msg
+	return nil
+}
+
+func (c *collector) next(t *testing.T) *consumer.Message {
+	t.Helper()
+	select {
+	case msg := <-c.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a message on %v", c.topics)
+		return nil
+	}
+}
+
+// setup() routes the users topic of a memory broker to the handler and collects the published events
+// and dead letters
+func setup(t *testing.T, services *fakeServices) (consumer.Producer, *collector, *collector) {
+	t.Helper()
+
+	schemas, err := schema.LoadRegistry("../../schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := consumer.NewMemoryBroker()
+	producer := broker.Producer()
+
+	userHandler := &UserEventHandler{
+		FileSvc:    fakeFiles{fakeServices: services},
+		FolderSvc:  fakeFolders{fakeServices: services},
+		TagSvc:     fakeTags{fakeServices: services},
+		Schemas:    schemas,
+		Producer:   producer,
+		EventTopic: eventsTopic,
+	}
+	RegisterUpcasters(schemas)
+	registry := handler.NewRegistry()
+	registry.SetDeadLetter(consumer.DeadLetterTo(producer, deadLetterTopic))
+	registry.Register(usersTopic, userHandler, userHandler.EventTypes()...)
+
+	events := &collector{topics: []string{eventsTopic}, messages: make(chan *consumer.Message, 10)}
+	deadLetters := &collector{topics: []string{deadLetterTopic}, messages: make(chan *consumer.Message, 10)}
+	for _, c := range []consumer.Consumer{broker.NewConsumer(registry), broker.NewConsumer(events), broker.NewConsumer(deadLetters)} {
+		go c.Run()
+		t.Cleanup(c.Close)
+	}
+	return producer, events, deadLetters
+}
+
+func userEvent(eventType string, payload string) *consumer.Message {
+	return &consumer.Message{
+		Topic: usersTopic,
+		Key:   []byte("7"),
+		Value: []byte(payload),
+		Headers: map[string]string{
+			handler.EventTypeHeader: eventType,
+			schema.VersionHeader:    "1",
+		},
+	}
+}
+
+func waitForCalls(t *testing.T, services *fakeServices, count int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if calls := services.recorded(); len(calls) >= count {
+			return calls
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d calls, got %v", count, services.recorded())
+	return nil
+}
+
+func TestUserCreatedEventCreatesRootFolder(t *testing.T) {
+	services := &fakeServices{}
+	producer, _, _ := setup(t, services)
+
+	if err := producer.Produce(context.Background(), userEvent(UserCreatedEvent, `{"userID":"7"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := waitForCalls(t, services, 1); calls[0] != "CreateRootFolder" {
+		t.Fatalf("expected CreateRootFolder, got %v", calls)
+	}
+}
+
+func TestUserDeletedEventErasesDataAndAnnouncesIt(t *testing.T) {
+	services := &fakeServices{}
+	producer, events, _ := setup(t, services)
+
+	if err := producer.Produce(context.Background(), userEvent(UserDeletedEvent, `{"userID":"7","eventType":"UserDeletedEvent"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := events.next(t)
+	if handler.EventType(msg) != UserDataDeletedEvent || string(msg.Key) != "7" {
+		t.Fatalf("expected a UserDataDeletedEvent keyed by the user, got %s keyed %s", handler.EventType(msg), msg.Key)
+	}
+	var deleted UserDataDeleted
+	if err := json.Unmarshal(msg.Value, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.UserID != "7" || deleted.DeletedFiles != 3 || deleted.DeletedFolders != 2 {
+		t.Fatalf("unexpected event %+v", deleted)
+	}
+
+	// files reference their folders, so they go first
+	expected := []string{"DeleteAllFiles", "DeleteAllFolders", "DeleteAllTags"}
+	calls := services.recorded()
+	if len(calls) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestUserDeletedEventResumesAfterAFailure(t *testing.T) {
+	services := &fakeServices{failures: 1}
+	producer, events, _ := setup(t, services)
+
+	if err := producer.Produce(context.Background(), userEvent(UserDeletedEvent, `{"userID":"7"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := events.next(t); handler.EventType(msg) != UserDataDeletedEvent {
+		t.Fatalf("expected a UserDataDeletedEvent, got %s", handler.EventType(msg))
+	}
+	calls := services.recorded()
+	if len(calls) != 4 || calls[0] != "DeleteAllFiles" || calls[1] != "DeleteAllFiles" {
+		t.Fatalf("expected the failed step to be retried, got %v", calls)
+	}
+}
+
+func TestLegacyEventsTakeTheUserFromTheKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"no payload", ""},
+		{"no userID", `{"eventType":"UserCreatedEvent"}`},
+		{"numeric userID", `{"userID":7}`},
+		{"string userID", `{"userID":"7"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			services := &fakeServices{}
+			producer, _, _ := setup(t, services)
+
+			// published before events carried a schemaVersion header
+			msg := userEvent(UserCreatedEvent, test.payload)
+			delete(msg.Headers, schema.VersionHeader)
+			if err := producer.Produce(context.Background(), msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if calls := waitForCalls(t, services, 1); calls[0] != "CreateRootFolder" {
+				t.Fatalf("expected CreateRootFolder, got %v", calls)
+			}
+		})
+	}
+}
+
+func TestInvalidEventsAreDeadLettered(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		key     string
+		payload string
+	}{
+		{"missing userID", "1", "7", `{}`},
+		{"userID not a number", "1", "", `{"userID":"seven"}`},
+		{"not json", "1", "7", `userID=7`},
+		{"unknown version", "9", "7", `{"userID":"7"}`},
+		{"legacy without any userID", "", "", ``},
+		{"legacy negative userID", "", "", `{"userID":-7}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			services := &fakeServices{}
+			producer, _, deadLetters := setup(t, services)
+
+			msg := userEvent(UserDeletedEvent, test.payload)
+			msg.Key = []byte(test.key)
+			if test.version == "" {
+				delete(msg.Headers, schema.VersionHeader)
+			} else {
+				msg.Headers[schema.VersionHeader] = test.version
+			}
+			if err := producer.Produce(context.Background(), msg); err != nil {
+				t.Fatal(err)
+			}
+
+			dead := deadLetters.next(t)
+			if dead.Headers["originalTopic"] != usersTopic || dead.Headers["error"] == "" || string(dead.Value) != test.payload {
+				t.Fatalf("expected the unchanged message with its cause, got %+v", dead)
+			}
+			if calls := services.recorded(); len(calls) != 0 {
+				t.Fatalf("an invalid event touched data: %v", calls)
+			}
+		})
+	}
+}
+
+func TestMismatchingKeyIsRejected(t *testing.T) {
+	services := &fakeServices{}
+	producer, _, deadLetters := setup(t, services)
+
+	msg := userEvent(UserDeletedEvent, `{"userID":"8"}`)
+	if err := producer.Produce(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetters.next(t)
+	if calls := services.recorded(); len(calls) != 0 {
+		t.Fatalf("the data of a user was erased for a message keyed by another: %v", calls)
+	}
+}
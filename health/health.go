@@ -0,0 +1,152 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	// every check gets this long before it counts as failed
+	checkTimeout = 2 * time.Second
+)
+
+// Check probes one dependency, details are reported as they are
+type Check func(ctx context.Context) (details map[string]interface{}, err error)
+
+type CheckResult struct {
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Health serves liveness and readiness of the service.
+// Liveness only fails when the process is wedged, readiness also fails when a dependency is unavailable
+// or the service is shutting down.
+type Health struct {
+	mu           sync.RWMutex
+	liveness     map[string]Check
+	readiness    map[string]Check
+	shuttingDown int32
+}
+
+func New() *Health {
+	return &Health{
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+	}
+}
+
+// AddLivenessCheck() adds a check to liveness and readiness
+func (h *Health) AddLivenessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness[name] = check
+}
+
+// AddReadinessCheck() adds a check to readiness only
+func (h *Health) AddReadinessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness[name] = check
+}
+
+// ShutDown() makes readiness fail from now on
+func (h *Health) ShutDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		checks := copyChecks(h.liveness)
+		h.mu.RUnlock()
+
+		writeReport(w, run(r.Context(), checks))
+	})
+}
+
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, h.Readiness(r.Context()))
+	})
+}
+
+// Readiness() runs the liveness and readiness checks, for servers reporting readiness their own way
+func (h *Health) Readiness(ctx context.Context) Report {
+	h.mu.RLock()
+	checks := copyChecks(h.liveness, h.readiness)
+	h.mu.RUnlock()
+
+	report := run(ctx, checks)
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		report.Status = StatusDown
+		report.Checks["shutdown"] = CheckResult{Status: StatusDown, Error: "service is shutting down"}
+	}
+	return report
+}
+
+// run() executes all checks concurrently
+func run(ctx context.Context, checks map[string]Check) Report {
+	report := Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			result := CheckResult{Status: StatusUp}
+			details, err := check(ctx)
+			result.Details = details
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusDown
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func copyChecks(sources ...map[string]Check) map[string]Check {
+	checks := make(map[string]Check)
+	for _, source := range sources {
+		for name, check := range source {
+			checks[name] = check
+		}
+	}
+	return checks
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != StatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(report)
+}
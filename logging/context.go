@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// CorrelationIDHeader is the message header correlating an event with the request that caused it
+const CorrelationIDHeader = "correlationId"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	correlationIDKey
+	userIDKey
+)
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, correlationID)
+}
+
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// RequestID() returns the request id of ctx or an empty string
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// CorrelationID() returns the correlation id of ctx, falling back to its request id
+func CorrelationID(ctx context.Context) string {
+	if correlationID, ok := ctx.Value(correlationIDKey).(string); ok {
+		return correlationID
+	}
+	return RequestID(ctx)
+}
+
+// contextHandler adds the ids stored in the context to every record logged with it
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if requestID, ok := ctx.Value(requestIDKey).(string); ok {
+			record.AddAttrs(slog.String("request_id", requestID))
+		}
+		if correlationID, ok := ctx.Value(correlationIDKey).(string); ok {
+			record.AddAttrs(slog.String("correlation_id", correlationID))
+		}
+		if userID, ok := ctx.Value(userIDKey).(string); ok {
+			record.AddAttrs(slog.String("user_id", userID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
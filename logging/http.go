@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"net/http"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware assigns every request an id, taken from X-Request-ID when the caller sent one
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = NewID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// NewID() returns a random id for requests and correlations
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// GraphqlExtension adds the userId argument of a resolver to everything logged below it
+type GraphqlExtension struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.FieldInterceptor
+} = GraphqlExtension{}
+
+func (GraphqlExtension) ExtensionName() string {
+	return "Logging"
+}
+
+func (GraphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (GraphqlExtension) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	if fc := graphql.GetFieldContext(ctx); fc != nil {
+		if userID, ok := fc.Args["userId"]; ok && userID != nil {
+			ctx = WithUserID(ctx, fmt.Sprint(userID))
+		}
+	}
+	return next(ctx)
+}
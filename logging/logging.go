@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"github.com/potatowhite/books/file-service/config"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Init() installs the shared logger every package logs through
+func Init(cfg config.Logging) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil && cfg.Level != "" {
+		return fmt.Errorf("unknown log level %s", cfg.Level)
+	}
+
+	options := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, options)
+	default:
+		return fmt.Errorf("unknown log format %s", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+	return nil
+}
+
+// For() returns the logger of a component. It always writes through the current default logger,
+// so package level loggers pick up the configuration of Init().
+func For(component string) *slog.Logger {
+	return slog.New(&defaultHandler{}).With("component", component)
+}
+
+// defaultHandler forwards to the handler of slog.Default() at the time of logging
+type defaultHandler struct {
+	// wrap re-applies attributes and groups added with With and WithGroup
+	wrap func(slog.Handler) slog.Handler
+}
+
+func (h *defaultHandler) base() slog.Handler {
+	base := slog.Default().Handler()
+	if h.wrap != nil {
+		return h.wrap(base)
+	}
+	return base
+}
+
+func (h *defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.base().Enabled(ctx, level)
+}
+
+func (h *defaultHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.base().Handle(ctx, record)
+}
+
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &defaultHandler{wrap: h.chain(func(base slog.Handler) slog.Handler { return base.WithAttrs(attrs) })}
+}
+
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	return &defaultHandler{wrap: h.chain(func(base slog.Handler) slog.Handler { return base.WithGroup(name) })}
+}
+
+func (h *defaultHandler) chain(next func(slog.Handler) slog.Handler) func(slog.Handler) slog.Handler {
+	previous := h.wrap
+	return func(base slog.Handler) slog.Handler {
+		if previous != nil {
+			base = previous(base)
+		}
+		return next(base)
+	}
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// attributes whose value is never logged
+	secretKeys = []string{"password", "secret", "token", "authorization", "apikey", "api_key"}
+
+	// key=value pairs of connection strings and credentials in URLs
+	secretPairs    = regexp.MustCompile(`(?i)\b(password|passwd|pwd|secret|token)=([^\s&]+)`)
+	urlCredentials = regexp.MustCompile(`://([^:/@\s]+):([^@/\s]+)@`)
+)
+
+// Redact() removes passwords and tokens from a string such as a DSN or URL
+func Redact(s string) string {
+	s = secretPairs.ReplaceAllString(s, "$1="+redacted)
+	return urlCredentials.ReplaceAllString(s, "://$1:"+redacted+"@")
+}
+
+// redactAttr() is applied to every attribute, including the message, before it is written
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+
+	if attr.Value.Kind() == slog.KindString {
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	}
+	// errors of drivers and clients quote the DSN or URL they failed with
+	if err, ok := attr.Value.Any().(error); ok && err != nil {
+		return slog.String(attr.Key, Redact(err.Error()))
+	}
+	return attr
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"github.com/potatowhite/books/file-service/config"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSecretsAreNeverLogged(t *testing.T) {
+	const secret = "s3cret"
+	tests := []struct {
+		name string
+		msg  string
+		args []interface{}
+	}{
+		{name: "dsn", msg: "connecting", args: []interface{}{"dsn", "host=db user=app password=s3cret dbname=files sslmode=disable"}},
+		{name: "dsn in message", msg: "connecting to host=db user=app password=s3cret dbname=files"},
+		{name: "url", msg: "connecting", args: []interface{}{"url", "postgres://app:s3cret@db:5432/files"}},
+		{name: "url query", msg: "connecting", args: []interface{}{"url", "https://storage/files?token=s3cret&id=7"}},
+		{name: "error", msg: "failed to connect", args: []interface{}{"error", errors.New("dial postgres://app:s3cret@db:5432/files: refused")}},
+		{name: "secret key", msg: "configured", args: []interface{}{"api_token", secret}},
+		{name: "database", msg: "configured", args: []interface{}{"database", config.Database{Host: "db", Username: "app", Password: secret, Dbname: "files"}}},
+		{name: "database in group", msg: "configured", args: []interface{}{slog.Group("config", "database", config.Database{Password: secret})}},
+	}
+	for _, test := range tests {
+		for format, newHandler := range map[string]func(*bytes.Buffer) slog.Handler{
+			"json": func(buf *bytes.Buffer) slog.Handler {
+				return slog.NewJSONHandler(buf, &slog.HandlerOptions{ReplaceAttr: redactAttr})
+			},
+			"text": func(buf *bytes.Buffer) slog.Handler {
+				return slog.NewTextHandler(buf, &slog.HandlerOptions{ReplaceAttr: redactAttr})
+			},
+		} {
+			t.Run(test.name+" as "+format, func(t *testing.T) {
+				var buf bytes.Buffer
+				slog.New(newHandler(&buf)).Info(test.msg, test.args...)
+
+				if strings.Contains(buf.String(), secret) {
+					t.Fatalf("the secret was logged: %s", buf.String())
+				} else if !strings.Contains(buf.String(), redacted) {
+					t.Fatalf("expected the secret to be replaced: %s", buf.String())
+				}
+			})
+		}
+	}
+}
+
+func TestRedactKeepsTheRest(t *testing.T) {
+	tests := map[string]string{
+		"host=db user=app password=s3cret dbname=files": "host=db user=app password=[REDACTED] dbname=files",
+		"postgres://app:s3cret@db:5432/files":           "postgres://app:[REDACTED]@db:5432/files",
+		"postgres://db:5432/files":                      "postgres://db:5432/files",
+		"no secrets here":                               "no secrets here",
+	}
+	for s, expected := range tests {
+		if actual := Redact(s); actual != expected {
+			t.Errorf("expected %q for %q, got %q", expected, s, actual)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"time"
+)
+
+// GraphqlExtension records the latency and the result of every GraphQL operation
+type GraphqlExtension struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = GraphqlExtension{}
+
+func (GraphqlExtension) ExtensionName() string {
+	return "Metrics"
+}
+
+func (GraphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (GraphqlExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	start := time.Now()
+	res := next(ctx)
+
+	operation := "anonymous"
+	if op := graphql.GetOperationContext(ctx); op != nil && op.OperationName != "" {
+		operation = op.OperationName
+	}
+
+	status := "success"
+	if res == nil || len(res.Errors) > 0 {
+		status = "error"
+	}
+
+	GraphqlDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	GraphqlOperations.WithLabelValues(operation, status).Inc()
+
+	return res
+}
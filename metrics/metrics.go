@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "file_service"
+
+var (
+	GraphqlOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "graphql_operations_total",
+		Help:      "GraphQL operations by operation name and result.",
+	}, []string{"operation", "status"})
+
+	GraphqlDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "graphql_operation_duration_seconds",
+		Help:      "Latency of GraphQL operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of repository queries by table and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"table", "operation", "status"})
+
+	ConsumedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_messages_total",
+		Help:      "Messages processed per topic and partition.",
+	}, []string{"topic", "partition"})
+
+	HandlerFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_handler_failures_total",
+		Help:      "Messages a handler failed to process, by topic and event type.",
+	}, []string{"topic", "event_type"})
+
+	WorkerQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_worker_queue_depth",
+		Help:      "Messages buffered or in progress per partition worker.",
+	}, []string{"worker"})
+)
+
+// RegisterDBStats() exposes the statistics of the connection pool
+func RegisterDBStats(sqlDB *sql.DB, dbName string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(sqlDB, dbName))
+}
+
+// Handler() serves all registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// observations() returns how often the histogram of the labels observed a value
+func observations(t *testing.T, histogram *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := histogram.WithLabelValues(labels...).(prometheus.Metric).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+// count() returns the value of the counter of the labels
+func count(t *testing.T, counter *prometheus.CounterVec, labels ...string) float64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := counter.WithLabelValues(labels...).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+// shelf is stored in the table shelves
+type shelf struct {
+	ID   uint
+	Name string
+}
+
+// dryRun() returns a database that builds statements without a server and runs the callbacks of the plugin
+func dryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestGormPluginLabelsQueriesByTableAndOperation(t *testing.T) {
+	db := dryRun(t)
+	queries := observations(t, QueryDuration, "shelves", "query", "success")
+	creates := observations(t, QueryDuration, "shelves", "create", "success")
+	deletes := observations(t, QueryDuration, "shelves", "delete", "success")
+
+	db.Find(&[]shelf{})
+	db.Create(&shelf{Name: "fiction"})
+	db.Delete(&shelf{ID: 1})
+
+	if observations(t, QueryDuration, "shelves", "query", "success") != queries+1 ||
+		observations(t, QueryDuration, "shelves", "create", "success") != creates+1 ||
+		observations(t, QueryDuration, "shelves", "delete", "success") != deletes+1 {
+		t.Fatal("expected every statement to be observed with its table and operation")
+	}
+}
+
+func TestGormPluginLabelsFailures(t *testing.T) {
+	db := dryRun(t)
+	tests := []struct {
+		err    error
+		status string
+	}{
+		{status: "success"},
+		// a missing row is an answer, not a failure of the database
+		{err: gorm.ErrRecordNotFound, status: "success"},
+		{err: errors.New("connection reset"), status: "error"},
+	}
+	for _, test := range tests {
+		observed := observations(t, QueryDuration, "unknown", "raw", test.status)
+
+		tx := db.Session(&gorm.Session{NewDB: true}).Table("")
+		before(tx)
+		tx.Error = test.err
+		after("raw")(tx)
+
+		if observations(t, QueryDuration, "unknown", "raw", test.status) != observed+1 {
+			t.Fatalf("expected %v to be observed as %s", test.err, test.status)
+		}
+	}
+}
+
+func TestGraphqlExtensionLabelsOperations(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		response  *graphql.Response
+		status    string
+	}{
+		{name: "success", operation: "CreateFile", response: &graphql.Response{}, status: "success"},
+		{name: "errors", operation: "CreateFile", response: &graphql.Response{Errors: gqlerror.List{gqlerror.Errorf("conflict")}}, status: "error"},
+		{name: "anonymous", response: &graphql.Response{}, status: "success"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			label := test.operation
+			if label == "" {
+				label = "anonymous"
+			}
+			before := count(t, GraphqlOperations, label, test.status)
+			durations := observations(t, GraphqlDuration, label)
+
+			ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{OperationName: test.operation})
+			GraphqlExtension{}.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+				return test.response
+			})
+
+			if count(t, GraphqlOperations, label, test.status) != before+1 || observations(t, GraphqlDuration, label) != durations+1 {
+				t.Fatalf("expected the operation to be counted as %s %s", label, test.status)
+			}
+		})
+	}
+}
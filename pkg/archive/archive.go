@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	logger = logging.For("archive")
+)
+
+// Format is the container of an archive
+type Format string
+
+const (
+	Zip   Format = "zip"
+	TarGz Format = "tar.gz"
+)
+
+// ParseFormat() accepts zip, tar.gz and tgz
+func ParseFormat(format string) (Format, error) {
+	switch strings.ToLower(format) {
+	case "", "zip":
+		return Zip, nil
+	case "tar.gz", "tgz":
+		return TarGz, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+// ContentType() is the media type of the format
+func (f Format) ContentType() string {
+	if f == TarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+type Service interface {
+	// Folder() returns the folder to archive, so callers can fail before streaming starts
+	Folder(ctx context.Context, userId uint, folderId uint) (*entity.Folder, error)
+	// Write() streams the contents of the folder with the folder hierarchy preserved
+	Write(ctx context.Context, userId uint, folder *entity.Folder, format Format, w io.Writer) error
+}
+
+func NewService(folderSvc service.FolderService, fileSvc service.FileService, blobs storage.BlobStore) Service {
+	return &archiveService{folderSvc: folderSvc, fileSvc: fileSvc, blobs: blobs}
+}
+
+type archiveService struct {
+	folderSvc service.FolderService
+	fileSvc   service.FileService
+	blobs     storage.BlobStore
+}
+
+func (a *archiveService) Folder(ctx context.Context, userId uint, folderId uint) (*entity.Folder, error) {
+	return a.folderSvc.GetFolder(ctx, userId, folderId)
+}
+
+func (a *archiveService) Write(ctx context.Context, userId uint, folder *entity.Folder, format Format, w io.Writer) error {
+	var archive entryWriter
+	if format == TarGz {
+		archive = newTarGzWriter(w)
+	} else {
+		archive = &zipWriter{zip: zip.NewWriter(w)}
+	}
+
+	// an archive left unclosed after an error is truncated, so the client cannot mistake it for a complete one
+	if err := a.walk(ctx, userId, folder.ID, "", archive); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// walk() writes the files of the folder and then descends into its subfolders, one folder at a time
+func (a *archiveService) walk(ctx context.Context, userId uint, folderId uint, dir string, archive entryWriter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	files, err := a.fileSvc.GetChildren(ctx, userId, folderId)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := a.writeFile(ctx, file, path.Join(dir, entryName(file.Name)), archive); err != nil {
+			return err
+		}
+	}
+
+	folders, err := a.folderSvc.GetChildren(ctx, userId, folderId)
+	if err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		sub := path.Join(dir, entryName(folder.Name))
+		if err := archive.Dir(sub, folder.UpdatedAt); err != nil {
+			return err
+		}
+		if err := a.walk(ctx, userId, folder.ID, sub, archive); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *archiveService) writeFile(ctx context.Context, file *entity.File, name string, archive entryWriter) error {
+	content, size, err := a.blobs.Open(ctx, file.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// the file has metadata only, it is archived empty to keep the tree complete
+		logger.DebugContext(ctx, "archiving file without content", "file_id", file.ID)
+		return archive.File(name, file.UpdatedAt, 0, strings.NewReader(""))
+	} else if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return archive.File(name, file.UpdatedAt, size, content)
+}
+
+// entryName() keeps a name from adding or leaving levels of the hierarchy
+func entryName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	switch name {
+	case "", ".", "..":
+		// extractors resolve the dot names to the current or parent folder
+		return strings.Repeat("_", max(len(name), 1))
+	}
+	return name
+}
+
+// entryWriter writes the entries of one archive format
+type entryWriter interface {
+	Dir(name string, modified time.Time) error
+	File(name string, modified time.Time, size int64, content io.Reader) error
+	Close() error
+}
+
+type zipWriter struct {
+	zip *zip.Writer
+}
+
+func (z *zipWriter) Dir(name string, modified time.Time) error {
+	_, err := z.zip.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: modified})
+	return err
+}
+
+func (z *zipWriter) File(name string, modified time.Time, size int64, content io.Reader) error {
+	w, err := z.zip.CreateHeader(&zip.FileHeader{Name: name, Modified: modified, Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zip.Close()
+}
+
+type tarGzWriter struct {
+	gzip *gzip.Writer
+	tar  *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	compressed := gzip.NewWriter(w)
+	return &tarGzWriter{gzip: compressed, tar: tar.NewWriter(compressed)}
+}
+
+func (t *tarGzWriter) Dir(name string, modified time.Time) error {
+	return t.tar.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: modified})
+}
+
+func (t *tarGzWriter) File(name string, modified time.Time, size int64, content io.Reader) error {
+	err := t.tar.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size, ModTime: modified})
+	if err != nil {
+		return err
+	}
+	// tar needs the size up front, content that changed meanwhile fails the archive
+	_, err = io.CopyN(t.tar, content, size)
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tar.Close(); err != nil {
+		return err
+	}
+	return t.gzip.Close()
+}
@@ -0,0 +1,202 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEntryName(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf": "report.pdf",
+		"a/b":        "a_b",
+		"a\\b":       "a_b",
+		".":          "_",
+		"..":         "__",
+		"":           "_",
+		"..hidden":   "..hidden",
+		"../etc":     ".._etc",
+	}
+	for name, expected := range tests {
+		if actual := entryName(name); actual != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, actual)
+		}
+	}
+}
+
+// fakeTree holds the folders, files and contents of an archived tree, the embedded interfaces panic on other calls
+type fakeTree struct {
+	service.FileService
+	storage.BlobStore
+	folders  map[uint][]*entity.Folder
+	files    map[uint][]*entity.File
+	contents map[uint]string
+	failing  uint
+}
+
+func (f *fakeTree) GetChildren(ctx context.Context, userId uint, folderId uint) ([]*entity.File, error) {
+	return f.files[folderId], nil
+}
+
+func (f *fakeTree) Open(ctx context.Context, id uint) (io.ReadCloser, int64, error) {
+	if id == f.failing {
+		return nil, 0, errors.New("disk is gone")
+	}
+	content, ok := f.contents[id]
+	if !ok {
+		return nil, 0, storage.ErrNotFound
+	}
+	return io.NopCloser(strings.NewReader(content)), int64(len(content)), nil
+}
+
+// fakeFolders serves the child folders, GetChildren of fakeTree serves the files
+type fakeFolders struct {
+	service.FolderService
+	tree *fakeTree
+}
+
+func (f fakeFolders) GetChildren(ctx context.Context, userId uint, parentID uint) ([]*entity.Folder, error) {
+	return f.tree.folders[parentID], nil
+}
+
+func folder(id uint, name string) *entity.Folder {
+	folder := &entity.Folder{Name: name}
+	folder.ID = id
+	return folder
+}
+
+func file(id uint, name string) *entity.File {
+	file := &entity.File{Name: name}
+	file.ID = id
+	return file
+}
+
+// newFakeTree() returns the root folder 1 with names that must not change the hierarchy
+func newFakeTree() *fakeTree {
+	return &fakeTree{
+		folders: map[uint][]*entity.Folder{1: {folder(2, "docs"), folder(3, ".")}},
+		files: map[uint][]*entity.File{
+			1: {file(10, "a.txt"), file(11, ".."), file(12, "empty.txt")},
+			2: {file(13, "b/c.txt")},
+		},
+		contents: map[uint]string{10: "hello", 11: "dots", 13: "slash"},
+	}
+}
+
+// the entries of the tree, folders end in a slash, file 12 has no content and is archived empty
+var expectedEntries = map[string]string{
+	"a.txt":        "hello",
+	"__":           "dots",
+	"empty.txt":    "",
+	"docs/":        "",
+	"docs/b_c.txt": "slash",
+	"_/":           "",
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range reader.File {
+		content, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	compressed, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := tar.NewReader(compressed)
+	entries := make(map[string]string)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = string(data)
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	tests := []struct {
+		format Format
+		read   func(t *testing.T, data []byte) map[string]string
+	}{
+		{format: Zip, read: readZip},
+		{format: TarGz, read: readTarGz},
+	}
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			tree := newFakeTree()
+			svc := NewService(fakeFolders{tree: tree}, tree, tree)
+
+			var buf bytes.Buffer
+			if err := svc.Write(context.Background(), 7, folder(1, "root"), test.format, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if entries := test.read(t, buf.Bytes()); !reflect.DeepEqual(entries, expectedEntries) {
+				t.Fatalf("expected %v, got %v", expectedEntries, entries)
+			}
+		})
+	}
+}
+
+func TestWriteLeavesTheArchiveTruncatedOnFailure(t *testing.T) {
+	for _, format := range []Format{Zip, TarGz} {
+		t.Run(string(format), func(t *testing.T) {
+			tree := newFakeTree()
+			tree.failing = 13
+			svc := NewService(fakeFolders{tree: tree}, tree, tree)
+
+			var buf bytes.Buffer
+			if err := svc.Write(context.Background(), 7, folder(1, "root"), format, &buf); err == nil {
+				t.Fatal("expected the failure of a content to fail the archive")
+			}
+
+			// the archive is not closed, a reader cannot take it for a complete one
+			if format == Zip {
+				if _, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+					t.Fatal("expected the zip archive to be unreadable")
+				}
+				return
+			}
+			compressed, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+			if err == nil {
+				_, err = io.ReadAll(compressed)
+			}
+			if err == nil {
+				t.Fatal("expected the tar.gz archive to be unreadable")
+			}
+		})
+	}
+}
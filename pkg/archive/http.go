@@ -0,0 +1,62 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	"gorm.io/gorm"
+	"mime"
+	"net/http"
+)
+
+// Handler serves GET /archive?userId=&folderId=&format=zip|tar.gz.
+// The archive is written to the response while the folder tree is walked, nothing is buffered.
+func Handler(svc Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		userId := query.Get("userId")
+		folderId := query.Get("folderId")
+		userIdInt, folderIdInt := util.AtoUIOrNil(&userId), util.AtoUIOrNil(&folderId)
+		if userIdInt == nil || folderIdInt == nil {
+			http.Error(w, "userId and folderId are required", http.StatusBadRequest)
+			return
+		}
+
+		format, err := ParseFormat(query.Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		folder, err := svc.Folder(r.Context(), *userIdInt, *folderIdInt)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "folder not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.ErrorContext(r.Context(), "failed to get folder to archive", "folder_id", *folderIdInt, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		name := folder.Name
+		if name == "" {
+			name = "files"
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": fmt.Sprintf("%s.%s", name, format),
+		}))
+
+		// the status is sent with the first bytes, a failure from here on can only cut the archive short
+		if err := svc.Write(r.Context(), *userIdInt, folder, format, w); err != nil {
+			logger.ErrorContext(r.Context(), "failed to stream archive", "folder_id", folder.ID, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// errSkipped marks entries that are left out silently, like the metadata folders of macOS
+var errSkipped = errors.New("skipped")
+
+// entry is a file or folder of an archive
+type entry struct {
+	name    string
+	dir     bool
+	regular bool
+	// compressed is the compressed size of a zip entry, 0 when unknown
+	compressed int64
+	open       func() (io.ReadCloser, error)
+}
+
+// readArchive() detects the format of the archive and calls fn for every entry in order until fn fails
+func readArchive(archive io.ReaderAt, size int64, fn func(e *entry) error) error {
+	header := make([]byte, 512)
+	n, err := archive.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return readZip(archive, size, fn)
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		compressed, err := gzip.NewReader(io.NewSectionReader(archive, 0, size))
+		if err != nil {
+			return err
+		}
+		defer compressed.Close()
+		return readTar(bufio.NewReader(compressed), fn)
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return readTar(io.NewSectionReader(archive, 0, size), fn)
+	default:
+		return errors.New("unsupported archive format, expected zip, tar or tar.gz")
+	}
+}
+
+func readZip(archive io.ReaderAt, size int64, fn func(e *entry) error) error {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		file := file
+		mode := file.Mode()
+		err := fn(&entry{
+			name:       file.Name,
+			dir:        mode.IsDir(),
+			regular:    mode.IsRegular(),
+			compressed: int64(file.CompressedSize64),
+			open:       file.Open,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTar(archive io.Reader, fn func(e *entry) error) error {
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = fn(&entry{
+			name:    header.Name,
+			dir:     header.Typeflag == tar.TypeDir,
+			regular: header.Typeflag == tar.TypeReg,
+			// tar entries are read in sequence, the content is only available until the next entry
+			open: func() (io.ReadCloser, error) { return io.NopCloser(reader), nil },
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cleanPath() returns the slash separated path of an entry relative to the import folder.
+// Paths escaping the folder are rejected, so an entry can never be written outside of it.
+func cleanPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("absolute path %q is not allowed", name)
+	}
+
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path %q leaves the import folder", name)
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", errSkipped
+	}
+	if cleaned == "__MACOSX" || strings.HasPrefix(cleaned, "__MACOSX/") {
+		return "", errSkipped
+	}
+
+	return cleaned, nil
+}
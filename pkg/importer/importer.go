@@ -0,0 +1,340 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logger = logging.For("importer")
+
+	// errLimits stops the whole import, the archive is considered an archive bomb
+	errLimits = errors.New("archive exceeds the import limits")
+)
+
+// entries may expand to this size whatever their compression ratio
+const minRatioLimit = 1 << 20
+
+type Service interface {
+	// Start() spools the uploaded archive and imports it into the folder in the background
+	Start(ctx context.Context, userId uint, folderId uint, upload io.Reader, strategy service.ConflictStrategy) (*Job, error)
+	// Job() returns the progress of an import of the user
+	Job(userId uint, id string) (*Job, bool)
+	// Close() stops the running imports and waits for them
+	Close()
+}
+
+func NewService(folderSvc service.FolderService, fileSvc service.FileService, blobs storage.BlobStore, limits config.Import) Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &importService{
+		folderSvc: folderSvc,
+		fileSvc:   fileSvc,
+		blobs:     blobs,
+		limits:    limits,
+		jobs:      newJobs(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+type importService struct {
+	folderSvc service.FolderService
+	fileSvc   service.FileService
+	blobs     storage.BlobStore
+	limits    config.Import
+	jobs      *jobs
+
+	// ctx outlives the request starting an import and is cancelled on Close()
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (s *importService) Start(ctx context.Context, userId uint, folderId uint, upload io.Reader, strategy service.ConflictStrategy) (*Job, error) {
+	if _, err := s.folderSvc.GetFolder(ctx, userId, folderId); err != nil {
+		return nil, err
+	}
+
+	// the upload is gone once the request ends, the job reads its own copy
+	spooled, size, err := s.spool(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        logging.NewID(),
+		UserId:    userId,
+		FolderId:  folderId,
+		Status:    JobRunning,
+		StartedAt: time.Now(),
+	}
+	s.jobs.add(job)
+
+	jobCtx := logging.WithCorrelationID(s.ctx, logging.CorrelationID(ctx))
+	jobCtx = logging.WithUserID(jobCtx, fmt.Sprint(userId))
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer os.Remove(spooled.Name())
+		defer spooled.Close()
+
+		s.run(jobCtx, job.ID, userId, folderId, spooled, size, strategy)
+	}()
+
+	logger.InfoContext(ctx, "import started", "job_id", job.ID, "folder_id", folderId, "bytes", size)
+	snapshot, _ := s.jobs.get(job.ID)
+	return snapshot, nil
+}
+
+func (s *importService) Job(userId uint, id string) (*Job, bool) {
+	job, ok := s.jobs.get(id)
+	if !ok || job.UserId != userId {
+		return nil, false
+	}
+	return job, true
+}
+
+func (s *importService) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// spool() copies the upload into a temporary file, refusing uploads over the size limit
+func (s *importService) spool(upload io.Reader) (*os.File, int64, error) {
+	spooled, err := os.CreateTemp("", "import-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := io.Copy(spooled, io.LimitReader(upload, s.limits.MaxArchiveBytes+1))
+	if err == nil && size > s.limits.MaxArchiveBytes {
+		err = fmt.Errorf("%w: the archive is larger than %d bytes", errLimits, s.limits.MaxArchiveBytes)
+	}
+	if err != nil {
+		spooled.Close()
+		os.Remove(spooled.Name())
+		return nil, 0, err
+	}
+
+	return spooled, size, nil
+}
+
+func (s *importService) run(ctx context.Context, jobId string, userId uint, folderId uint, archive *os.File, size int64, strategy service.ConflictStrategy) {
+	run := &importRun{
+		service:  s,
+		ctx:      ctx,
+		jobId:    jobId,
+		userId:   userId,
+		strategy: strategy,
+		folders:  map[string]uint{".": folderId},
+		failed:   make(map[string]error),
+		budget:   s.limits.MaxExtractedBytes,
+	}
+
+	err := readArchive(archive, size, run.importEntry)
+
+	finished := time.Now()
+	s.jobs.update(jobId, func(job *Job) {
+		job.FinishedAt = &finished
+		switch {
+		case err != nil:
+			job.Status = JobFailed
+			job.Error = err.Error()
+		case len(job.Failures) > 0:
+			job.Status = JobCompletedWithErrors
+		default:
+			job.Status = JobCompleted
+		}
+	})
+
+	if err != nil {
+		logger.ErrorContext(ctx, "import failed", "job_id", jobId, "error", err)
+	} else {
+		logger.InfoContext(ctx, "import finished", "job_id", jobId)
+	}
+}
+
+// importRun is the state of one running import
+type importRun struct {
+	service  *importService
+	ctx      context.Context
+	jobId    string
+	userId   uint
+	strategy service.ConflictStrategy
+
+	// folders maps cleaned archive paths to folder ids, failed remembers folders that could not be created
+	folders map[string]uint
+	failed  map[string]error
+	entries int
+	budget  int64
+}
+
+// importEntry() imports one entry, errors returned stop the import, failures of the entry only are recorded
+func (r *importRun) importEntry(e *entry) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	r.entries++
+	if r.service.limits.MaxEntries > 0 && r.entries > r.service.limits.MaxEntries {
+		return fmt.Errorf("%w: the archive has more than %d entries", errLimits, r.service.limits.MaxEntries)
+	}
+
+	defer r.service.jobs.update(r.jobId, func(job *Job) { job.EntriesProcessed++ })
+
+	p, err := cleanPath(e.name)
+	if errors.Is(err, errSkipped) {
+		return nil
+	} else if err != nil {
+		r.fail(e.name, err)
+		return nil
+	}
+
+	switch {
+	case e.dir:
+		if _, err := r.folder(p); err != nil {
+			r.fail(p, err)
+		}
+		return nil
+	case !e.regular:
+		r.fail(p, errors.New("only files and folders can be imported"))
+		return nil
+	}
+
+	parentId, err := r.folder(path.Dir(p))
+	if err != nil {
+		r.fail(p, err)
+		return nil
+	}
+
+	return r.file(p, parentId, e)
+}
+
+// file() creates the file and stores its content
+func (r *importRun) file(p string, parentId uint, e *entry) error {
+	ctx := r.ctx
+	name := path.Base(p)
+
+	file, err := r.service.fileSvc.CreateFile(ctx, r.userId, name, parentId, r.strategy)
+	if err != nil {
+		r.fail(p, err)
+		return nil
+	}
+
+	content, err := e.open()
+	if err != nil {
+		r.discard(file.ID)
+		r.fail(p, err)
+		return nil
+	}
+	defer content.Close()
+
+	// the declared sizes of an archive cannot be trusted, the extracted bytes are counted instead
+	limit := r.budget
+	if e.compressed > 0 && r.service.limits.MaxRatio > 0 {
+		// small files compress far beyond any sane ratio, they are covered by the total budget only
+		if byRatio := max(e.compressed*r.service.limits.MaxRatio, minRatioLimit); byRatio < limit {
+			limit = byRatio
+		}
+	}
+	guarded := &limitedReader{reader: content, remaining: limit}
+
+	size, err := r.service.blobs.Put(ctx, file.ID, guarded)
+	if err != nil {
+		r.discard(file.ID)
+		if guarded.exceeded {
+			return fmt.Errorf("%w: %s expands beyond the allowed size", errLimits, p)
+		}
+		r.fail(p, err)
+		return nil
+	}
+	r.budget -= size
+
+	sizeUInt := uint64(size)
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	fileType := mime.TypeByExtension(path.Ext(name))
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+	if _, err := r.service.fileSvc.PatchFile(ctx, r.userId, file.ID, nil, &fileType, &ext, &sizeUInt, nil); err != nil {
+		r.fail(p, err)
+		return nil
+	}
+
+	r.service.jobs.update(r.jobId, func(job *Job) {
+		job.FilesImported++
+		job.BytesExtracted += size
+	})
+	return nil
+}
+
+// folder() returns the id of the folder at the cleaned path, creating missing folders on the way.
+// Existing folders are merged into, whatever the conflict strategy of the files.
+func (r *importRun) folder(p string) (uint, error) {
+	if id, ok := r.folders[p]; ok {
+		return id, nil
+	}
+	if err, ok := r.failed[p]; ok {
+		return 0, err
+	}
+
+	parentId, err := r.folder(path.Dir(p))
+	if err != nil {
+		return 0, err
+	}
+
+	folder, err := r.service.folderSvc.EnsureFolder(r.ctx, r.userId, path.Base(p), parentId)
+	if err != nil {
+		err = fmt.Errorf("folder %s: %w", p, err)
+		r.failed[p] = err
+		return 0, err
+	}
+
+	r.folders[p] = folder.ID
+	r.service.jobs.update(r.jobId, func(job *Job) { job.FoldersImported++ })
+	return folder.ID, nil
+}
+
+func (r *importRun) fail(p string, err error) {
+	r.service.jobs.update(r.jobId, func(job *Job) {
+		job.Failures = append(job.Failures, EntryFailure{Path: p, Error: err.Error()})
+	})
+}
+
+// discard() removes a file whose content could not be stored, a file it replaced is brought back
+func (r *importRun) discard(id uint) {
+	// a cancelled import still brings back the replaced file
+	if err := r.service.fileSvc.DiscardFile(context.WithoutCancel(r.ctx), r.userId, id); err != nil {
+		logger.ErrorContext(r.ctx, "failed to remove file of failed import entry", "file_id", id, "error", err)
+	}
+}
+
+// limitedReader fails once more than remaining bytes were read
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return n, errLimits
+	}
+	return n, err
+}
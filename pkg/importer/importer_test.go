@@ -0,0 +1,312 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{name: "docs/report.pdf", expected: "docs/report.pdf"},
+		{name: "docs\\report.pdf", expected: "docs/report.pdf"},
+		{name: "./docs//report.pdf", expected: "docs/report.pdf"},
+		{name: "docs/", expected: "docs"},
+		{name: "./", err: errSkipped},
+		{name: "__MACOSX/._report.pdf", err: errSkipped},
+		{name: "../report.pdf"},
+		{name: "docs/../../report.pdf"},
+		{name: "docs/../report.pdf"},
+		{name: "..\\report.pdf"},
+		{name: "/etc/passwd"},
+		{name: "\\etc\\passwd"},
+		{name: "C:/Windows/win.ini"},
+		{name: "c:report.pdf"},
+	}
+	for _, test := range tests {
+		cleaned, err := cleanPath(test.name)
+		switch {
+		case test.expected != "":
+			if err != nil || cleaned != test.expected {
+				t.Errorf("expected %q for %q, got %q: %v", test.expected, test.name, cleaned, err)
+			}
+		case test.err != nil:
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v for %q, got %q: %v", test.err, test.name, cleaned, err)
+			}
+		default:
+			// the entry fails, it is neither written nor skipped silently
+			if err == nil || errors.Is(err, errSkipped) {
+				t.Errorf("expected %q to be rejected, got %q: %v", test.name, cleaned, err)
+			}
+		}
+	}
+}
+
+// fakeTree keeps the folders and files an import creates in memory, the embedded interfaces panic on other calls
+type fakeTree struct {
+	folders map[string]uint
+	files   map[uint]string
+	deleted map[uint]bool
+	nextId  uint
+}
+
+func newFakeTree() *fakeTree {
+	return &fakeTree{folders: make(map[string]uint), files: make(map[uint]string), deleted: make(map[uint]bool), nextId: 1}
+}
+
+type fakeFolders struct {
+	service.FolderService
+	tree *fakeTree
+}
+
+type fakeFiles struct {
+	service.FileService
+	tree *fakeTree
+}
+
+func (f fakeFolders) EnsureFolder(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error) {
+	key := path.Join(fmt.Sprint(parentId), name)
+	if _, ok := f.tree.folders[key]; !ok {
+		f.tree.nextId++
+		f.tree.folders[key] = f.tree.nextId
+	}
+	folder := &entity.Folder{Name: name, ParentId: &parentId}
+	folder.ID = f.tree.folders[key]
+	return folder, nil
+}
+
+func (f fakeFiles) CreateFile(ctx context.Context, userId uint, name string, folderId uint, strategy service.ConflictStrategy) (*entity.File, error) {
+	f.tree.nextId++
+	f.tree.files[f.tree.nextId] = name
+	file := &entity.File{Name: name, FolderId: folderId}
+	file.ID = f.tree.nextId
+	return file, nil
+}
+
+func (f fakeFiles) PatchFile(ctx context.Context, userId uint, id uint, name *string, fileType *string, extension *string, size *uint64, expectedVersion *uint) (*entity.File, error) {
+	file := &entity.File{Name: f.tree.files[id]}
+	file.ID = id
+	return file, nil
+}
+
+func (f fakeFiles) DiscardFile(ctx context.Context, userId uint, id uint) error {
+	f.tree.deleted[id] = true
+	return nil
+}
+
+// countingBlobs reads every content completely and keeps its size
+type countingBlobs struct {
+	storage.BlobStore
+	sizes map[uint]int64
+}
+
+func (b *countingBlobs) Put(ctx context.Context, id uint, content io.Reader) (int64, error) {
+	size, err := io.Copy(io.Discard, content)
+	if err != nil {
+		return size, err
+	}
+	b.sizes[id] = size
+	return size, nil
+}
+
+// archiveEntry is a file of a test archive, a name ending in a slash is a folder
+type archiveEntry struct {
+	name    string
+	content []byte
+}
+
+func zipArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := writer.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	compressed := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(compressed)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// runImport() imports the archive into folder 1 synchronously and returns the finished job
+func runImport(t *testing.T, archive []byte, limits config.Import) (*Job, *fakeTree, *countingBlobs) {
+	t.Helper()
+	tree := newFakeTree()
+	blobs := &countingBlobs{sizes: make(map[uint]int64)}
+	s := NewService(fakeFolders{tree: tree}, fakeFiles{tree: tree}, blobs, limits).(*importService)
+	t.Cleanup(s.Close)
+
+	spooled, err := os.CreateTemp(t.TempDir(), "import-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spooled.Close()
+	if _, err := spooled.Write(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{ID: "job", UserId: 7, FolderId: 1, Status: JobRunning}
+	s.jobs.add(job)
+	s.run(context.Background(), job.ID, 7, 1, spooled, int64(len(archive)), service.ConflictFail)
+
+	finished, _ := s.jobs.get(job.ID)
+	return finished, tree, blobs
+}
+
+var testLimits = config.Import{MaxArchiveBytes: 1 << 30, MaxExtractedBytes: 8 << 20, MaxEntries: 100, MaxRatio: 100}
+
+func TestImportCreatesFoldersAndFiles(t *testing.T) {
+	archive := zipArchive(t,
+		archiveEntry{name: "docs/"},
+		archiveEntry{name: "docs/a.txt", content: []byte("a")},
+		archiveEntry{name: "docs/sub/b.txt", content: []byte("bb")},
+	)
+	job, tree, blobs := runImport(t, archive, testLimits)
+
+	if job.Status != JobCompleted || job.FilesImported != 2 || job.FoldersImported != 2 || job.BytesExtracted != 3 {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if len(tree.files) != 2 || len(blobs.sizes) != 2 {
+		t.Fatalf("expected 2 files with content, got %v and %v", tree.files, blobs.sizes)
+	}
+}
+
+func TestImportRejectsPathsLeavingTheFolder(t *testing.T) {
+	archive := zipArchive(t,
+		archiveEntry{name: "../evil.txt", content: []byte("x")},
+		archiveEntry{name: "/etc/cron.d/evil", content: []byte("x")},
+		archiveEntry{name: "C:\\evil.txt", content: []byte("x")},
+		archiveEntry{name: "docs/../../evil.txt", content: []byte("x")},
+		archiveEntry{name: "good.txt", content: []byte("x")},
+	)
+	job, tree, _ := runImport(t, archive, testLimits)
+
+	if job.Status != JobCompletedWithErrors || len(job.Failures) != 4 {
+		t.Fatalf("expected 4 failed entries, got %+v", job)
+	}
+	if len(tree.files) != 1 || len(tree.folders) != 0 {
+		t.Fatalf("expected only good.txt to be created, got %v and %v", tree.files, tree.folders)
+	}
+}
+
+func TestImportLimits(t *testing.T) {
+	zeros := func(size int) []byte { return make([]byte, size) }
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		limits  config.Import
+		failed  bool
+	}{
+		{
+			name:    "small entries compress beyond the ratio",
+			archive: func(t *testing.T) []byte { return zipArchive(t, archiveEntry{name: "a", content: zeros(512 << 10)}) },
+			limits:  testLimits,
+		},
+		{
+			name:    "an entry expands beyond the ratio",
+			archive: func(t *testing.T) []byte { return zipArchive(t, archiveEntry{name: "bomb", content: zeros(4 << 20)}) },
+			limits:  testLimits,
+			failed:  true,
+		},
+		{
+			name:    "without a ratio only the budget applies",
+			archive: func(t *testing.T) []byte { return zipArchive(t, archiveEntry{name: "a", content: zeros(4 << 20)}) },
+			limits:  config.Import{MaxArchiveBytes: 1 << 30, MaxExtractedBytes: 8 << 20},
+		},
+		{
+			name: "entries together exceed the budget",
+			archive: func(t *testing.T) []byte {
+				var entries []archiveEntry
+				for _, name := range []string{"a", "b", "c"} {
+					entries = append(entries, archiveEntry{name: name, content: zeros(3 << 20)})
+				}
+				return tarGzArchive(t, entries...)
+			},
+			limits: testLimits,
+			failed: true,
+		},
+		{
+			name: "an archive of many small entries",
+			archive: func(t *testing.T) []byte {
+				var entries []archiveEntry
+				for i := 0; i < 101; i++ {
+					entries = append(entries, archiveEntry{name: fmt.Sprintf("%d/%d", i%10, i), content: zeros(1)})
+				}
+				return zipArchive(t, entries...)
+			},
+			limits: testLimits,
+			failed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job, tree, blobs := runImport(t, test.archive(t), test.limits)
+
+			if !test.failed {
+				if job.Status != JobCompleted {
+					t.Fatalf("expected the import to complete, got %+v", job)
+				}
+				return
+			}
+			if job.Status != JobFailed || !strings.Contains(job.Error, errLimits.Error()) {
+				t.Fatalf("expected the import to fail, got %+v", job)
+			}
+			// a file whose content was cut off is removed again
+			for id := range tree.files {
+				if _, stored := blobs.sizes[id]; !stored && !tree.deleted[id] {
+					t.Fatalf("file %d was kept without content", id)
+				}
+			}
+			if job.BytesExtracted > test.limits.MaxExtractedBytes {
+				t.Fatalf("extracted %d bytes with a budget of %d", job.BytesExtracted, test.limits.MaxExtractedBytes)
+			}
+		})
+	}
+}
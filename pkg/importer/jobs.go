@@ -0,0 +1,96 @@
+package importer
+
+import (
+	"sync"
+	"time"
+)
+
+// JobStatus is the state of an import
+type JobStatus string
+
+const (
+	JobRunning JobStatus = "RUNNING"
+	// JobCompleted imported every entry
+	JobCompleted JobStatus = "COMPLETED"
+	// JobCompletedWithErrors imported the archive but some entries failed
+	JobCompletedWithErrors JobStatus = "COMPLETED_WITH_ERRORS"
+	// JobFailed stopped the import, entries imported before stay in place
+	JobFailed JobStatus = "FAILED"
+)
+
+// finished jobs are kept this long for clients polling their outcome
+const jobRetention = 24 * time.Hour
+
+// EntryFailure is an archive entry that could not be imported
+type EntryFailure struct {
+	Path  string
+	Error string
+}
+
+// Job is the progress of one import, jobs returned to callers are snapshots
+type Job struct {
+	ID       string
+	UserId   uint
+	FolderId uint
+	Status   JobStatus
+	// Error is the reason of a failed job
+	Error string
+
+	EntriesProcessed int
+	FilesImported    int
+	// FoldersImported counts the folders created or merged into
+	FoldersImported int
+	BytesExtracted  int64
+	Failures        []EntryFailure
+
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// jobs keeps the jobs of this instance in memory
+type jobs struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func newJobs() *jobs {
+	return &jobs{jobs: make(map[string]*Job)}
+}
+
+func (j *jobs) add(job *Job) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// forget jobs nobody asked for in a while
+	for id, old := range j.jobs {
+		if old.FinishedAt != nil && time.Since(*old.FinishedAt) > jobRetention {
+			delete(j.jobs, id)
+		}
+	}
+	j.jobs[job.ID] = job
+}
+
+// update() changes the job under the lock
+func (j *jobs) update(id string, fn func(job *Job)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if job, ok := j.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// get() returns a snapshot of the job
+func (j *jobs) get(id string) (*Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return nil, false
+	}
+
+	snapshot := *job
+	snapshot.Failures = append([]EntryFailure(nil), job.Failures...)
+	return &snapshot, true
+}
@@ -2,28 +2,49 @@ package entity
 
 import (
 	"gorm.io/gorm"
+	"time"
 )
 
 type Folder struct {
 	gorm.Model
 
-	Name     string  `json:"name" gorm:"not null"`
-	ParentId *uint   `json:"parentId" gorm:"index"`
-	Parent   *Folder `json:"parent,omitempty"`
-	UserId   uint    `json:"userId" gorm:"not null;index"`
-	Path     string  `json:"path" gorm:"-"`
+	Name     string   `json:"name" gorm:"not null"`
+	NameKey  string   `json:"-" gorm:"not null"`
+	ParentId *uint    `json:"parentId" gorm:"index"`
+	Parent   *Folder  `json:"parent,omitempty"`
+	UserId   uint     `json:"userId" gorm:"not null;index"`
+	Version  uint     `json:"version" gorm:"not null;default:1"`
+	Metadata Metadata `json:"metadata" gorm:"not null;default:'{}'"`
+	Path     string   `json:"path" gorm:"-"`
 }
 
 type File struct {
 	gorm.Model
 
-	Name      string  `json:"name" gorm:"not null""`
-	FolderId  uint    `json:"folderId" gorm:"not null;index"`
-	Folder    *Folder `json:"folder"`
-	Type      string  `json:"type"`
-	Extension string  `json:"extension"`
-	Size      uint64  `json:"size"`
-	Modified  string  `json:"modified"`
-	UserId    uint    `json:"userId" gorm:"not null;index"`
-	Path      string  `json:"path" gorm:"-"`
+	Name      string   `json:"name" gorm:"not null"`
+	NameKey   string   `json:"-" gorm:"not null"`
+	FolderId  uint     `json:"folderId" gorm:"not null;index"`
+	Folder    *Folder  `json:"folder"`
+	Type      string   `json:"type"`
+	Extension string   `json:"extension"`
+	Size      uint64   `json:"size"`
+	Modified  string   `json:"modified"`
+	UserId    uint     `json:"userId" gorm:"not null;index"`
+	Version   uint     `json:"version" gorm:"not null;default:1"`
+	Metadata  Metadata `json:"metadata" gorm:"not null;default:'{}'"`
+	Path      string   `json:"path" gorm:"-"`
+	// ReplacedById is the file that replaced this one, which is kept soft-deleted as its prior version
+	ReplacedById *uint `json:"-"`
+}
+
+// Tag is a label of the user, attached to any number of files and folders.
+// Tags are removed permanently, so they have no DeletedAt.
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Name   string `json:"name" gorm:"not null"`
+	Color  string `json:"color" gorm:"not null;default:''"`
+	UserId uint   `json:"userId" gorm:"not null"`
 }
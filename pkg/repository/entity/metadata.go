@@ -0,0 +1,52 @@
+package entity
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Metadata is the free-form document of a folder or file, stored as jsonb.
+// Numbers are read as json.Number so large integers keep their precision.
+type Metadata map[string]interface{}
+
+// Value() stores a nil document as an empty one
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	encoded, err := json.Marshal(map[string]interface{}(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+func (m *Metadata) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = Metadata{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into metadata", value)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoded := Metadata{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return err
+	}
+	*m = decoded
+	return nil
+}
+
+func (Metadata) GormDataType() string {
+	return "jsonb"
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrConflict is wrapped by every error caused by a unique constraint of the database
+var ErrConflict = errors.New("conflict")
+
+// NameKey maps a name to the key that is unique among the siblings of a folder, names of the same key collide
+type NameKey func(name string) string
+
+// ErrStaleVersion is returned by updates of an item that was changed since it was read
+var ErrStaleVersion = fmt.Errorf("%w: the item was changed since it was read", ErrConflict)
+
+// postgres error code of unique_violation
+const uniqueViolation = "23505"
+
+// conflicts describes the unique indexes of the schema
+var conflicts = map[string]string{
+	"idx_folders_unique_name":     "a folder with this name already exists in the parent folder",
+	"idx_files_unique_name":       "a file with this name already exists in the folder",
+	"idx_folders_unique_name_key": "a folder with this name already exists in the parent folder",
+	"idx_files_unique_name_key":   "a file with this name already exists in the folder",
+	"idx_folders_unique_root":     "the user already has a root folder",
+	"idx_tags_unique_name":        "a tag with this name already exists",
+}
+
+// translateError() turns unique violations into errors wrapping ErrConflict and leaves other errors untouched
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return err
+	}
+
+	if message, ok := conflicts[pgErr.ConstraintName]; ok {
+		return fmt.Errorf("%w: %s", ErrConflict, message)
+	}
+	return fmt.Errorf("%w: %s", ErrConflict, pgErr.ConstraintName)
+}
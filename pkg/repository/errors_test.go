@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"testing"
+)
+
+func TestTranslateError(t *testing.T) {
+	other := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		err      error
+		conflict bool
+		message  string
+	}{
+		{name: "known index", err: &pgconn.PgError{Code: uniqueViolation, ConstraintName: "idx_files_unique_name_key"},
+			conflict: true, message: "a file with this name already exists in the folder"},
+		{name: "wrapped", err: fmt.Errorf("insert: %w", &pgconn.PgError{Code: uniqueViolation, ConstraintName: "idx_folders_unique_root"}),
+			conflict: true, message: "the user already has a root folder"},
+		{name: "unknown index", err: &pgconn.PgError{Code: uniqueViolation, ConstraintName: "idx_new"},
+			conflict: true, message: "idx_new"},
+		{name: "other violation", err: &pgconn.PgError{Code: "23503", ConstraintName: "fk_files_folder"}},
+		{name: "no postgres error", err: other},
+		{name: "nil"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			translated := translateError(test.err)
+			if errors.Is(translated, ErrConflict) != test.conflict {
+				t.Fatalf("expected conflict %v, got %v", test.conflict, translated)
+			}
+			if !test.conflict && translated != test.err {
+				t.Fatalf("expected the error untouched, got %v", translated)
+			}
+			if test.conflict && !strings.Contains(translated.Error(), test.message) {
+				t.Fatalf("expected %q in %q", test.message, translated.Error())
+			}
+		})
+	}
+}
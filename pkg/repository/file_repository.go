@@ -1,57 +1,159 @@
 package repository
 
 import (
+	"context"
+	"github.com/potatowhite/books/file-service/logging"
 	"github.com/potatowhite/books/file-service/pkg/repository/entity"
 	"gorm.io/gorm"
-	"log"
-	"os"
 )
 
 var (
-	logger = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
+	logger = logging.For("repository")
 )
 
-func NewFileRepository(db *gorm.DB) FileRepository {
-	return &fileRepository{db: db}
+func NewFileRepository(db *gorm.DB, timeouts Timeouts, nameKey NameKey) FileRepository {
+	return &fileRepository{db: db, timeouts: timeouts, nameKey: nameKey}
 }
 
 type FileRepository interface {
-	CreateFile(userId uint, name string, folderId uint) (*entity.File, error)
-	UpdateFile(userId uint, file *entity.File) error
-	DeleteFile(userId uint, id uint) (bool, error)
-	GetFile(userId uint, id uint) (*entity.File, error)
-	GetFileByNameAndFolderId(userId uint, name string, folderId uint) (*entity.File, error)
-	GetFilesByFolderId(userId uint, folderId uint) ([]*entity.File, error)
+	CreateFile(ctx context.Context, userId uint, name string, folderId uint) (*entity.File, error)
+	CopyFile(ctx context.Context, userId uint, source *entity.File, name string, folderId uint) (*entity.File, error)
+	UpdateFile(ctx context.Context, userId uint, file *entity.File) error
+	DeleteFile(ctx context.Context, userId uint, id uint) (bool, error)
+	GetFile(ctx context.Context, userId uint, id uint) (*entity.File, error)
+	GetFileByNameAndFolderId(ctx context.Context, userId uint, name string, folderId uint) (*entity.File, error)
+	ExistsFileNameFold(ctx context.Context, userId uint, name string, folderId uint, excludeId uint) (bool, error)
+	GetFilesByFolderId(ctx context.Context, userId uint, folderId uint) ([]*entity.File, error)
+	GetFilesPageByFolderId(ctx context.Context, userId uint, folderId uint, afterId uint, limit int) ([]*entity.File, error)
+	GetUsage(ctx context.Context, userId uint) (count int64, bytes int64, err error)
+	FindByMetadata(ctx context.Context, userId uint, filters []MetadataFilter, limit int) ([]*entity.File, error)
+	MarkReplaced(ctx context.Context, userId uint, id uint, replacedById uint) error
+	GetFileVersions(ctx context.Context, userId uint, id uint) ([]*entity.File, error)
+	RestoreFile(ctx context.Context, userId uint, id uint) error
+	GetPurgeableFileIds(ctx context.Context, userId uint, limit int) ([]uint, error)
+	PurgeFiles(ctx context.Context, userId uint, ids []uint) (int64, error)
 }
 type fileRepository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	timeouts Timeouts
+	nameKey  NameKey
 }
 
-func (f *fileRepository) CreateFile(userId uint, name string, folderId uint) (*entity.File, error) {
+func (f *fileRepository) CreateFile(ctx context.Context, userId uint, name string, folderId uint) (*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "CreateFile")
+	defer cancel()
+
 	create := &entity.File{
 		Name:     name,
+		NameKey:  f.nameKey(name),
 		FolderId: folderId,
 		UserId:   userId,
 	}
 
-	if err := f.db.Create(create).Error; err != nil {
-		return nil, err
+	if err := conn(ctx, f.db).Create(create).Error; err != nil {
+		return nil, translateError(err)
 	}
 
 	return create, nil
 }
 
-func (f *fileRepository) UpdateFile(userId uint, file *entity.File) error {
-	err := f.db.Save(file).Error
+// CopyFile creates a file with the metadata of source
+func (f *fileRepository) CopyFile(ctx context.Context, userId uint, source *entity.File, name string, folderId uint) (*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "CopyFile")
+	defer cancel()
+
+	create := &entity.File{
+		Name:      name,
+		NameKey:   f.nameKey(name),
+		FolderId:  folderId,
+		Type:      source.Type,
+		Extension: source.Extension,
+		Size:      source.Size,
+		Modified:  source.Modified,
+		Metadata:  source.Metadata,
+		UserId:    userId,
+	}
+
+	if err := conn(ctx, f.db).Create(create).Error; err != nil {
+		return nil, translateError(err)
+	}
+
+	return create, nil
+}
+
+func (f *fileRepository) UpdateFile(ctx context.Context, userId uint, file *entity.File) error {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "UpdateFile")
+	defer cancel()
+
+	// only applies when nobody else updated the file since it was read
+	result := conn(ctx, f.db).Model(&entity.File{}).
+		Where("user_id = ? AND id = ? AND version = ?", userId, file.ID, file.Version).
+		Updates(map[string]interface{}{
+			"name":      file.Name,
+			"name_key":  f.nameKey(file.Name),
+			"folder_id": file.FolderId,
+			"type":      file.Type,
+			"extension": file.Extension,
+			"size":      file.Size,
+			"modified":  file.Modified,
+			"metadata":  file.Metadata,
+			"version":   gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return translateError(result.Error)
+	} else if result.RowsAffected == 0 {
+		return ErrStaleVersion
+	}
+
+	file.Version++
+	return nil
+}
+
+// MarkReplaced keeps the deleted file id as a prior version of replacedById
+func (f *fileRepository) MarkReplaced(ctx context.Context, userId uint, id uint, replacedById uint) error {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "MarkReplaced")
+	defer cancel()
+
+	return conn(ctx, f.db).Unscoped().Model(&entity.File{}).
+		Where("user_id = ? AND id = ?", userId, id).
+		Update("replaced_by_id", replacedById).Error
+}
+
+// GetFileVersions returns the prior versions of the file, the most recently replaced first
+func (f *fileRepository) GetFileVersions(ctx context.Context, userId uint, id uint) ([]*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFileVersions")
+	defer cancel()
+
+	var versions []*entity.File
+	err := conn(ctx, f.db).Raw("WITH RECURSIVE versions AS ( SELECT * FROM files WHERE user_id = ? AND replaced_by_id = ? UNION ALL SELECT f.* FROM files f JOIN versions v ON f.replaced_by_id = v.id ) SELECT * FROM versions ORDER BY deleted_at DESC, id DESC", userId, id).Scan(&versions).Error
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return versions, nil
+}
+
+// RestoreFile makes a prior version a live file again, it fails with ErrConflict when its name is taken
+func (f *fileRepository) RestoreFile(ctx context.Context, userId uint, id uint) error {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "RestoreFile")
+	defer cancel()
+
+	result := conn(ctx, f.db).Unscoped().Model(&entity.File{}).
+		Where("user_id = ? AND id = ? AND deleted_at IS NOT NULL", userId, id).
+		Updates(map[string]interface{}{"deleted_at": nil, "replaced_by_id": nil})
+	if result.Error != nil {
+		return translateError(result.Error)
+	} else if result.RowsAffected == 0 {
+		return ErrStaleVersion
+	}
 	return nil
 }
 
-func (f *fileRepository) DeleteFile(userId uint, id uint) (bool, error) {
-	tx := f.db.Where("user_id = ? AND id = ?", userId, id).Delete(&entity.File{})
+func (f *fileRepository) DeleteFile(ctx context.Context, userId uint, id uint) (bool, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "DeleteFile")
+	defer cancel()
+
+	tx := conn(ctx, f.db).Where("user_id = ? AND id = ?", userId, id).Delete(&entity.File{})
 
 	if tx.Error != nil {
 		return false, tx.Error
@@ -64,10 +166,13 @@ func (f *fileRepository) DeleteFile(userId uint, id uint) (bool, error) {
 	return true, nil
 }
 
-func (f *fileRepository) GetFile(userId uint, id uint) (*entity.File, error) {
+func (f *fileRepository) GetFile(ctx context.Context, userId uint, id uint) (*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFile")
+	defer cancel()
+
 	var file entity.File
 
-	tx := f.db.Where("user_id = ? AND id = ?", userId, id).First(&file)
+	tx := conn(ctx, f.db).Where("user_id = ? AND id = ?", userId, id).First(&file)
 
 	if tx.Error != nil {
 		if tx.Error == gorm.ErrRecordNotFound {
@@ -79,10 +184,13 @@ func (f *fileRepository) GetFile(userId uint, id uint) (*entity.File, error) {
 	return &file, nil
 }
 
-func (f *fileRepository) GetFileByNameAndFolderId(userId uint, name string, folderId uint) (*entity.File, error) {
+func (f *fileRepository) GetFileByNameAndFolderId(ctx context.Context, userId uint, name string, folderId uint) (*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFileByNameAndFolderId")
+	defer cancel()
+
 	var file entity.File
 
-	tx := f.db.Where("user_id = ? AND name = ? AND folder_id = ?", userId, name, folderId).First(&file)
+	tx := conn(ctx, f.db).Where("user_id = ? AND name = ? AND folder_id = ?", userId, name, folderId).First(&file)
 
 	if tx.Error != nil {
 		if tx.Error == gorm.ErrRecordNotFound {
@@ -94,8 +202,107 @@ func (f *fileRepository) GetFileByNameAndFolderId(userId uint, name string, fold
 	return &file, nil
 }
 
-func (f *fileRepository) GetFilesByFolderId(userId uint, folderId uint) ([]*entity.File, error) {
+// ExistsFileNameFold reports whether the folder has another file with the name key of name,
+// so names differing only in case are found when the naming policy ignores case
+func (f *fileRepository) ExistsFileNameFold(ctx context.Context, userId uint, name string, folderId uint, excludeId uint) (bool, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "ExistsFileNameFold")
+	defer cancel()
+
+	var count int64
+	err := conn(ctx, f.db).Model(&entity.File{}).
+		Where("user_id = ? AND folder_id = ? AND name_key = ? AND id <> ?", userId, folderId, f.nameKey(name), excludeId).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetUsage() counts the files of the user and sums their sizes
+func (f *fileRepository) GetUsage(ctx context.Context, userId uint) (int64, int64, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetUsage")
+	defer cancel()
+
+	var usage struct {
+		Count int64
+		Bytes int64
+	}
+	err := conn(ctx, f.db).Model(&entity.File{}).
+		Select("count(*) AS count, coalesce(sum(size), 0) AS bytes").
+		Where("user_id = ?", userId).
+		Scan(&usage).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return usage.Count, usage.Bytes, nil
+}
+
+func (f *fileRepository) GetFilesByFolderId(ctx context.Context, userId uint, folderId uint) ([]*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFilesByFolderId")
+	defer cancel()
+
 	var files []*entity.File
-	f.db.Where("user_id = ? AND folder_id = ?", userId, folderId).Find(&files)
+	if err := conn(ctx, f.db).Where("user_id = ? AND folder_id = ?", userId, folderId).Find(&files).Error; err != nil {
+		return nil, err
+	}
 	return files, nil
 }
+
+// GetFilesPageByFolderId returns up to limit files of the folder with an id above afterId, ordered by id
+func (f *fileRepository) GetFilesPageByFolderId(ctx context.Context, userId uint, folderId uint, afterId uint, limit int) ([]*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFilesPageByFolderId")
+	defer cancel()
+
+	var files []*entity.File
+	err := conn(ctx, f.db).Where("user_id = ? AND folder_id = ? AND id > ?", userId, folderId, afterId).Order("id").Limit(limit).Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// FindByMetadata returns up to limit files of the user matching every filter, anywhere in the tree
+func (f *fileRepository) FindByMetadata(ctx context.Context, userId uint, filters []MetadataFilter, limit int) ([]*entity.File, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "FindByMetadata")
+	defer cancel()
+
+	query := conn(ctx, f.db).Where("user_id = ?", userId)
+	for _, filter := range filters {
+		query = query.Where(filter)
+	}
+
+	var files []*entity.File
+	if err := query.Order("id").Limit(limit).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// GetPurgeableFileIds returns up to limit ids of files of the user, including soft-deleted ones.
+func (f *fileRepository) GetPurgeableFileIds(ctx context.Context, userId uint, limit int) ([]uint, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetPurgeableFileIds")
+	defer cancel()
+
+	var ids []uint
+	err := conn(ctx, f.db).Unscoped().Model(&entity.File{}).Where("user_id = ?", userId).Order("id").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// PurgeFiles permanently removes the files of the user, including soft-deleted ones.
+func (f *fileRepository) PurgeFiles(ctx context.Context, userId uint, ids []uint) (int64, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "PurgeFiles")
+	defer cancel()
+
+	result := conn(ctx, f.db).Exec("DELETE FROM files WHERE user_id = ? AND id IN ?", userId, ids)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
@@ -1,37 +1,52 @@
 package repository
 
 import (
-	"fmt"
+	"context"
 	"github.com/potatowhite/books/file-service/pkg/repository/entity"
 	"gorm.io/gorm"
 )
 
-func NewFolderRepository(db *gorm.DB) FolderRepository {
+func NewFolderRepository(db *gorm.DB, timeouts Timeouts, nameKey NameKey) FolderRepository {
 	return &folderRepository{
-		db: db,
+		db:       db,
+		timeouts: timeouts,
+		nameKey:  nameKey,
 	}
 }
 
 type FolderRepository interface {
-	CreateRootFolder(userId uint) (*entity.Folder, error)
-	CreateFolder(userId uint, name string, parentId uint) (*entity.Folder, error)
-	UpdateFolder(userId uint, folder *entity.Folder) error
-	DeleteFolder(userId uint, id uint) (bool, error)
+	CreateRootFolder(ctx context.Context, userId uint) (*entity.Folder, error)
+	CreateFolder(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error)
+	UpdateFolder(ctx context.Context, userId uint, folder *entity.Folder) error
+	DeleteFolder(ctx context.Context, userId uint, id uint) (bool, error)
+	DeleteFolderTree(ctx context.Context, userId uint, id uint) (bool, []uint, error)
 
-	GetRootFolder(userId uint) (*entity.Folder, error)
-	GetFolder(userId uint, id uint) (*entity.Folder, error)
-	GetChildren(userId uint, id uint) ([]*entity.Folder, error)
-	GetFolderByNameAndParentId(userId uint, name string, parentId uint) (*entity.Folder, error)
-	GetPathOrNil(userId uint, id uint) (*string, error)
-	DeleteAllFolders(id uint) (int64, error)
+	GetRootFolder(ctx context.Context, userId uint) (*entity.Folder, error)
+	GetFolder(ctx context.Context, userId uint, id uint) (*entity.Folder, error)
+	GetChildren(ctx context.Context, userId uint, id uint) ([]*entity.Folder, error)
+	GetChildrenPage(ctx context.Context, userId uint, id uint, afterId uint, limit int) ([]*entity.Folder, error)
+	GetFolderByNameAndParentId(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error)
+	ExistsFolderNameFold(ctx context.Context, userId uint, name string, parentId uint, excludeId uint) (bool, error)
+	GetPathOrNil(ctx context.Context, userId uint, id uint) (*string, error)
+	PurgeLeafFolders(ctx context.Context, userId uint, limit int) (int64, error)
+	IsDescendant(ctx context.Context, userId uint, ancestorId uint, id uint) (bool, error)
+	CountFolders(ctx context.Context, userId uint) (int64, error)
+	FindByMetadata(ctx context.Context, userId uint, filters []MetadataFilter, limit int) ([]*entity.Folder, error)
 }
 
 type folderRepository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	timeouts Timeouts
+	nameKey  NameKey
 }
 
-func (f *folderRepository) DeleteAllFolders(id uint) (int64, error) {
-	result := f.db.Where("user_id = ?", id).Delete(&entity.Folder{})
+// PurgeLeafFolders permanently removes up to limit folders of the user that have no children left,
+// including soft-deleted ones. Calling it repeatedly removes the whole tree bottom-up.
+func (f *folderRepository) PurgeLeafFolders(ctx context.Context, userId uint, limit int) (int64, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "PurgeLeafFolders")
+	defer cancel()
+
+	result := conn(ctx, f.db).Exec("DELETE FROM folders WHERE id IN (SELECT p.id FROM folders p WHERE p.user_id = ? AND NOT EXISTS (SELECT 1 FROM folders c WHERE c.parent_id = p.id) LIMIT ?)", userId, limit)
 	if result.Error != nil {
 		return 0, result.Error
 	}
@@ -39,20 +54,37 @@ func (f *folderRepository) DeleteAllFolders(id uint) (int64, error) {
 	return result.RowsAffected, nil
 }
 
-func (f *folderRepository) GetPathOrNil(userId uint, id uint) (*string, error) {
+// IsDescendant reports whether the folder id lies somewhere below ancestorId
+func (f *folderRepository) IsDescendant(ctx context.Context, userId uint, ancestorId uint, id uint) (bool, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "IsDescendant")
+	defer cancel()
+
+	var found bool
+	err := conn(ctx, f.db).Raw("WITH RECURSIVE ancestors AS ( SELECT id, parent_id FROM folders WHERE id = ? AND user_id = ? UNION ALL SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON a.parent_id = f.id ) SELECT EXISTS (SELECT 1 FROM ancestors WHERE parent_id = ?)", id, userId, ancestorId).Scan(&found).Error
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+func (f *folderRepository) GetPathOrNil(ctx context.Context, userId uint, id uint) (*string, error) {
 	// exisiting folder
-	folder, err := f.GetFolder(userId, id)
+	folder, err := f.GetFolder(ctx, userId, id)
 	if err != nil {
 		return nil, err
 	}
 
 	// get path
-	return f.GetPathCTE(folder), nil
+	return f.GetPathCTE(ctx, folder), nil
 }
 
-func (f *folderRepository) GetFolderByNameAndParentId(userId uint, name string, parentId uint) (*entity.Folder, error) {
+func (f *folderRepository) GetFolderByNameAndParentId(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFolderByNameAndParentId")
+	defer cancel()
+
 	var folder entity.Folder
-	err := f.db.Where("user_id = ? AND name = ? AND parent_id = ?", userId, name, parentId).First(&folder).Error
+	err := conn(ctx, f.db).Where("user_id = ? AND name = ? AND parent_id = ?", userId, name, parentId).First(&folder).Error
 	if err != nil {
 		return nil, err
 	}
@@ -60,8 +92,28 @@ func (f *folderRepository) GetFolderByNameAndParentId(userId uint, name string,
 	return &folder, nil
 }
 
-func (f *folderRepository) DeleteFolder(userId uint, id uint) (bool, error) {
-	result := f.db.Where("id = ?", id).Delete(&entity.Folder{})
+// ExistsFolderNameFold reports whether the parent has another folder with the name key of name,
+// so names differing only in case are found when the naming policy ignores case
+func (f *folderRepository) ExistsFolderNameFold(ctx context.Context, userId uint, name string, parentId uint, excludeId uint) (bool, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "ExistsFolderNameFold")
+	defer cancel()
+
+	var count int64
+	err := conn(ctx, f.db).Model(&entity.Folder{}).
+		Where("user_id = ? AND parent_id = ? AND name_key = ? AND id <> ?", userId, parentId, f.nameKey(name), excludeId).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (f *folderRepository) DeleteFolder(ctx context.Context, userId uint, id uint) (bool, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "DeleteFolder")
+	defer cancel()
+
+	result := conn(ctx, f.db).Where("user_id = ? AND id = ?", userId, id).Delete(&entity.Folder{})
 	if result.Error != nil {
 		return false, result.Error
 	}
@@ -69,13 +121,64 @@ func (f *folderRepository) DeleteFolder(userId uint, id uint) (bool, error) {
 	return result.RowsAffected > 0, nil
 }
 
-func (f *folderRepository) UpdateFolder(userId uint, folder *entity.Folder) error {
-	return f.db.Model(&entity.Folder{}).Where("id = ?", folder.ID).Updates(folder).Error
+// DeleteFolderTree soft-deletes the folder with every folder and file below it and returns the ids of the
+// deleted files and their prior versions, whose contents are no longer needed. Run it in a transaction.
+func (f *folderRepository) DeleteFolderTree(ctx context.Context, userId uint, id uint) (bool, []uint, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "DeleteFolderTree")
+	defer cancel()
+
+	var folderIds []uint
+	err := conn(ctx, f.db).Raw("WITH RECURSIVE tree AS ( SELECT id FROM folders WHERE user_id = ? AND id = ? AND deleted_at IS NULL UNION ALL SELECT f.id FROM folders f JOIN tree t ON f.parent_id = t.id WHERE f.deleted_at IS NULL ) SELECT id FROM tree", userId, id).Scan(&folderIds).Error
+	if err != nil || len(folderIds) == 0 {
+		return false, nil, err
+	}
+
+	var fileIds []uint
+	err = conn(ctx, f.db).Raw("WITH RECURSIVE contents AS ( SELECT id FROM files WHERE user_id = ? AND folder_id IN ? AND deleted_at IS NULL UNION ALL SELECT f.id FROM files f JOIN contents c ON f.replaced_by_id = c.id ) SELECT id FROM contents", userId, folderIds).Scan(&fileIds).Error
+	if err != nil {
+		return false, nil, err
+	}
+
+	if err := conn(ctx, f.db).Where("user_id = ? AND folder_id IN ?", userId, folderIds).Delete(&entity.File{}).Error; err != nil {
+		return false, nil, err
+	}
+	if err := conn(ctx, f.db).Where("user_id = ? AND id IN ?", userId, folderIds).Delete(&entity.Folder{}).Error; err != nil {
+		return false, nil, err
+	}
+
+	return true, fileIds, nil
+}
+
+func (f *folderRepository) UpdateFolder(ctx context.Context, userId uint, folder *entity.Folder) error {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "UpdateFolder")
+	defer cancel()
+
+	// only applies when nobody else updated the folder since it was read
+	result := conn(ctx, f.db).Model(&entity.Folder{}).
+		Where("user_id = ? AND id = ? AND version = ?", userId, folder.ID, folder.Version).
+		Updates(map[string]interface{}{
+			"name":      folder.Name,
+			"name_key":  f.nameKey(folder.Name),
+			"parent_id": folder.ParentId,
+			"metadata":  folder.Metadata,
+			"version":   gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return translateError(result.Error)
+	} else if result.RowsAffected == 0 {
+		return ErrStaleVersion
+	}
+
+	folder.Version++
+	return nil
 }
 
-func (f *folderRepository) GetChildren(userId uint, id uint) ([]*entity.Folder, error) {
+func (f *folderRepository) GetChildren(ctx context.Context, userId uint, id uint) ([]*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetChildren")
+	defer cancel()
+
 	var children []*entity.Folder
-	err := f.db.Where("user_id = ? AND parent_id = ?", userId, id).Find(&children).Error
+	err := conn(ctx, f.db).Where("user_id = ? AND parent_id = ?", userId, id).Find(&children).Error
 	if err != nil {
 		return nil, err
 	}
@@ -83,9 +186,26 @@ func (f *folderRepository) GetChildren(userId uint, id uint) ([]*entity.Folder,
 	return children, nil
 }
 
-func (f *folderRepository) GetFolder(userId uint, id uint) (*entity.Folder, error) {
+// GetChildrenPage returns up to limit child folders with an id above afterId, ordered by id
+func (f *folderRepository) GetChildrenPage(ctx context.Context, userId uint, id uint, afterId uint, limit int) ([]*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetChildrenPage")
+	defer cancel()
+
+	var children []*entity.Folder
+	err := conn(ctx, f.db).Where("user_id = ? AND parent_id = ? AND id > ?", userId, id, afterId).Order("id").Limit(limit).Find(&children).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return children, nil
+}
+
+func (f *folderRepository) GetFolder(ctx context.Context, userId uint, id uint) (*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetFolder")
+	defer cancel()
+
 	var folder entity.Folder
-	err := f.db.Where("id = ?", id).First(&folder).Error
+	err := conn(ctx, f.db).Where("user_id = ? AND id = ?", userId, id).First(&folder).Error
 	if err != nil {
 		return nil, err
 	}
@@ -93,9 +213,12 @@ func (f *folderRepository) GetFolder(userId uint, id uint) (*entity.Folder, erro
 	return &folder, nil
 }
 
-func (f *folderRepository) GetRootFolder(userId uint) (*entity.Folder, error) {
+func (f *folderRepository) GetRootFolder(ctx context.Context, userId uint) (*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetRootFolder")
+	defer cancel()
+
 	var rootFolder entity.Folder
-	err := f.db.Where("user_id = ? AND parent_id IS NULL", userId).First(&rootFolder).Error
+	err := conn(ctx, f.db).Where("user_id = ? AND parent_id IS NULL", userId).First(&rootFolder).Error
 	if err != nil {
 		return nil, err
 	}
@@ -104,42 +227,82 @@ func (f *folderRepository) GetRootFolder(userId uint) (*entity.Folder, error) {
 }
 
 // get the path of a folder(cte version) by traversing the parent folders
-func (f *folderRepository) GetPathCTE(folder *entity.Folder) *string {
+func (f *folderRepository) GetPathCTE(ctx context.Context, folder *entity.Folder) *string {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "GetPathCTE")
+	defer cancel()
+
 	var path string
-	err := f.db.Raw("WITH RECURSIVE cte AS ( SELECT id, name, parent_id, name AS full_path FROM public.folders WHERE id = ? and user_id = ? UNION ALL SELECT f.id, f.name, f.parent_id,  f.name || '/' || cte.full_path FROM public.folders f JOIN cte ON cte.parent_id = f.id ) SELECT full_path FROM cte WHERE parent_id is null", folder.ID, folder.UserId).Scan(&path).Error
+	err := conn(ctx, f.db).Raw("WITH RECURSIVE cte AS ( SELECT id, name, parent_id, name AS full_path FROM public.folders WHERE id = ? and user_id = ? UNION ALL SELECT f.id, f.name, f.parent_id,  f.name || '/' || cte.full_path FROM public.folders f JOIN cte ON cte.parent_id = f.id ) SELECT full_path FROM cte WHERE parent_id is null", folder.ID, folder.UserId).Scan(&path).Error
 
 	if err != nil {
-		logger.Println(fmt.Sprintf("failed to get path of folder %d: %v", folder.ID, err))
+		logger.ErrorContext(ctx, "failed to get path of folder", "folder_id", folder.ID, "error", err)
 		return nil
 	}
 	return &path
 }
 
-func (f *folderRepository) CreateRootFolder(userId uint) (*entity.Folder, error) {
+func (f *folderRepository) CreateRootFolder(ctx context.Context, userId uint) (*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "CreateRootFolder")
+	defer cancel()
+
 	rootFolder := entity.Folder{
 		Name:   "",
 		UserId: userId,
 	}
 
-	err := f.db.Create(&rootFolder).Error
+	err := conn(ctx, f.db).Create(&rootFolder).Error
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &rootFolder, nil
 }
 
-func (f *folderRepository) CreateFolder(userId uint, name string, parentId uint) (*entity.Folder, error) {
+func (f *folderRepository) CreateFolder(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "CreateFolder")
+	defer cancel()
+
 	folder := entity.Folder{
 		Name:     name,
+		NameKey:  f.nameKey(name),
 		ParentId: &parentId,
 		UserId:   userId,
 	}
 
-	err := f.db.Create(&folder).Error
+	err := conn(ctx, f.db).Create(&folder).Error
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &folder, nil
 }
+
+// CountFolders() counts the folders of the user, the root folder included
+func (f *folderRepository) CountFolders(ctx context.Context, userId uint) (int64, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "CountFolders")
+	defer cancel()
+
+	var count int64
+	if err := conn(ctx, f.db).Model(&entity.Folder{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// FindByMetadata returns up to limit folders of the user matching every filter, anywhere in the tree
+func (f *folderRepository) FindByMetadata(ctx context.Context, userId uint, filters []MetadataFilter, limit int) ([]*entity.Folder, error) {
+	ctx, cancel := f.timeouts.withTimeout(ctx, "FindByMetadata")
+	defer cancel()
+
+	query := conn(ctx, f.db).Where("user_id = ?", userId)
+	for _, filter := range filters {
+		query = query.Where(filter)
+	}
+
+	var folders []*entity.Folder
+	if err := query.Order("id").Limit(limit).Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"encoding/json"
+	"gorm.io/gorm/clause"
+)
+
+// MetadataOp compares a top-level key of the metadata document
+type MetadataOp string
+
+const (
+	// MetadataEquals matches documents whose key holds Value, served by the GIN index
+	MetadataEquals MetadataOp = "EQ"
+	// MetadataExists matches documents having the key with any value, served by the GIN index
+	MetadataExists MetadataOp = "EXISTS"
+	// MetadataGreater and the other ranges compare numbers with numbers and strings with strings,
+	// a value of another type never matches
+	MetadataGreater        MetadataOp = "GT"
+	MetadataGreaterOrEqual MetadataOp = "GTE"
+	MetadataLess           MetadataOp = "LT"
+	MetadataLessOrEqual    MetadataOp = "LTE"
+)
+
+var rangeOperators = map[MetadataOp]string{
+	MetadataGreater:        ">",
+	MetadataGreaterOrEqual: ">=",
+	MetadataLess:           "<",
+	MetadataLessOrEqual:    "<=",
+}
+
+// MetadataFilter is one condition on the metadata document, Value is ignored by MetadataExists
+type MetadataFilter struct {
+	Key   string
+	Op    MetadataOp
+	Value interface{}
+}
+
+// Build() writes the condition on the metadata column.
+// The jsonb key operator ? is written here because gorm takes every ? of a condition string as a placeholder.
+func (f MetadataFilter) Build(builder clause.Builder) {
+	switch f.Op {
+	case MetadataExists:
+		builder.WriteString("metadata ? ")
+		builder.AddVar(builder, f.Key)
+		builder.WriteString("::text")
+	case MetadataEquals:
+		document, err := json.Marshal(map[string]interface{}{f.Key: f.Value})
+		if err != nil {
+			builder.AddError(err)
+			return
+		}
+		builder.WriteString("metadata @> ")
+		builder.AddVar(builder, string(document))
+		builder.WriteString("::jsonb")
+	default:
+		operator, ok := rangeOperators[f.Op]
+		if !ok {
+			builder.WriteString("FALSE")
+			return
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			builder.AddError(err)
+			return
+		}
+		// jsonb orders values of different types by type, so the types have to agree first
+		builder.WriteString("(jsonb_typeof(metadata -> ")
+		builder.AddVar(builder, f.Key)
+		builder.WriteString("::text) = jsonb_typeof(")
+		builder.AddVar(builder, string(value))
+		builder.WriteString("::jsonb) AND metadata -> ")
+		builder.AddVar(builder, f.Key)
+		builder.WriteString("::text " + operator + " ")
+		builder.AddVar(builder, string(value))
+		builder.WriteString("::jsonb)")
+	}
+}
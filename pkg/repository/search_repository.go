@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// SearchOrder sorts search hits, ties are broken by name with folders first
+type SearchOrder string
+
+const (
+	SearchByRelevance     SearchOrder = "RELEVANCE"
+	SearchByNameAsc       SearchOrder = "NAME_ASC"
+	SearchByNameDesc      SearchOrder = "NAME_DESC"
+	SearchByUpdatedAtAsc  SearchOrder = "UPDATED_AT_ASC"
+	SearchByUpdatedAtDesc SearchOrder = "UPDATED_AT_DESC"
+	// sizes only apply to files, folders come after all files
+	SearchBySizeAsc  SearchOrder = "SIZE_ASC"
+	SearchBySizeDesc SearchOrder = "SIZE_DESC"
+)
+
+var searchOrders = map[SearchOrder]string{
+	SearchByRelevance:     "score DESC",
+	SearchByNameAsc:       "lower(name)",
+	SearchByNameDesc:      "lower(name) DESC",
+	SearchByUpdatedAtAsc:  "updated_at",
+	SearchByUpdatedAtDesc: "updated_at DESC",
+	SearchBySizeAsc:       "size NULLS LAST",
+	SearchBySizeDesc:      "size DESC NULLS LAST",
+}
+
+// SearchQuery selects the folders and files below FolderId, nil and empty fields do not filter
+type SearchQuery struct {
+	// Text matches names containing it or similar to it, ignoring case
+	Text string
+	// FolderId is the folder searched below and FolderPath its path, hits get paths below it
+	FolderId   uint
+	FolderPath string
+
+	Folders bool
+	Files   bool
+	// Extensions, Types and the sizes only match files, Types match by prefix such as image/
+	Extensions []string
+	Types      []string
+	MinSize    *uint64
+	MaxSize    *uint64
+
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+
+	Order  SearchOrder
+	Limit  int
+	Offset int
+}
+
+// SearchHit is a matching folder or file with its path and relevance
+type SearchHit struct {
+	Folder *entity.Folder
+	File   *entity.File
+	Path   string
+	Score  float64
+}
+
+func NewSearchRepository(db *gorm.DB, timeouts Timeouts) SearchRepository {
+	return &searchRepository{db: db, timeouts: timeouts}
+}
+
+type SearchRepository interface {
+	Search(ctx context.Context, userId uint, query SearchQuery) ([]*SearchHit, error)
+}
+
+type searchRepository struct {
+	db       *gorm.DB
+	timeouts Timeouts
+}
+
+// searchRow is a hit of either kind, the columns of the other kind are empty
+type searchRow struct {
+	Kind      string
+	Id        uint
+	Name      string
+	ParentId  *uint
+	FolderId  uint
+	Type      string
+	Extension string
+	Size      *uint64
+	Modified  string
+	Metadata  entity.Metadata
+	Version   uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Path      string
+	Score     float64
+}
+
+// Search returns a page of the folders and files matching the query.
+// The tree is walked from FolderId through live folders, so items below a deleted folder are not found.
+func (s *searchRepository) Search(ctx context.Context, userId uint, query SearchQuery) ([]*SearchHit, error) {
+	fileOnly := len(query.Extensions) > 0 || len(query.Types) > 0 || query.MinSize != nil || query.MaxSize != nil
+	searchFolders := query.Folders && !fileOnly
+	if !searchFolders && !query.Files {
+		return nil, nil
+	}
+
+	order, ok := searchOrders[query.Order]
+	if !ok {
+		return nil, fmt.Errorf("unknown search order %q", query.Order)
+	}
+
+	ctx, cancel := s.timeouts.withTimeout(ctx, "Search")
+	defer cancel()
+
+	args := map[string]interface{}{
+		"user":   userId,
+		"folder": query.FolderId,
+		"path":   query.FolderPath,
+		"limit":  query.Limit,
+		"offset": query.Offset,
+	}
+
+	var parts []string
+	if searchFolders {
+		parts = append(parts, "SELECT 'FOLDER' AS kind, f.id, f.name, f.parent_id, CAST(0 AS bigint) AS folder_id, '' AS type, '' AS extension, CAST(NULL AS bigint) AS size, '' AS modified, f.metadata, f.version, f.created_at, f.updated_at, tree.path, "+
+			searchScore("f", query, args)+" AS score FROM tree JOIN folders f ON f.id = tree.id WHERE f.id <> @folder "+
+			searchConditions("f", query, args, false))
+	}
+	if query.Files {
+		parts = append(parts, "SELECT 'FILE' AS kind, fi.id, fi.name, CAST(NULL AS bigint) AS parent_id, fi.folder_id, COALESCE(fi.type, '') AS type, COALESCE(fi.extension, '') AS extension, fi.size, COALESCE(fi.modified, '') AS modified, fi.metadata, fi.version, fi.created_at, fi.updated_at, tree.path || '/' || fi.name AS path, "+
+			searchScore("fi", query, args)+" AS score FROM tree JOIN files fi ON fi.folder_id = tree.id WHERE fi.deleted_at IS NULL AND fi.user_id = @user "+
+			searchConditions("fi", query, args, true))
+	}
+
+	sql := "WITH RECURSIVE tree AS ( " +
+		"SELECT id, CAST(@path AS text) AS path FROM folders WHERE id = @folder AND user_id = @user AND deleted_at IS NULL " +
+		"UNION ALL SELECT f.id, tree.path || '/' || f.name FROM folders f JOIN tree ON f.parent_id = tree.id WHERE f.deleted_at IS NULL " +
+		"), hits AS ( " + strings.Join(parts, " UNION ALL ") + " ) " +
+		"SELECT * FROM hits ORDER BY " + order + ", lower(name), kind DESC, id LIMIT @limit OFFSET @offset "
+
+	var rows []searchRow
+	if err := conn(ctx, s.db).Raw(sql, args).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	hits := make([]*SearchHit, len(rows))
+	for i, row := range rows {
+		hits[i] = row.toHit(userId)
+	}
+	return hits, nil
+}
+
+// searchScore() ranks exact names over prefixes over substrings, each plus the trigram similarity
+func searchScore(alias string, query SearchQuery, args map[string]interface{}) string {
+	if query.Text == "" {
+		return "CAST(0 AS float8)"
+	}
+
+	args["text"] = query.Text
+	args["prefix"] = escapeLike(query.Text) + "%"
+	args["contains"] = "%" + escapeLike(query.Text) + "%"
+	name := alias + ".name"
+	return "(CASE WHEN lower(" + name + ") = lower(@text) THEN 3 WHEN " + name + " ILIKE @prefix THEN 2 WHEN " + name + " ILIKE @contains THEN 1 ELSE 0 END + CAST(word_similarity(@text, " + name + ") AS float8))"
+}
+
+// searchConditions() returns the filters of the query, each starting with AND
+func searchConditions(alias string, query SearchQuery, args map[string]interface{}, file bool) string {
+	var conditions strings.Builder
+	if query.Text != "" {
+		// <% is the fuzzy match of pg_trgm, served by the trigram index like ILIKE
+		conditions.WriteString(" AND (" + alias + ".name ILIKE @contains OR @text <% " + alias + ".name)")
+	}
+	if query.UpdatedAfter != nil {
+		args["updatedAfter"] = *query.UpdatedAfter
+		conditions.WriteString(" AND " + alias + ".updated_at >= @updatedAfter ")
+	}
+	if query.UpdatedBefore != nil {
+		args["updatedBefore"] = *query.UpdatedBefore
+		conditions.WriteString(" AND " + alias + ".updated_at < @updatedBefore ")
+	}
+	if !file {
+		return conditions.String()
+	}
+
+	if len(query.Extensions) > 0 {
+		args["extensions"] = query.Extensions
+		conditions.WriteString(" AND lower(" + alias + ".extension) IN @extensions ")
+	}
+	if len(query.Types) > 0 {
+		types := make([]string, len(query.Types))
+		for i, fileType := range query.Types {
+			name := fmt.Sprintf("type%d", i)
+			args[name] = escapeLike(fileType) + "%"
+			types[i] = alias + ".type ILIKE @" + name + " "
+		}
+		conditions.WriteString(" AND (" + strings.Join(types, "OR ") + ")")
+	}
+	if query.MinSize != nil {
+		args["minSize"] = *query.MinSize
+		conditions.WriteString(" AND " + alias + ".size >= @minSize ")
+	}
+	if query.MaxSize != nil {
+		args["maxSize"] = *query.MaxSize
+		conditions.WriteString(" AND " + alias + ".size <= @maxSize ")
+	}
+	return conditions.String()
+}
+
+// escapeLike() makes the wildcards of a LIKE pattern match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (r searchRow) toHit(userId uint) *SearchHit {
+	model := gorm.Model{ID: r.Id, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt}
+	hit := &SearchHit{Path: r.Path, Score: r.Score}
+
+	if r.Kind == "FOLDER" {
+		hit.Folder = &entity.Folder{Model: model, Name: r.Name, ParentId: r.ParentId, UserId: userId, Version: r.Version, Metadata: r.Metadata, Path: r.Path}
+		return hit
+	}
+
+	file := &entity.File{Model: model, Name: r.Name, FolderId: r.FolderId, Type: r.Type, Extension: r.Extension, Modified: r.Modified, UserId: userId, Version: r.Version, Metadata: r.Metadata, Path: r.Path}
+	if r.Size != nil {
+		file.Size = *r.Size
+	}
+	hit.File = file
+	return hit
+}
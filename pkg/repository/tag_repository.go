@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"context"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"gorm.io/gorm"
+)
+
+func NewTagRepository(db *gorm.DB, timeouts Timeouts) TagRepository {
+	return &tagRepository{db: db, timeouts: timeouts}
+}
+
+type TagRepository interface {
+	CreateTag(ctx context.Context, userId uint, name string, color string) (*entity.Tag, error)
+	UpdateTag(ctx context.Context, userId uint, tag *entity.Tag) error
+	DeleteTag(ctx context.Context, userId uint, id uint) (bool, error)
+	DeleteAllTags(ctx context.Context, userId uint) (int64, error)
+
+	GetTag(ctx context.Context, userId uint, id uint) (*entity.Tag, error)
+	GetTags(ctx context.Context, userId uint) ([]*entity.Tag, error)
+	GetExistingTagIds(ctx context.Context, userId uint, ids []uint) ([]uint, error)
+	GetExistingFileIds(ctx context.Context, userId uint, ids []uint) ([]uint, error)
+	GetExistingFolderIds(ctx context.Context, userId uint, ids []uint) ([]uint, error)
+
+	TagFiles(ctx context.Context, userId uint, tagIds []uint, fileIds []uint) error
+	TagFolders(ctx context.Context, userId uint, tagIds []uint, folderIds []uint) error
+	UntagFiles(ctx context.Context, userId uint, tagIds []uint, fileIds []uint) error
+	UntagFolders(ctx context.Context, userId uint, tagIds []uint, folderIds []uint) error
+
+	GetFileTags(ctx context.Context, userId uint, fileId uint) ([]*entity.Tag, error)
+	GetFolderTags(ctx context.Context, userId uint, folderId uint) ([]*entity.Tag, error)
+	GetTaggedFiles(ctx context.Context, userId uint, tagId uint) ([]*entity.File, error)
+	GetTaggedFolders(ctx context.Context, userId uint, tagId uint) ([]*entity.Folder, error)
+}
+
+type tagRepository struct {
+	db       *gorm.DB
+	timeouts Timeouts
+}
+
+func (t *tagRepository) CreateTag(ctx context.Context, userId uint, name string, color string) (*entity.Tag, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "CreateTag")
+	defer cancel()
+
+	tag := &entity.Tag{
+		Name:   name,
+		Color:  color,
+		UserId: userId,
+	}
+
+	if err := conn(ctx, t.db).Create(tag).Error; err != nil {
+		return nil, translateError(err)
+	}
+
+	return tag, nil
+}
+
+func (t *tagRepository) UpdateTag(ctx context.Context, userId uint, tag *entity.Tag) error {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "UpdateTag")
+	defer cancel()
+
+	result := conn(ctx, t.db).Model(&entity.Tag{}).
+		Where("user_id = ? AND id = ?", userId, tag.ID).
+		Updates(map[string]interface{}{
+			"name":  tag.Name,
+			"color": tag.Color,
+		})
+	if result.Error != nil {
+		return translateError(result.Error)
+	} else if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteTag removes the tag permanently, it is detached from every file and folder
+func (t *tagRepository) DeleteTag(ctx context.Context, userId uint, id uint) (bool, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "DeleteTag")
+	defer cancel()
+
+	result := conn(ctx, t.db).Where("user_id = ? AND id = ?", userId, id).Delete(&entity.Tag{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteAllTags removes the whole tag catalogue of the user
+func (t *tagRepository) DeleteAllTags(ctx context.Context, userId uint) (int64, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "DeleteAllTags")
+	defer cancel()
+
+	result := conn(ctx, t.db).Where("user_id = ?", userId).Delete(&entity.Tag{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+func (t *tagRepository) GetTag(ctx context.Context, userId uint, id uint) (*entity.Tag, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "GetTag")
+	defer cancel()
+
+	var tag entity.Tag
+	if err := conn(ctx, t.db).Where("user_id = ? AND id = ?", userId, id).First(&tag).Error; err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+func (t *tagRepository) GetTags(ctx context.Context, userId uint) ([]*entity.Tag, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "GetTags")
+	defer cancel()
+
+	var tags []*entity.Tag
+	if err := conn(ctx, t.db).Where("user_id = ?", userId).Order("lower(name)").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetExistingTagIds returns those of ids that are tags of the user
+func (t *tagRepository) GetExistingTagIds(ctx context.Context, userId uint, ids []uint) ([]uint, error) {
+	return t.existingIds(ctx, "GetExistingTagIds", &entity.Tag{}, userId, ids)
+}
+
+// GetExistingFileIds returns those of ids that are files of the user
+func (t *tagRepository) GetExistingFileIds(ctx context.Context, userId uint, ids []uint) ([]uint, error) {
+	return t.existingIds(ctx, "GetExistingFileIds", &entity.File{}, userId, ids)
+}
+
+// GetExistingFolderIds returns those of ids that are folders of the user
+func (t *tagRepository) GetExistingFolderIds(ctx context.Context, userId uint, ids []uint) ([]uint, error) {
+	return t.existingIds(ctx, "GetExistingFolderIds", &entity.Folder{}, userId, ids)
+}
+
+func (t *tagRepository) existingIds(ctx context.Context, operation string, model interface{}, userId uint, ids []uint) ([]uint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := t.timeouts.withTimeout(ctx, operation)
+	defer cancel()
+
+	var existing []uint
+	if err := conn(ctx, t.db).Model(model).Where("user_id = ? AND id IN ?", userId, ids).Pluck("id", &existing).Error; err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+// TagFiles attaches every tag to every file, links that already exist are kept.
+// Only tags and files of the user are linked.
+func (t *tagRepository) TagFiles(ctx context.Context, userId uint, tagIds []uint, fileIds []uint) error {
+	if len(tagIds) == 0 || len(fileIds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := t.timeouts.withTimeout(ctx, "TagFiles")
+	defer cancel()
+
+	return conn(ctx, t.db).Exec("INSERT INTO file_tags (tag_id, file_id) SELECT t.id, f.id FROM tags t CROSS JOIN files f WHERE t.user_id = ? AND t.id IN ? AND f.user_id = ? AND f.id IN ? AND f.deleted_at IS NULL ON CONFLICT DO NOTHING", userId, tagIds, userId, fileIds).Error
+}
+
+// TagFolders attaches every tag to every folder, links that already exist are kept.
+// Only tags and folders of the user are linked.
+func (t *tagRepository) TagFolders(ctx context.Context, userId uint, tagIds []uint, folderIds []uint) error {
+	if len(tagIds) == 0 || len(folderIds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := t.timeouts.withTimeout(ctx, "TagFolders")
+	defer cancel()
+
+	return conn(ctx, t.db).Exec("INSERT INTO folder_tags (tag_id, folder_id) SELECT t.id, f.id FROM tags t CROSS JOIN folders f WHERE t.user_id = ? AND t.id IN ? AND f.user_id = ? AND f.id IN ? AND f.deleted_at IS NULL ON CONFLICT DO NOTHING", userId, tagIds, userId, folderIds).Error
+}
+
+func (t *tagRepository) UntagFiles(ctx context.Context, userId uint, tagIds []uint, fileIds []uint) error {
+	if len(tagIds) == 0 || len(fileIds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := t.timeouts.withTimeout(ctx, "UntagFiles")
+	defer cancel()
+
+	return conn(ctx, t.db).Exec("DELETE FROM file_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ? AND id IN ?) AND file_id IN ?", userId, tagIds, fileIds).Error
+}
+
+func (t *tagRepository) UntagFolders(ctx context.Context, userId uint, tagIds []uint, folderIds []uint) error {
+	if len(tagIds) == 0 || len(folderIds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := t.timeouts.withTimeout(ctx, "UntagFolders")
+	defer cancel()
+
+	return conn(ctx, t.db).Exec("DELETE FROM folder_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ? AND id IN ?) AND folder_id IN ?", userId, tagIds, folderIds).Error
+}
+
+func (t *tagRepository) GetFileTags(ctx context.Context, userId uint, fileId uint) ([]*entity.Tag, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "GetFileTags")
+	defer cancel()
+
+	var tags []*entity.Tag
+	err := conn(ctx, t.db).Joins("JOIN file_tags ft ON ft.tag_id = tags.id").
+		Where("tags.user_id = ? AND ft.file_id = ?", userId, fileId).
+		Order("lower(tags.name)").Find(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (t *tagRepository) GetFolderTags(ctx context.Context, userId uint, folderId uint) ([]*entity.Tag, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "GetFolderTags")
+	defer cancel()
+
+	var tags []*entity.Tag
+	err := conn(ctx, t.db).Joins("JOIN folder_tags ft ON ft.tag_id = tags.id").
+		Where("tags.user_id = ? AND ft.folder_id = ?", userId, folderId).
+		Order("lower(tags.name)").Find(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetTaggedFiles returns the files carrying the tag, wherever they are in the tree
+func (t *tagRepository) GetTaggedFiles(ctx context.Context, userId uint, tagId uint) ([]*entity.File, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "GetTaggedFiles")
+	defer cancel()
+
+	var files []*entity.File
+	err := conn(ctx, t.db).Joins("JOIN file_tags ft ON ft.file_id = files.id").
+		Where("files.user_id = ? AND ft.tag_id = ?", userId, tagId).
+		Order("files.name, files.id").Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// GetTaggedFolders returns the folders carrying the tag, wherever they are in the tree
+func (t *tagRepository) GetTaggedFolders(ctx context.Context, userId uint, tagId uint) ([]*entity.Folder, error) {
+	ctx, cancel := t.timeouts.withTimeout(ctx, "GetTaggedFolders")
+	defer cancel()
+
+	var folders []*entity.Folder
+	err := conn(ctx, t.db).Joins("JOIN folder_tags ft ON ft.folder_id = folders.id").
+		Where("folders.user_id = ? AND ft.tag_id = ?", userId, tagId).
+		Order("folders.name, folders.id").Find(&folders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Timeouts limits how long a single repository operation may run.
+// Operations are matched case-insensitively by method name, anything else uses Default.
+type Timeouts struct {
+	Default    time.Duration
+	Operations map[string]time.Duration
+}
+
+// NewTimeouts() builds Timeouts from milliseconds as found in the configuration
+func NewTimeouts(defaultMillis int, operationMillis map[string]int) Timeouts {
+	timeouts := Timeouts{
+		Default:    time.Duration(defaultMillis) * time.Millisecond,
+		Operations: make(map[string]time.Duration, len(operationMillis)),
+	}
+	for operation, millis := range operationMillis {
+		timeouts.Operations[strings.ToLower(operation)] = time.Duration(millis) * time.Millisecond
+	}
+	return timeouts
+}
+
+// withTimeout() derives the context an operation runs with, cancelling the caller also cancels the query
+func (t Timeouts) withTimeout(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	timeout, ok := t.Operations[strings.ToLower(operation)]
+	if !ok {
+		timeout = t.Default
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
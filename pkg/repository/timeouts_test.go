@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	timeouts := NewTimeouts(100, map[string]int{"GetFile": 5000, "PurgeFiles": 0})
+	tests := []struct {
+		operation string
+		expected  time.Duration
+	}{
+		// operations are matched ignoring case
+		{operation: "GetFile", expected: 5 * time.Second},
+		{operation: "GETFILE", expected: 5 * time.Second},
+		{operation: "CreateFile", expected: 100 * time.Millisecond},
+		// an operation of 0 runs without a timeout, whatever the default
+		{operation: "PurgeFiles"},
+	}
+	for _, test := range tests {
+		t.Run(test.operation, func(t *testing.T) {
+			started := time.Now()
+			ctx, cancel := timeouts.withTimeout(context.Background(), test.operation)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if test.expected == 0 {
+				if ok {
+					t.Fatalf("expected no deadline, got one in %v", time.Until(deadline))
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected a deadline")
+			}
+			if timeout := deadline.Sub(started); timeout < test.expected || timeout > test.expected+time.Second {
+				t.Fatalf("expected a timeout of %v, got %v", test.expected, timeout)
+			}
+		})
+	}
+}
+
+func TestWithTimeoutWithoutDefault(t *testing.T) {
+	ctx, cancel := NewTimeouts(0, nil).withTimeout(context.Background(), "GetFile")
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("expected no deadline without a default")
+	}
+}
+
+func TestWithTimeoutFollowsTheCaller(t *testing.T) {
+	caller, cancelCaller := context.WithCancel(context.Background())
+	ctx, cancel := NewTimeouts(60000, nil).withTimeout(caller, "GetFile")
+	defer cancel()
+
+	cancelCaller()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the operation was not cancelled with its caller")
+	}
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// Transactor runs several repository operations in one database transaction
+type Transactor interface {
+	// Transaction() commits when fn returns nil and rolls back otherwise.
+	// Repositories called with the context passed to fn take part in the transaction,
+	// a nested call runs in a savepoint of the outer transaction.
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+func NewTransactor(db *gorm.DB) Transactor {
+	return &transactor{db: db}
+}
+
+type transactor struct {
+	db *gorm.DB
+}
+
+type txKey struct{}
+
+// afterCommit collects the functions to run once the outermost transaction committed
+type afterCommit struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+func (a *afterCommit) add(fns ...func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fns = append(a.fns, fns...)
+}
+
+func (t *transactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	parent, _ := ctx.Value(afterCommitKey{}).(*afterCommit)
+	hooks := &afterCommit{}
+
+	err := conn(ctx, t.db).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(context.WithValue(ctx, txKey{}, tx), afterCommitKey{}, hooks))
+	})
+	if err != nil {
+		return err
+	}
+
+	// a savepoint hands its functions to the enclosing transaction, which may still roll back
+	if parent != nil {
+		parent.add(hooks.fns...)
+		return nil
+	}
+	for _, fn := range hooks.fns {
+		fn()
+	}
+	return nil
+}
+
+type afterCommitKey struct{}
+
+// AfterCommit() runs fn once the transaction of ctx committed, or right away when ctx carries none.
+// fn is dropped when the transaction rolls back.
+func AfterCommit(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(afterCommitKey{}).(*afterCommit); ok {
+		hooks.add(fn)
+		return
+	}
+	fn()
+}
+
+// conn() returns the transaction of ctx, or db when ctx carries none
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
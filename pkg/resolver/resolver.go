@@ -1,12 +1,19 @@
 package resolver
 
-import "github.com/potatowhite/books/file-service/pkg/service"
+import (
+	"github.com/potatowhite/books/file-service/pkg/importer"
+	"github.com/potatowhite/books/file-service/pkg/service"
+)
 
 type Resolver struct {
 	FolderSvc service.FolderService
 	FileSvc   service.FileService
+	BulkSvc   service.BulkService
+	ImportSvc importer.Service
+	TagSvc    service.TagService
+	SearchSvc service.SearchService
 }
 
-func NewResolver(folderSvc service.FolderService, fileSvc service.FileService) *Resolver {
-	return &Resolver{FolderSvc: folderSvc, FileSvc: fileSvc}
+func NewResolver(folderSvc service.FolderService, fileSvc service.FileService, bulkSvc service.BulkService, importSvc importer.Service, tagSvc service.TagService, searchSvc service.SearchService) *Resolver {
+	return &Resolver{FolderSvc: folderSvc, FileSvc: fileSvc, BulkSvc: bulkSvc, ImportSvc: importSvc, TagSvc: tagSvc, SearchSvc: searchSvc}
 }
@@ -6,15 +6,15 @@ package resolver
 
 import (
 	"context"
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/potatowhite/books/file-service/graph"
 	"github.com/potatowhite/books/file-service/graph/model"
+	"github.com/potatowhite/books/file-service/logging"
 	"github.com/potatowhite/books/file-service/pkg/util"
-	"log"
-	"os"
 )
 
 var (
-	logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
+	logger = logging.For("resolver")
 )
 
 // Mutation returns MutationResolver implementation.
@@ -30,7 +30,7 @@ type queryResolver struct{ *Resolver }
 // CreateRootFolder is the resolver for the createRootFolder field.
 func (r *mutationResolver) CreateRootFolder(ctx context.Context, userID string) (*model.Folder, error) {
 	userIDInt := *util.AtoUIOrNil(&userID)
-	rootFolder, err := r.FolderSvc.CreateRootFolder(userIDInt)
+	rootFolder, err := r.FolderSvc.CreateRootFolder(ctx, userIDInt)
 	if err != nil {
 		return nil, err
 	}
@@ -39,11 +39,11 @@ func (r *mutationResolver) CreateRootFolder(ctx context.Context, userID string)
 }
 
 // CreateFolder is the resolver for the createFolder field.
-func (r *mutationResolver) CreateFolder(ctx context.Context, userID string, name string, parentID string) (*model.Folder, error) {
+func (r *mutationResolver) CreateFolder(ctx context.Context, userID string, name string, parentID string, conflictStrategy *model.ConflictStrategy) (*model.Folder, error) {
 	userIDInt := *util.AtoUIOrNil(&userID)
 	parentIDInt := *util.AtoUIOrNil(&parentID)
 
-	subFolder, err := r.FolderSvc.CreateFolder(userIDInt, name, parentIDInt)
+	subFolder, err := r.FolderSvc.CreateFolder(ctx, userIDInt, name, parentIDInt, util.ToConflictStrategy(conflictStrategy))
 	if err != nil {
 		return nil, err
 	}
@@ -52,11 +52,15 @@ func (r *mutationResolver) CreateFolder(ctx context.Context, userID string, name
 }
 
 // RenameFolder is the resolver for the renameFolder field.
-func (r *mutationResolver) RenameFolder(ctx context.Context, userID string, id string, name string) (*model.Folder, error) {
+func (r *mutationResolver) RenameFolder(ctx context.Context, userID string, id string, name string, expectedVersion *int) (*model.Folder, error) {
 	userIDInt := *util.AtoUIOrNil(&userID)
 	idInt := *util.AtoUIOrNil(&id)
 
-	folder, err := r.FolderSvc.RenameFolder(userIDInt, idInt, name)
+	version, err := util.ToExpectedVersion(expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := r.FolderSvc.RenameFolder(ctx, userIDInt, idInt, name, version)
 	if err != nil {
 		return nil, err
 	}
@@ -69,7 +73,7 @@ func (r *mutationResolver) DeleteFolder(ctx context.Context, userID string, id s
 	userIDInt := *util.AtoUIOrNil(&userID)
 	idInt := *util.AtoUIOrNil(&id)
 
-	_, err := r.FolderSvc.DeleteFolder(userIDInt, idInt)
+	_, err := r.FolderSvc.DeleteFolder(ctx, userIDInt, idInt)
 	if err != nil {
 		return false, err
 	}
@@ -78,11 +82,11 @@ func (r *mutationResolver) DeleteFolder(ctx context.Context, userID string, id s
 }
 
 // CreateFile is the resolver for the createFile field.
-func (r *mutationResolver) CreateFile(ctx context.Context, userID string, name string, folderID string) (*model.File, error) {
+func (r *mutationResolver) CreateFile(ctx context.Context, userID string, name string, folderID string, conflictStrategy *model.ConflictStrategy) (*model.File, error) {
 	userIDInt := *util.AtoUIOrNil(&userID)
 	folderIDInt := *util.AtoUIOrNil(&folderID)
 
-	file, err := r.FileSvc.CreateFile(userIDInt, name, folderIDInt)
+	file, err := r.FileSvc.CreateFile(ctx, userIDInt, name, folderIDInt, util.ToConflictStrategy(conflictStrategy))
 	if err != nil {
 		return nil, err
 	}
@@ -91,12 +95,20 @@ func (r *mutationResolver) CreateFile(ctx context.Context, userID string, name s
 }
 
 // UpdateFile is the resolver for the updateFile field.
-func (r *mutationResolver) UpdateFile(ctx context.Context, userID string, id string, name *string, typeArg *string, extension *string, size *int) (*model.File, error) {
+func (r *mutationResolver) UpdateFile(ctx context.Context, userID string, id string, name *string, typeArg *string, extension *string, size *int, expectedVersion *int) (*model.File, error) {
 	userIDInt := *util.AtoUIOrNil(&userID)
 	idInt := *util.AtoUIOrNil(&id)
 
-	sizeUInt := uint64(*size)
-	file, err := r.FileSvc.PatchFile(userIDInt, idInt, name, typeArg, extension, &sizeUInt)
+	var sizeUInt *uint64
+	if size != nil {
+		value := uint64(*size)
+		sizeUInt = &value
+	}
+	version, err := util.ToExpectedVersion(expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.FileSvc.PatchFile(ctx, userIDInt, idInt, name, typeArg, extension, sizeUInt, version)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +121,7 @@ func (r *mutationResolver) DeleteFile(ctx context.Context, userID string, id str
 	userIDInt := *util.AtoUIOrNil(&userID)
 	idInt := *util.AtoUIOrNil(&id)
 
-	_, err := r.FileSvc.DeleteFile(userIDInt, idInt)
+	_, err := r.FileSvc.DeleteFile(ctx, userIDInt, idInt)
 	if err != nil {
 		return false, err
 	}
@@ -117,9 +129,204 @@ func (r *mutationResolver) DeleteFile(ctx context.Context, userID string, id str
 	return true, nil
 }
 
+// RestoreFileVersion is the resolver for the restoreFileVersion field.
+func (r *mutationResolver) RestoreFileVersion(ctx context.Context, userID string, id string, versionID string, expectedVersion *int) (*model.File, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	idInt := *util.AtoUIOrNil(&id)
+	versionIDInt := *util.AtoUIOrNil(&versionID)
+
+	version, err := util.ToExpectedVersion(expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.FileSvc.RestoreVersion(ctx, userIDInt, idInt, versionIDInt, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToFileDto(file), nil
+}
+
+// BulkDelete is the resolver for the bulkDelete field.
+func (r *mutationResolver) BulkDelete(ctx context.Context, userID string, items model.BulkItems, mode *model.BulkMode) (*model.BulkResult, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	bulkItems, err := util.ToBulkItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.BulkSvc.Delete(ctx, userIDInt, bulkItems, util.ToBulkMode(mode))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToBulkResultDto(result), nil
+}
+
+// BulkMove is the resolver for the bulkMove field.
+func (r *mutationResolver) BulkMove(ctx context.Context, userID string, items model.BulkItems, targetFolderID string, conflictStrategy *model.ConflictStrategy, mode *model.BulkMode, expectedVersions []*model.ExpectedVersion) (*model.BulkResult, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	targetFolderIDInt := *util.AtoUIOrNil(&targetFolderID)
+	bulkItems, err := util.ToBulkItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := util.AddExpectedVersions(&bulkItems, expectedVersions); err != nil {
+		return nil, err
+	}
+
+	result, err := r.BulkSvc.Move(ctx, userIDInt, bulkItems, targetFolderIDInt, util.ToConflictStrategy(conflictStrategy), util.ToBulkMode(mode))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToBulkResultDto(result), nil
+}
+
+// BulkCopy is the resolver for the bulkCopy field.
+func (r *mutationResolver) BulkCopy(ctx context.Context, userID string, items model.BulkItems, targetFolderID string, conflictStrategy *model.ConflictStrategy, mode *model.BulkMode) (*model.BulkResult, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	targetFolderIDInt := *util.AtoUIOrNil(&targetFolderID)
+	bulkItems, err := util.ToBulkItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.BulkSvc.Copy(ctx, userIDInt, bulkItems, targetFolderIDInt, util.ToConflictStrategy(conflictStrategy), util.ToBulkMode(mode))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToBulkResultDto(result), nil
+}
+
+// ImportArchive is the resolver for the importArchive field.
+func (r *mutationResolver) ImportArchive(ctx context.Context, userID string, folderID string, upload graphql.Upload, conflictStrategy *model.ConflictStrategy) (*model.ImportJob, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	folderIDInt := *util.AtoUIOrNil(&folderID)
+
+	job, err := r.ImportSvc.Start(ctx, userIDInt, folderIDInt, upload.File, util.ToConflictStrategy(conflictStrategy))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToImportJobDto(job), nil
+}
+
+// CreateTag is the resolver for the createTag field.
+func (r *mutationResolver) CreateTag(ctx context.Context, userID string, name string, color *string) (*model.Tag, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+
+	var colorStr string
+	if color != nil {
+		colorStr = *color
+	}
+
+	tag, err := r.TagSvc.CreateTag(ctx, userIDInt, name, colorStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToTagDto(tag), nil
+}
+
+// UpdateTag is the resolver for the updateTag field.
+func (r *mutationResolver) UpdateTag(ctx context.Context, userID string, id string, name *string, color *string) (*model.Tag, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	idInt := *util.AtoUIOrNil(&id)
+
+	tag, err := r.TagSvc.UpdateTag(ctx, userIDInt, idInt, name, color)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToTagDto(tag), nil
+}
+
+// DeleteTag is the resolver for the deleteTag field.
+func (r *mutationResolver) DeleteTag(ctx context.Context, userID string, id string) (bool, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	idInt := *util.AtoUIOrNil(&id)
+
+	return r.TagSvc.DeleteTag(ctx, userIDInt, idInt)
+}
+
+// AddTags is the resolver for the addTags field.
+func (r *mutationResolver) AddTags(ctx context.Context, userID string, tagIds []string, items model.BulkItems) (bool, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	tagIDInts, err := util.AtoUIs(tagIds)
+	if err != nil {
+		return false, err
+	}
+	bulkItems, err := util.ToBulkItems(items)
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.TagSvc.AddTags(ctx, userIDInt, tagIDInts, bulkItems); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RemoveTags is the resolver for the removeTags field.
+func (r *mutationResolver) RemoveTags(ctx context.Context, userID string, tagIds []string, items model.BulkItems) (bool, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	tagIDInts, err := util.AtoUIs(tagIds)
+	if err != nil {
+		return false, err
+	}
+	bulkItems, err := util.ToBulkItems(items)
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.TagSvc.RemoveTags(ctx, userIDInt, tagIDInts, bulkItems); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetFileMetadata is the resolver for the setFileMetadata field.
+func (r *mutationResolver) SetFileMetadata(ctx context.Context, userID string, id string, metadata map[string]interface{}, merge *bool, expectedVersion *int) (*model.File, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	idInt := *util.AtoUIOrNil(&id)
+
+	version, err := util.ToExpectedVersion(expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.FileSvc.SetMetadata(ctx, userIDInt, idInt, metadata, merge != nil && *merge, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToFileDto(file), nil
+}
+
+// SetFolderMetadata is the resolver for the setFolderMetadata field.
+func (r *mutationResolver) SetFolderMetadata(ctx context.Context, userID string, id string, metadata map[string]interface{}, merge *bool, expectedVersion *int) (*model.Folder, error) {
+	userIDInt := *util.AtoUIOrNil(&userID)
+	idInt := *util.AtoUIOrNil(&id)
+
+	version, err := util.ToExpectedVersion(expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := r.FolderSvc.SetMetadata(ctx, userIDInt, idInt, metadata, merge != nil && *merge, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToFolderDto(folder), nil
+}
+
 // RootFolder is the resolver for the rootFolder field.
 func (r *queryResolver) RootFolder(ctx context.Context, userID string) (*model.Folder, error) {
-	rootFolder, err := r.FolderSvc.GetRootFolder(*util.AtoUIOrNil(&userID))
+	rootFolder, err := r.FolderSvc.GetRootFolder(ctx, *util.AtoUIOrNil(&userID))
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +339,7 @@ func (r *queryResolver) ChildrenFolders(ctx context.Context, userID string, id s
 	userIDInt := *util.AtoUIOrNil(&userID)
 	folderIDInt := *util.AtoUIOrNil(&id)
 
-	folders, err := r.FolderSvc.GetChildren(userIDInt, folderIDInt)
+	folders, err := r.FolderSvc.GetChildren(ctx, userIDInt, folderIDInt)
 	if err != nil {
 		return nil, err
 	}
@@ -151,7 +358,7 @@ func (r *queryResolver) ChildrenFiles(ctx context.Context, userID string, id str
 	userIDInt := *util.AtoUIOrNil(&userID)
 	folderIDInt := *util.AtoUIOrNil(&id)
 
-	files, err := r.FileSvc.GetChildren(userIDInt, folderIDInt)
+	files, err := r.FileSvc.GetChildren(ctx, userIDInt, folderIDInt)
 	if err != nil {
 		return nil, err
 	}
@@ -165,9 +372,19 @@ func (r *queryResolver) ChildrenFiles(ctx context.Context, userID string, id str
 	return filesDto, nil
 }
 
+// ImportJob is the resolver for the importJob field.
+func (r *queryResolver) ImportJob(ctx context.Context, userID string, id string) (*model.ImportJob, error) {
+	job, ok := r.ImportSvc.Job(*util.AtoUIOrNil(&userID), id)
+	if !ok {
+		return nil, nil
+	}
+
+	return util.ToImportJobDto(job), nil
+}
+
 // Folder is the resolver for the folder field.
 func (r *queryResolver) Folder(ctx context.Context, userID string, id string) (*model.Folder, error) {
-	folder, err := r.FolderSvc.GetFolder(*util.AtoUIOrNil(&userID), *util.AtoUIOrNil(&id))
+	folder, err := r.FolderSvc.GetFolder(ctx, *util.AtoUIOrNil(&userID), *util.AtoUIOrNil(&id))
 	if err != nil {
 		return nil, err
 	}
@@ -177,7 +394,7 @@ func (r *queryResolver) Folder(ctx context.Context, userID string, id string) (*
 
 // File is the resolver for the file field.
 func (r *queryResolver) File(ctx context.Context, userID string, id string) (*model.File, error) {
-	file, err := r.FileSvc.GetFile(*util.AtoUIOrNil(&userID), *util.AtoUIOrNil(&id))
+	file, err := r.FileSvc.GetFile(ctx, *util.AtoUIOrNil(&userID), *util.AtoUIOrNil(&id))
 	if err != nil {
 		return nil, err
 	}
@@ -185,6 +402,109 @@ func (r *queryResolver) File(ctx context.Context, userID string, id string) (*mo
 	return util.ToFileDto(file), nil
 }
 
+// Tags is the resolver for the tags field.
+func (r *queryResolver) Tags(ctx context.Context, userID string) ([]*model.Tag, error) {
+	tags, err := r.TagSvc.GetTags(ctx, *util.AtoUIOrNil(&userID))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToTagDtos(tags), nil
+}
+
+// Tagged is the resolver for the tagged field.
+func (r *queryResolver) Tagged(ctx context.Context, userID string, tagID string) (*model.TaggedItems, error) {
+	folders, files, err := r.TagSvc.GetTagged(ctx, *util.AtoUIOrNil(&userID), *util.AtoUIOrNil(&tagID))
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := &model.TaggedItems{
+		Folders: make([]*model.Folder, len(folders)),
+		Files:   make([]*model.File, len(files)),
+	}
+	for i, folder := range folders {
+		tagged.Folders[i] = util.ToFolderDto(folder)
+	}
+	for i, file := range files {
+		tagged.Files[i] = util.ToFileDto(file)
+	}
+
+	return tagged, nil
+}
+
+// FilesByMetadata is the resolver for the filesByMetadata field.
+func (r *queryResolver) FilesByMetadata(ctx context.Context, userID string, filters []*model.MetadataFilter, limit *int) ([]*model.File, error) {
+	// a null limit returns as many items as allowed
+	var limitInt int
+	if limit != nil {
+		limitInt = *limit
+	}
+
+	files, err := r.FileSvc.FindByMetadata(ctx, *util.AtoUIOrNil(&userID), util.ToMetadataFilters(filters), limitInt)
+	if err != nil {
+		return nil, err
+	}
+
+	filesDto := make([]*model.File, len(files))
+	for i, file := range files {
+		filesDto[i] = util.ToFileDto(file)
+	}
+
+	return filesDto, nil
+}
+
+// FoldersByMetadata is the resolver for the foldersByMetadata field.
+func (r *queryResolver) FoldersByMetadata(ctx context.Context, userID string, filters []*model.MetadataFilter, limit *int) ([]*model.Folder, error) {
+	// a null limit returns as many items as allowed
+	var limitInt int
+	if limit != nil {
+		limitInt = *limit
+	}
+
+	folders, err := r.FolderSvc.FindByMetadata(ctx, *util.AtoUIOrNil(&userID), util.ToMetadataFilters(filters), limitInt)
+	if err != nil {
+		return nil, err
+	}
+
+	foldersDto := make([]*model.Folder, len(folders))
+	for i, folder := range folders {
+		foldersDto[i] = util.ToFolderDto(folder)
+	}
+
+	return foldersDto, nil
+}
+
+type fileResolver struct{ *Resolver }
+
+// File returns FileResolver implementation.
+func (r *Resolver) File() graph.FileResolver { return &fileResolver{r} }
+
+// Tags is the resolver for the tags field.
+func (r *fileResolver) Tags(ctx context.Context, obj *model.File) ([]*model.Tag, error) {
+	tags, err := r.TagSvc.GetFileTags(ctx, *util.AtoUIOrNil(&obj.UserID), *util.AtoUIOrNil(&obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToTagDtos(tags), nil
+}
+
+// Versions is the resolver for the versions field.
+func (r *fileResolver) Versions(ctx context.Context, obj *model.File) ([]*model.File, error) {
+	versions, err := r.FileSvc.GetVersions(ctx, *util.AtoUIOrNil(&obj.UserID), *util.AtoUIOrNil(&obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	versionsDto := make([]*model.File, len(versions))
+	for i, version := range versions {
+		versionsDto[i] = util.ToFileDto(version)
+	}
+
+	return versionsDto, nil
+}
+
 type folderResolver struct{ *Resolver }
 
 // Folder returns FolderResolver implementation.
@@ -195,5 +515,30 @@ func (r *folderResolver) Path(ctx context.Context, obj *model.Folder) (*string,
 	userIdInt := *util.AtoUIOrNil(&obj.UserID)
 	folderId := *util.AtoUIOrNil(&obj.ID)
 
-	return r.FolderSvc.GetPathOrNil(userIdInt, folderId)
+	return r.FolderSvc.GetPathOrNil(ctx, userIdInt, folderId)
+}
+
+// Tags is the resolver for the tags field.
+func (r *folderResolver) Tags(ctx context.Context, obj *model.Folder) ([]*model.Tag, error) {
+	tags, err := r.TagSvc.GetFolderTags(ctx, *util.AtoUIOrNil(&obj.UserID), *util.AtoUIOrNil(&obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToTagDtos(tags), nil
+}
+
+// Search is the resolver for the search field.
+func (r *queryResolver) Search(ctx context.Context, userID string, query *string, filters *model.SearchFilters, scope *string, order *model.SearchOrder, limit *int, offset *int) (*model.SearchResult, error) {
+	searchQuery, err := util.ToSearchQuery(query, filters, scope, order, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.SearchSvc.Search(ctx, *util.AtoUIOrNil(&userID), searchQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.ToSearchResultDto(result), nil
 }
@@ -0,0 +1,314 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/graph/model"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	"net/http"
+)
+
+var (
+	logger = logging.For("rest")
+
+	// errBadRequest is wrapped by the errors of requests the API cannot read
+	errBadRequest = errors.New("bad request")
+)
+
+// Handler serves the REST API below /api/v1 and its OpenAPI document at /api/v1/openapi.yaml.
+// It fails when the routes and the document disagree, so the document cannot drift from the API.
+func Handler(folderSvc service.FolderService, fileSvc service.FileService) (http.Handler, error) {
+	a := &api{folderSvc: folderSvc, fileSvc: fileSvc}
+	routes := a.routes()
+	if err := checkSpec(routes); err != nil {
+		return nil, err
+	}
+	return &router{routes: routes}, nil
+}
+
+type api struct {
+	folderSvc service.FolderService
+	fileSvc   service.FileService
+}
+
+func (a *api) routes() []route {
+	// literal segments come before parameters, /folders/root is not the folder with id "root"
+	return []route{
+		{http.MethodGet, "/api/v1/openapi.yaml", serveSpec},
+		{http.MethodGet, "/api/v1/folders/root", a.getRootFolder},
+		{http.MethodPost, "/api/v1/folders/root", a.createRootFolder},
+		{http.MethodPost, "/api/v1/folders", a.createFolder},
+		{http.MethodGet, "/api/v1/folders/{id}", a.getFolder},
+		{http.MethodPatch, "/api/v1/folders/{id}", a.renameFolder},
+		{http.MethodDelete, "/api/v1/folders/{id}", a.deleteFolder},
+		{http.MethodGet, "/api/v1/folders/{id}/children", a.getChildren},
+		{http.MethodPost, "/api/v1/files", a.createFile},
+		{http.MethodGet, "/api/v1/files/{id}", a.getFile},
+		{http.MethodPatch, "/api/v1/files/{id}", a.updateFile},
+		{http.MethodDelete, "/api/v1/files/{id}", a.deleteFile},
+	}
+}
+
+func (a *api) getRootFolder(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, err := userID(r)
+	if err != nil {
+		return err
+	}
+
+	folder, err := a.folderSvc.GetRootFolder(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+	return a.writeFolder(w, r, http.StatusOK, userId, util.ToFolderDto(folder))
+}
+
+func (a *api) createRootFolder(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, err := userID(r)
+	if err != nil {
+		return err
+	}
+
+	folder, err := a.folderSvc.CreateRootFolder(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+	return a.writeFolder(w, r, http.StatusCreated, userId, util.ToFolderDto(folder))
+}
+
+type createFolderRequest struct {
+	Name             string                  `json:"name"`
+	ParentId         string                  `json:"parentId"`
+	ConflictStrategy *model.ConflictStrategy `json:"conflictStrategy"`
+}
+
+func (a *api) createFolder(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, err := userID(r)
+	if err != nil {
+		return err
+	}
+	var body createFolderRequest
+	if err := readBody(r, &body); err != nil {
+		return err
+	}
+	parentId, err := parseID("parentId", body.ParentId)
+	if err != nil {
+		return err
+	}
+	strategy, err := conflictStrategy(body.ConflictStrategy)
+	if err != nil {
+		return err
+	}
+
+	folder, err := a.folderSvc.CreateFolder(r.Context(), userId, body.Name, parentId, strategy)
+	if err != nil {
+		return err
+	}
+	return a.writeFolder(w, r, http.StatusCreated, userId, util.ToFolderDto(folder))
+}
+
+func (a *api) getFolder(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+
+	folder, err := a.folderSvc.GetFolder(r.Context(), userId, id)
+	if err != nil {
+		return err
+	}
+	return a.writeFolder(w, r, http.StatusOK, userId, util.ToFolderDto(folder))
+}
+
+type renameFolderRequest struct {
+	Name            string `json:"name"`
+	ExpectedVersion *int   `json:"expectedVersion"`
+}
+
+func (a *api) renameFolder(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+	var body renameFolderRequest
+	if err := readBody(r, &body); err != nil {
+		return err
+	}
+
+	version, err := util.ToExpectedVersion(body.ExpectedVersion)
+	if err != nil {
+		return err
+	}
+	folder, err := a.folderSvc.RenameFolder(r.Context(), userId, id, body.Name, version)
+	if err != nil {
+		return err
+	}
+	return a.writeFolder(w, r, http.StatusOK, userId, util.ToFolderDto(folder))
+}
+
+func (a *api) deleteFolder(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := a.folderSvc.DeleteFolder(r.Context(), userId, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("%w: folder %d", service.ErrNotFound, id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type childrenResponse struct {
+	Folders []*model.Folder `json:"folders"`
+	Files   []*model.File   `json:"files"`
+}
+
+func (a *api) getChildren(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+
+	// the folder itself is looked up so a missing folder is not mistaken for an empty one
+	if _, err := a.folderSvc.GetFolder(r.Context(), userId, id); err != nil {
+		return err
+	}
+	folders, err := a.folderSvc.GetChildren(r.Context(), userId, id)
+	if err != nil {
+		return err
+	}
+	files, err := a.fileSvc.GetChildren(r.Context(), userId, id)
+	if err != nil {
+		return err
+	}
+
+	response := childrenResponse{Folders: make([]*model.Folder, len(folders)), Files: make([]*model.File, len(files))}
+	for i, folder := range folders {
+		if response.Folders[i], err = a.withPath(r, userId, util.ToFolderDto(folder)); err != nil {
+			return err
+		}
+	}
+	for i, file := range files {
+		response.Files[i] = util.ToFileDto(file)
+	}
+	writeJSON(w, http.StatusOK, response)
+	return nil
+}
+
+type createFileRequest struct {
+	Name             string                  `json:"name"`
+	FolderId         string                  `json:"folderId"`
+	ConflictStrategy *model.ConflictStrategy `json:"conflictStrategy"`
+}
+
+func (a *api) createFile(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, err := userID(r)
+	if err != nil {
+		return err
+	}
+	var body createFileRequest
+	if err := readBody(r, &body); err != nil {
+		return err
+	}
+	folderId, err := parseID("folderId", body.FolderId)
+	if err != nil {
+		return err
+	}
+	strategy, err := conflictStrategy(body.ConflictStrategy)
+	if err != nil {
+		return err
+	}
+
+	file, err := a.fileSvc.CreateFile(r.Context(), userId, body.Name, folderId, strategy)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, util.ToFileDto(file))
+	return nil
+}
+
+func (a *api) getFile(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+
+	file, err := a.fileSvc.GetFile(r.Context(), userId, id)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, util.ToFileDto(file))
+	return nil
+}
+
+type updateFileRequest struct {
+	Name            *string `json:"name"`
+	Type            *string `json:"type"`
+	Extension       *string `json:"extension"`
+	Size            *uint64 `json:"size"`
+	ExpectedVersion *int    `json:"expectedVersion"`
+}
+
+func (a *api) updateFile(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+	var body updateFileRequest
+	if err := readBody(r, &body); err != nil {
+		return err
+	}
+
+	version, err := util.ToExpectedVersion(body.ExpectedVersion)
+	if err != nil {
+		return err
+	}
+	file, err := a.fileSvc.PatchFile(r.Context(), userId, id, body.Name, body.Type, body.Extension, body.Size, version)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, util.ToFileDto(file))
+	return nil
+}
+
+func (a *api) deleteFile(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	userId, id, err := userAndPathID(r, params)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := a.fileSvc.DeleteFile(r.Context(), userId, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("%w: file %d", service.ErrNotFound, id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// writeFolder() writes the folder with its path, as the GraphQL API resolves it
+func (a *api) writeFolder(w http.ResponseWriter, r *http.Request, status int, userId uint, folder *model.Folder) error {
+	folder, err := a.withPath(r, userId, folder)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, status, folder)
+	return nil
+}
+
+func (a *api) withPath(r *http.Request, userId uint, folder *model.Folder) (*model.Folder, error) {
+	path, err := a.folderSvc.GetPathOrNil(r.Context(), userId, *util.AtoUIOrNil(&folder.ID))
+	if err != nil {
+		return nil, err
+	}
+	folder.Path = path
+	return folder, nil
+}
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/graph/model"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	"net/http"
+)
+
+// the largest request body read, bodies carry names and numbers only
+const maxBodyBytes = 1 << 20
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+// errorDetail carries the same code as the code extension of a GraphQL error
+type errorDetail struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+// userID() reads the userId query parameter every operation is scoped to, as the GraphQL userId argument
+func userID(r *http.Request) (uint, error) {
+	return parseID("userId", r.URL.Query().Get("userId"))
+}
+
+func userAndPathID(r *http.Request, params map[string]string) (uint, uint, error) {
+	userId, err := userID(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	id := params["id"]
+	idInt := util.AtoUIOrNil(&id)
+	if idInt == nil {
+		return 0, 0, fmt.Errorf("%w: %q", service.ErrNotFound, id)
+	}
+	return userId, *idInt, nil
+}
+
+func parseID(name string, value string) (uint, error) {
+	id := util.AtoUIOrNil(&value)
+	if id == nil {
+		return 0, fmt.Errorf("%w: %s must be an id, got %q", errBadRequest, name, value)
+	}
+	return *id, nil
+}
+
+func conflictStrategy(strategy *model.ConflictStrategy) (service.ConflictStrategy, error) {
+	if strategy != nil && !strategy.IsValid() {
+		return "", fmt.Errorf("%w: unknown conflictStrategy %q", errBadRequest, *strategy)
+	}
+	return util.ToConflictStrategy(strategy), nil
+}
+
+// readBody() decodes the JSON body, fields the operation does not know are rejected
+func readBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("failed to write response", "error", err)
+	}
+}
+
+// writeError() maps errors by the codes the GraphQL API reports, unexpected errors are logged and not disclosed
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := util.ErrorCode(err)
+
+	var status int
+	switch {
+	case errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case code == "NOT_FOUND":
+		status = http.StatusNotFound
+	case code == "CONFLICT", code == "ROLLED_BACK":
+		status = http.StatusConflict
+	case code == "INVALID_NAME", code == "INVALID_MOVE", code == "INVALID_VERSION":
+		status = http.StatusUnprocessableEntity
+	default:
+		logger.ErrorContext(r.Context(), "request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeJSON(w, http.StatusInternalServerError, errorBody{Error: errorDetail{Message: "internal server error"}})
+		return
+	}
+
+	writeJSON(w, status, errorBody{Error: errorDetail{Message: err.Error(), Code: code}})
+}
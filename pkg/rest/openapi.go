@@ -0,0 +1,57 @@
+package rest
+
+import (
+	_ "embed"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+func serveSpec(w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, err := w.Write(spec)
+	return err
+}
+
+// checkSpec() fails when an operation of the document has no route or a route is not documented
+func checkSpec(routes []route) error {
+	var document struct {
+		Paths map[string]map[string]interface{} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(spec, &document); err != nil {
+		return fmt.Errorf("failed to parse the OpenAPI document: %w", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range document.Paths {
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	var mismatches []string
+	for _, route := range routes {
+		operation := route.method + " " + route.pattern
+		if !documented[operation] {
+			mismatches = append(mismatches, operation+" is not documented")
+		}
+		delete(documented, operation)
+	}
+	for operation := range documented {
+		mismatches = append(mismatches, operation+" is documented but not served")
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("the OpenAPI document does not match the API: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
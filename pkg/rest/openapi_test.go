@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSpecMatchesTheRoutes(t *testing.T) {
+	if err := checkSpec((&api{}).routes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSpecDetectsDrift(t *testing.T) {
+	routes := (&api{}).routes()
+	tests := []struct {
+		name     string
+		routes   []route
+		expected string
+	}{
+		{
+			name:     "a documented operation without route",
+			routes:   routes[1:],
+			expected: "GET /api/v1/openapi.yaml is documented but not served",
+		},
+		{
+			name:     "a route without operation",
+			routes:   append(append([]route(nil), routes...), route{method: http.MethodPut, pattern: "/api/v1/files/{id}"}),
+			expected: "PUT /api/v1/files/{id} is not documented",
+		},
+		{
+			name:     "a route under another parameter name",
+			routes:   append(append([]route(nil), routes[:len(routes)-1]...), route{method: http.MethodDelete, pattern: "/api/v1/files/{fileId}"}),
+			expected: "DELETE /api/v1/files/{fileId} is not documented",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkSpec(test.routes)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		params  map[string]string
+	}{
+		{"/api/v1/folders/{id}", "/api/v1/folders/7", map[string]string{"id": "7"}},
+		{"/api/v1/folders/{id}/children", "/api/v1/folders/7/children/", map[string]string{"id": "7"}},
+		{"/api/v1/folders/root", "/api/v1/folders/root", map[string]string{}},
+		{"/api/v1/folders/{id}", "/api/v1/folders/7/children", nil},
+		{"/api/v1/folders/root", "/api/v1/folders/7", nil},
+	}
+	for _, test := range tests {
+		params, ok := match(test.pattern, test.path)
+		if ok != (test.params != nil) || len(params) != len(test.params) || params["id"] != test.params["id"] {
+			t.Errorf("match(%s, %s) = %v, %v", test.pattern, test.path, params, ok)
+		}
+	}
+}
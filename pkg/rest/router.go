@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// route is one operation of the API, the same method and path as documented in the OpenAPI document
+type route struct {
+	method  string
+	pattern string
+	handle  func(w http.ResponseWriter, r *http.Request, params map[string]string) error
+}
+
+// router matches requests against the routes in order, path segments in braces are parameters
+type router struct {
+	routes []route
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := match(route.pattern, r.URL.Path)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+
+		if err := route.handle(w, r, params); err != nil {
+			writeError(w, r, err)
+		}
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: errorDetail{Message: "method not allowed"}})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, errorBody{Error: errorDetail{Message: "no such endpoint", Code: "NOT_FOUND"}})
+}
+
+// match() returns the parameters of the path when it matches the pattern
+func match(pattern string, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
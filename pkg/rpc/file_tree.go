@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	filev1 "github.com/potatowhite/books/file-service/proto/file/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// number of children ListChildren() reads per query
+const listPageSize = 500
+
+// fileTreeServer implements the FileTree service on the folder and file services
+type fileTreeServer struct {
+	filev1.UnimplementedFileTreeServer
+
+	folderSvc service.FolderService
+	fileSvc   service.FileService
+}
+
+func (s *fileTreeServer) GetRootFolder(ctx context.Context, req *filev1.GetRootFolderRequest) (*filev1.Folder, error) {
+	if err := requireIDs(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	folder, err := s.folderSvc.GetRootFolder(ctx, uint(req.GetUserId()))
+	if err != nil {
+		return nil, err
+	}
+	return toFolder(folder), nil
+}
+
+func (s *fileTreeServer) GetFolder(ctx context.Context, req *filev1.GetFolderRequest) (*filev1.Folder, error) {
+	if err := requireIDs(req.GetUserId(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	folder, err := s.folderSvc.GetFolder(ctx, uint(req.GetUserId()), uint(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return toFolder(folder), nil
+}
+
+func (s *fileTreeServer) GetFile(ctx context.Context, req *filev1.GetFileRequest) (*filev1.File, error) {
+	if err := requireIDs(req.GetUserId(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	file, err := s.fileSvc.GetFile(ctx, uint(req.GetUserId()), uint(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return toFile(file), nil
+}
+
+func (s *fileTreeServer) FileExists(ctx context.Context, req *filev1.FileExistsRequest) (*filev1.FileExistsResponse, error) {
+	if err := requireIDs(req.GetUserId(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	_, err := s.fileSvc.GetFile(ctx, uint(req.GetUserId()), uint(req.GetId()))
+	if util.ErrorCode(err) == "NOT_FOUND" {
+		return &filev1.FileExistsResponse{Exists: false}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &filev1.FileExistsResponse{Exists: true}, nil
+}
+
+func (s *fileTreeServer) GetPath(ctx context.Context, req *filev1.GetPathRequest) (*filev1.GetPathResponse, error) {
+	if err := requireIDs(req.GetUserId(), req.GetFolderId()); err != nil {
+		return nil, err
+	}
+
+	path, err := s.folderSvc.GetPathOrNil(ctx, uint(req.GetUserId()), uint(req.GetFolderId()))
+	if err != nil {
+		return nil, err
+	}
+	if path == nil {
+		return nil, fmt.Errorf("no path resolved for folder %d", req.GetFolderId())
+	}
+	return &filev1.GetPathResponse{Path: *path}, nil
+}
+
+// ListChildren() sends one message per child, so large folders are not limited by the message size.
+// Children are read page by page and sent as they arrive, never holding a whole large folder in memory.
+func (s *fileTreeServer) ListChildren(req *filev1.ListChildrenRequest, stream filev1.FileTree_ListChildrenServer) error {
+	if err := requireIDs(req.GetUserId(), req.GetFolderId()); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	userId, folderId := uint(req.GetUserId()), uint(req.GetFolderId())
+
+	// a missing folder is not mistaken for an empty one
+	if _, err := s.folderSvc.GetFolder(ctx, userId, folderId); err != nil {
+		return err
+	}
+
+	for afterId := uint(0); ; {
+		folders, err := s.folderSvc.GetChildrenPage(ctx, userId, folderId, afterId, listPageSize)
+		if err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			if err := stream.Send(&filev1.Entry{Entry: &filev1.Entry_Folder{Folder: toFolder(folder)}}); err != nil {
+				return err
+			}
+		}
+		if len(folders) < listPageSize {
+			break
+		}
+		afterId = folders[len(folders)-1].ID
+	}
+
+	for afterId := uint(0); ; {
+		files, err := s.fileSvc.GetChildrenPage(ctx, userId, folderId, afterId, listPageSize)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := stream.Send(&filev1.Entry{Entry: &filev1.Entry_File{File: toFile(file)}}); err != nil {
+				return err
+			}
+		}
+		if len(files) < listPageSize {
+			return nil
+		}
+		afterId = files[len(files)-1].ID
+	}
+}
+
+func (s *fileTreeServer) GetUsage(ctx context.Context, req *filev1.GetUsageRequest) (*filev1.Usage, error) {
+	if err := requireIDs(req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	folders, err := s.folderSvc.CountFolders(ctx, uint(req.GetUserId()))
+	if err != nil {
+		return nil, err
+	}
+	files, bytes, err := s.fileSvc.GetUsage(ctx, uint(req.GetUserId()))
+	if err != nil {
+		return nil, err
+	}
+	return &filev1.Usage{Folders: uint64(folders), Files: uint64(files), Bytes: uint64(bytes)}, nil
+}
+
+func (s *fileTreeServer) CreateFolder(ctx context.Context, req *filev1.CreateFolderRequest) (*filev1.Folder, error) {
+	if err := requireIDs(req.GetUserId(), req.GetParentId()); err != nil {
+		return nil, err
+	}
+	strategy, err := toConflictStrategy(req.GetConflictStrategy())
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := s.folderSvc.CreateFolder(ctx, uint(req.GetUserId()), req.GetName(), uint(req.GetParentId()), strategy)
+	if err != nil {
+		return nil, err
+	}
+	return toFolder(folder), nil
+}
+
+func (s *fileTreeServer) CreateFile(ctx context.Context, req *filev1.CreateFileRequest) (*filev1.File, error) {
+	if err := requireIDs(req.GetUserId(), req.GetFolderId()); err != nil {
+		return nil, err
+	}
+	strategy, err := toConflictStrategy(req.GetConflictStrategy())
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.fileSvc.CreateFile(ctx, uint(req.GetUserId()), req.GetName(), uint(req.GetFolderId()), strategy)
+	if err != nil {
+		return nil, err
+	}
+	return toFile(file), nil
+}
+
+func (s *fileTreeServer) DeleteFolder(ctx context.Context, req *filev1.DeleteFolderRequest) (*filev1.DeleteResponse, error) {
+	if err := requireIDs(req.GetUserId(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.folderSvc.DeleteFolder(ctx, uint(req.GetUserId()), uint(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return &filev1.DeleteResponse{Deleted: deleted}, nil
+}
+
+func (s *fileTreeServer) DeleteFile(ctx context.Context, req *filev1.DeleteFileRequest) (*filev1.DeleteResponse, error) {
+	if err := requireIDs(req.GetUserId(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.fileSvc.DeleteFile(ctx, uint(req.GetUserId()), uint(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return &filev1.DeleteResponse{Deleted: deleted}, nil
+}
+
+// requireIDs() rejects requests missing an id, ids start at 1
+func requireIDs(ids ...uint64) error {
+	for _, id := range ids {
+		if id == 0 {
+			return status.Error(codes.InvalidArgument, "ids are required")
+		}
+	}
+	return nil
+}
+
+func toConflictStrategy(strategy filev1.ConflictStrategy) (service.ConflictStrategy, error) {
+	switch strategy {
+	case filev1.ConflictStrategy_CONFLICT_STRATEGY_UNSPECIFIED, filev1.ConflictStrategy_CONFLICT_STRATEGY_FAIL:
+		return service.ConflictFail, nil
+	case filev1.ConflictStrategy_CONFLICT_STRATEGY_RENAME:
+		return service.ConflictRename, nil
+	case filev1.ConflictStrategy_CONFLICT_STRATEGY_REPLACE:
+		return service.ConflictReplace, nil
+	case filev1.ConflictStrategy_CONFLICT_STRATEGY_KEEP_BOTH:
+		return service.ConflictKeepBoth, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "unknown conflict strategy %d", strategy)
+	}
+}
+
+func toFolder(folder *entity.Folder) *filev1.Folder {
+	dto := &filev1.Folder{
+		Id:        uint64(folder.ID),
+		Name:      folder.Name,
+		UserId:    uint64(folder.UserId),
+		Version:   uint64(folder.Version),
+		CreatedAt: timestamppb.New(folder.CreatedAt),
+		UpdatedAt: timestamppb.New(folder.UpdatedAt),
+	}
+	if folder.ParentId != nil {
+		dto.ParentId = uint64(*folder.ParentId)
+	}
+	return dto
+}
+
+func toFile(file *entity.File) *filev1.File {
+	return &filev1.File{
+		Id:        uint64(file.ID),
+		Name:      file.Name,
+		FolderId:  uint64(file.FolderId),
+		Type:      file.Type,
+		Extension: file.Extension,
+		Size:      file.Size,
+		UserId:    uint64(file.UserId),
+		Version:   uint64(file.Version),
+		CreatedAt: timestamppb.New(file.CreatedAt),
+		UpdatedAt: timestamppb.New(file.UpdatedAt),
+	}
+}
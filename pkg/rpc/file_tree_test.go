@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"context"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	filev1 "github.com/potatowhite/books/file-service/proto/file/v1"
+	"google.golang.org/grpc"
+	"testing"
+)
+
+// pagedFolders serves count child folders and count files, page by page
+type pagedFolders struct {
+	service.FolderService
+	count int
+	pages int
+}
+
+func (f *pagedFolders) GetFolder(ctx context.Context, userId uint, id uint) (*entity.Folder, error) {
+	folder := &entity.Folder{UserId: userId}
+	folder.ID = id
+	return folder, nil
+}
+
+func (f *pagedFolders) GetChildrenPage(ctx context.Context, userId uint, parentID uint, afterId uint, limit int) ([]*entity.Folder, error) {
+	f.pages++
+	var folders []*entity.Folder
+	for id := afterId + 1; id <= uint(f.count) && len(folders) < limit; id++ {
+		folder := &entity.Folder{ParentId: &parentID}
+		folder.ID = id
+		folders = append(folders, folder)
+	}
+	return folders, nil
+}
+
+type pagedFiles struct {
+	service.FileService
+	count int
+	pages int
+}
+
+func (f *pagedFiles) GetChildrenPage(ctx context.Context, userId uint, folderId uint, afterId uint, limit int) ([]*entity.File, error) {
+	f.pages++
+	var files []*entity.File
+	for id := afterId + 1; id <= uint(f.count) && len(files) < limit; id++ {
+		file := &entity.File{FolderId: folderId}
+		file.ID = id
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// entryStream collects the entries sent, the embedded stream panics on anything else
+type entryStream struct {
+	grpc.ServerStream
+	entries []*filev1.Entry
+}
+
+func (s *entryStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *entryStream) Send(entry *filev1.Entry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func TestListChildrenSendsEveryPage(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int
+		expectedPages int
+	}{
+		{"empty", 0, 1},
+		{"one page", listPageSize - 1, 1},
+		{"full page", listPageSize, 2},
+		{"several pages", 2*listPageSize + 1, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			folders := &pagedFolders{count: test.count}
+			files := &pagedFiles{count: test.count}
+			server := &fileTreeServer{folderSvc: folders, fileSvc: files}
+
+			stream := &entryStream{}
+			if err := server.ListChildren(&filev1.ListChildrenRequest{UserId: 1, FolderId: 2}, stream); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stream.entries) != 2*test.count {
+				t.Fatalf("expected %d entries, got %d", 2*test.count, len(stream.entries))
+			}
+			for i, entry := range stream.entries {
+				// folders first, each kind in id order without gaps or repeats
+				id := uint64(i%max(test.count, 1)) + 1
+				if i < test.count && entry.GetFolder().GetId() != id {
+					t.Fatalf("entry %d: expected folder %d, got %v", i, id, entry)
+				} else if i >= test.count && entry.GetFile().GetId() != id {
+					t.Fatalf("entry %d: expected file %d, got %v", i, id, entry)
+				}
+			}
+			if folders.pages != test.expectedPages || files.pages != test.expectedPages {
+				t.Fatalf("expected %d pages each, got %d and %d", test.expectedPages, folders.pages, files.pages)
+			}
+		})
+	}
+}
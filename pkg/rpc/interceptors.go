@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"context"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// errorDomain is the domain of the ErrorInfo detail of errors
+const errorDomain = "file-service"
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = withRequestIDs(ctx)
+	resp, err := handler(ctx, req)
+	return resp, toStatus(ctx, info.FullMethod, err)
+}
+
+func streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := withRequestIDs(stream.Context())
+	err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	return toStatus(ctx, info.FullMethod, err)
+}
+
+// contextStream replaces the context of a stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// withRequestIDs() takes the request and correlation ids from the metadata, as the HTTP middleware does from headers
+func withRequestIDs(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	requestID := first(md, logging.RequestIDHeader)
+	if requestID == "" {
+		requestID = logging.NewID()
+	}
+	ctx = logging.WithRequestID(ctx, requestID)
+
+	if correlationID := first(md, logging.CorrelationIDHeader); correlationID != "" {
+		ctx = logging.WithCorrelationID(ctx, correlationID)
+	}
+	return ctx
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(strings.ToLower(key)); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// toStatus() maps errors by the codes the GraphQL API reports, the code is sent as the reason of an ErrorInfo.
+// Unexpected errors are logged and not disclosed.
+func toStatus(ctx context.Context, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := util.ErrorCode(err)
+	var grpcCode codes.Code
+	switch code {
+	case "NOT_FOUND":
+		grpcCode = codes.NotFound
+	case "CONFLICT":
+		grpcCode = codes.AlreadyExists
+	case "ROLLED_BACK":
+		grpcCode = codes.Aborted
+	case "INVALID_NAME", "INVALID_MOVE":
+		grpcCode = codes.InvalidArgument
+	default:
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		logger.ErrorContext(ctx, "grpc call failed", "method", method, "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	st, detailErr := status.New(grpcCode, err.Error()).WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: errorDomain})
+	if detailErr != nil {
+		return status.Error(grpcCode, err.Error())
+	}
+	return st.Err()
+}
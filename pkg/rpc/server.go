@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/health"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	filev1 "github.com/potatowhite/books/file-service/proto/file/v1"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"net"
+	"time"
+)
+
+var (
+	logger = logging.For("rpc")
+)
+
+// the gRPC health service follows the readiness checks at this interval
+const healthInterval = 5 * time.Second
+
+// Server serves the FileTree service with the standard health and reflection services on a port of its own
+type Server struct {
+	grpc      *grpc.Server
+	health    *grpchealth.Server
+	readiness *health.Health
+	done      chan struct{}
+}
+
+func NewServer(folderSvc service.FolderService, fileSvc service.FileService, serviceHealth *health.Health) *Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamInterceptor),
+	)
+	filev1.RegisterFileTreeServer(server, &fileTreeServer{folderSvc: folderSvc, fileSvc: fileSvc})
+
+	healthServer := grpchealth.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+
+	return &Server{grpc: server, health: healthServer, readiness: serviceHealth, done: make(chan struct{})}
+}
+
+// Serve() listens on the port until Shutdown()
+func (s *Server) Serve(port string) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+
+	go s.followReadiness()
+
+	logger.Info("serving gRPC", "port", port)
+	if err := s.grpc.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown() reports not serving and drains the calls in flight, cancelling those still running when ctx ends
+func (s *Server) Shutdown(ctx context.Context) {
+	close(s.done)
+	s.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Error("failed to drain grpc server", "error", ctx.Err())
+		s.grpc.Stop()
+	}
+}
+
+func (s *Server) followReadiness() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+		if s.readiness.Readiness(context.Background()).Status != health.StatusUp {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+		s.health.SetServingStatus("", status)
+		s.health.SetServingStatus(filev1.FileTree_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
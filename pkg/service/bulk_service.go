@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+)
+
+// ErrRolledBack is reported for the items of an atomic bulk operation that failed because of another item
+var ErrRolledBack = errors.New("rolled back, another item failed")
+
+// the largest number of items of a single bulk operation
+const maxBulkItems = 1000
+
+// BulkMode decides whether a bulk operation applies all items or as many as possible
+type BulkMode string
+
+const (
+	// BulkAtomic applies every item in one transaction, a single failure rolls back all of them
+	BulkAtomic BulkMode = "ATOMIC"
+	// BulkBestEffort applies every item in its own transaction and reports the outcome per item
+	BulkBestEffort BulkMode = "BEST_EFFORT"
+)
+
+// BulkItems selects the files and folders of a bulk operation.
+// A move fails items that are not at their expected version, items without one move at any version.
+type BulkItems struct {
+	FileIds        []uint
+	FolderIds      []uint
+	FileVersions   map[uint]uint
+	FolderVersions map[uint]uint
+}
+
+// expectedVersion() returns the version the item is expected at, or nil
+func (i BulkItems) expectedVersion(item *BulkItemResult) *uint {
+	versions := i.FileVersions
+	if item.IsFolder {
+		versions = i.FolderVersions
+	}
+	if version, ok := versions[item.Id]; ok {
+		return &version
+	}
+	return nil
+}
+
+// BulkItemResult is the outcome of one item, File or Folder is the item after a move or its copy
+type BulkItemResult struct {
+	Id       uint
+	IsFolder bool
+	File     *entity.File
+	Folder   *entity.Folder
+	Err      error
+	// the files copied for the item, their contents are copied after the copies committed
+	copies []fileCopy
+}
+
+// fileCopy is a file copied from the file with the id source
+type fileCopy struct {
+	source uint
+	copied uint
+}
+
+// BulkResult lists the outcome of every item in the order files, then folders
+type BulkResult struct {
+	Items []*BulkItemResult
+}
+
+// Succeeded() reports whether every item was applied
+func (r *BulkResult) Succeeded() bool {
+	for _, item := range r.Items {
+		if item.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+type BulkService interface {
+	Delete(ctx context.Context, userId uint, items BulkItems, mode BulkMode) (*BulkResult, error)
+	Move(ctx context.Context, userId uint, items BulkItems, targetFolderId uint, strategy ConflictStrategy, mode BulkMode) (*BulkResult, error)
+	Copy(ctx context.Context, userId uint, items BulkItems, targetFolderId uint, strategy ConflictStrategy, mode BulkMode) (*BulkResult, error)
+}
+
+func NewBulkService(folderSvc FolderService, fileSvc FileService, naming *NamingPolicy, blobs storage.BlobStore, tx repository.Transactor) BulkService {
+	return &bulkService{folderSvc: folderSvc, fileSvc: fileSvc, naming: naming, blobs: blobs, tx: tx}
+}
+
+type bulkService struct {
+	folderSvc FolderService
+	fileSvc   FileService
+	naming    *NamingPolicy
+	blobs     storage.BlobStore
+	tx        repository.Transactor
+}
+
+func (b *bulkService) Delete(ctx context.Context, userId uint, items BulkItems, mode BulkMode) (*BulkResult, error) {
+	return b.apply(ctx, items, mode, func(ctx context.Context, item *BulkItemResult) error {
+		var deleted bool
+		var err error
+		if item.IsFolder {
+			deleted, err = b.folderSvc.DeleteFolder(ctx, userId, item.Id)
+		} else {
+			deleted, err = b.fileSvc.DeleteFile(ctx, userId, item.Id)
+		}
+
+		if err == nil && !deleted {
+			return fmt.Errorf("%w: item %v", ErrNotFound, item.Id)
+		}
+		return err
+	})
+}
+
+func (b *bulkService) Move(ctx context.Context, userId uint, items BulkItems, targetFolderId uint, strategy ConflictStrategy, mode BulkMode) (*BulkResult, error) {
+	if _, err := b.folderSvc.GetFolder(ctx, userId, targetFolderId); err != nil {
+		return nil, err
+	}
+
+	return b.apply(ctx, items, mode, func(ctx context.Context, item *BulkItemResult) error {
+		var err error
+		if item.IsFolder {
+			item.Folder, err = b.folderSvc.MoveFolder(ctx, userId, item.Id, targetFolderId, strategy, items.expectedVersion(item))
+		} else {
+			item.File, err = b.fileSvc.MoveFile(ctx, userId, item.Id, targetFolderId, strategy, items.expectedVersion(item))
+		}
+		return err
+	})
+}
+
+// Copy() copies the rows of the items in transactions and their contents afterwards,
+// the blob store takes no part in transactions
+func (b *bulkService) Copy(ctx context.Context, userId uint, items BulkItems, targetFolderId uint, strategy ConflictStrategy, mode BulkMode) (*BulkResult, error) {
+	if _, err := b.folderSvc.GetFolder(ctx, userId, targetFolderId); err != nil {
+		return nil, err
+	}
+
+	result, err := b.apply(ctx, items, mode, func(ctx context.Context, item *BulkItemResult) error {
+		var err error
+		if item.IsFolder {
+			if strategy == ConflictReplace {
+				if err := b.checkReplace(ctx, userId, item.Id, targetFolderId); err != nil {
+					return err
+				}
+			}
+			item.Folder, err = b.copyFolder(ctx, userId, item, item.Id, targetFolderId, strategy)
+		} else {
+			item.File, err = b.copyFile(ctx, userId, item, item.Id, targetFolderId, strategy)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.copyContents(ctx, userId, result, mode)
+	return result, nil
+}
+
+// checkReplace() fails with ErrInvalidMove when the copy of the folder would replace the folder itself,
+// or a folder it lies in, since the source would be deleted before it is copied
+func (b *bulkService) checkReplace(ctx context.Context, userId uint, id uint, parentId uint) error {
+	source, err := b.folderSvc.GetFolder(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+
+	siblings, err := b.folderSvc.GetChildren(ctx, userId, parentId)
+	if err != nil {
+		return err
+	}
+	for _, sibling := range siblings {
+		if !b.naming.SameName(sibling.Name, source.Name) {
+			continue
+		}
+		// the sibling is the source or holds it
+		if err := b.folderSvc.CheckPlacement(ctx, userId, sibling.ID, id); errors.Is(err, ErrInvalidMove) {
+			return fmt.Errorf("%w: the copy of folder %v cannot replace the folder %v it is copied from", ErrInvalidMove, id, sibling.ID)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFolder() copies the folder with everything below it
+func (b *bulkService) copyFolder(ctx context.Context, userId uint, item *BulkItemResult, id uint, parentId uint, strategy ConflictStrategy) (*entity.Folder, error) {
+	source, err := b.folderSvc.GetFolder(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// a folder copied into itself would copy its own copy forever
+	if err := b.folderSvc.CheckPlacement(ctx, userId, id, parentId); err != nil {
+		return nil, err
+	}
+
+	copied, err := b.folderSvc.CreateFolder(ctx, userId, source.Name, parentId, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(source.Metadata) > 0 {
+		if copied, err = b.folderSvc.SetMetadata(ctx, userId, copied.ID, source.Metadata, false, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := b.fileSvc.GetChildren(ctx, userId, source.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if _, err := b.copyFile(ctx, userId, item, file.ID, copied.ID, strategy); err != nil {
+			return nil, err
+		}
+	}
+
+	folders, err := b.folderSvc.GetChildren(ctx, userId, source.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if _, err := b.copyFolder(ctx, userId, item, folder.ID, copied.ID, strategy); err != nil {
+			return nil, err
+		}
+	}
+
+	return copied, nil
+}
+
+// copyFile() copies the file, its content is copied by copyContents()
+func (b *bulkService) copyFile(ctx context.Context, userId uint, item *BulkItemResult, id uint, folderId uint, strategy ConflictStrategy) (*entity.File, error) {
+	copied, err := b.fileSvc.CopyFile(ctx, userId, id, folderId, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	item.copies = append(item.copies, fileCopy{source: id, copied: copied.ID})
+	return copied, nil
+}
+
+// copyContents() copies the contents of the committed copies. An item whose content fails is removed again,
+// in atomic mode together with every other item.
+func (b *bulkService) copyContents(ctx context.Context, userId uint, result *BulkResult, mode BulkMode) {
+	var committed []*BulkItemResult
+	for _, item := range result.Items {
+		if item.Err == nil {
+			committed = append(committed, item)
+		}
+	}
+
+	failed := false
+	for _, item := range committed {
+		if failed && mode != BulkBestEffort {
+			break
+		}
+		for _, c := range item.copies {
+			if err := b.copyContent(ctx, c.source, c.copied); err != nil {
+				item.Err = err
+				failed = true
+				break
+			}
+		}
+	}
+	if !failed {
+		return
+	}
+
+	for _, item := range committed {
+		if item.Err == nil && mode != BulkBestEffort {
+			item.Err = ErrRolledBack
+		}
+		if item.Err != nil {
+			b.removeCopy(ctx, userId, item)
+			item.File, item.Folder = nil, nil
+		}
+	}
+}
+
+func (b *bulkService) copyContent(ctx context.Context, source uint, copied uint) error {
+	content, _, err := b.blobs.Open(ctx, source)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	_, err = b.blobs.Put(ctx, copied, content)
+	return err
+}
+
+// removeCopy() deletes the copies of the item together with the contents copied so far.
+// Files replaced by a copy come back, a folder replaced by a copy stays deleted.
+func (b *bulkService) removeCopy(ctx context.Context, userId uint, item *BulkItemResult) {
+	ctx = context.WithoutCancel(ctx)
+	for _, c := range item.copies {
+		if err := b.fileSvc.DiscardFile(ctx, userId, c.copied); err != nil {
+			logger.WarnContext(ctx, "failed to remove copy of failed bulk item", "file_id", c.copied, "error", err)
+		}
+	}
+	if item.Folder != nil {
+		if _, err := b.folderSvc.DeleteFolder(ctx, userId, item.Folder.ID); err != nil {
+			logger.WarnContext(ctx, "failed to remove copy of failed bulk item", "folder_id", item.Folder.ID, "error", err)
+		}
+	}
+}
+
+// apply() runs fn for every item, all in one transaction or each in its own depending on the mode
+func (b *bulkService) apply(ctx context.Context, items BulkItems, mode BulkMode, fn func(ctx context.Context, item *BulkItemResult) error) (*BulkResult, error) {
+	if count := len(items.FileIds) + len(items.FolderIds); count > maxBulkItems {
+		return nil, fmt.Errorf("a bulk operation takes at most %d items, got %d", maxBulkItems, count)
+	}
+
+	result := &BulkResult{}
+	for _, id := range items.FileIds {
+		result.Items = append(result.Items, &BulkItemResult{Id: id})
+	}
+	for _, id := range items.FolderIds {
+		result.Items = append(result.Items, &BulkItemResult{Id: id, IsFolder: true})
+	}
+
+	if mode == BulkBestEffort {
+		for _, item := range result.Items {
+			// a folder is copied or moved completely or not at all
+			item.Err = b.tx.Transaction(ctx, func(ctx context.Context) error {
+				return fn(ctx, item)
+			})
+		}
+		return result, nil
+	}
+
+	err := b.tx.Transaction(ctx, func(ctx context.Context) error {
+		for _, item := range result.Items {
+			if item.Err = fn(ctx, item); item.Err != nil {
+				return item.Err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, item := range result.Items {
+			item.File, item.Folder = nil, nil
+			if item.Err == nil {
+				item.Err = ErrRolledBack
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/config"
+	"io"
+	"testing"
+)
+
+// trackingTransactor runs fn without a transaction and counts the transactions open
+type trackingTransactor struct {
+	open int
+}
+
+func (t *trackingTransactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	t.open++
+	defer func() { t.open-- }()
+	return fn(ctx)
+}
+
+// bulkBlobs fails the test on content I/O inside a transaction and fails to store the content "broken"
+type bulkBlobs struct {
+	*fakeBlobStore
+	t    *testing.T
+	tx   *trackingTransactor
+	puts int
+}
+
+func (b *bulkBlobs) Open(ctx context.Context, id uint) (io.ReadCloser, int64, error) {
+	if b.tx.open > 0 {
+		b.t.Errorf("content %v opened inside a transaction", id)
+	}
+	return b.fakeBlobStore.Open(ctx, id)
+}
+
+func (b *bulkBlobs) Put(ctx context.Context, id uint, content io.Reader) (int64, error) {
+	if b.tx.open > 0 {
+		b.t.Errorf("content %v stored inside a transaction", id)
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return 0, err
+	} else if string(data) == "broken" {
+		return 0, errors.New("disk is full")
+	}
+	b.puts++
+	b.contents[id] = data
+	return int64(len(data)), nil
+}
+
+type bulkFixture struct {
+	folders *fakeFolderRepository
+	files   *fakeFileRepository
+	blobs   *bulkBlobs
+	bulk    BulkService
+}
+
+// newBulkFixture() returns the folder docs (2) holding a.txt (1) and b.txt (2), and the empty folder out (3)
+func newBulkFixture(t *testing.T) *bulkFixture {
+	folders := newFakeFolderRepository()
+	folders.add("docs", 1)
+	folders.add("out", 1)
+
+	files := newFakeFileRepository(1, 2)
+	files.files[1].Name, files.files[1].FolderId = "a.txt", 2
+	files.files[2].Name, files.files[2].FolderId = "b.txt", 2
+
+	tx := &trackingTransactor{}
+	blobs := &bulkBlobs{fakeBlobStore: newFakeBlobStore(1, 2), t: t, tx: tx}
+	blobs.contents[2] = []byte("second")
+
+	naming := NewNamingPolicy(config.Naming{})
+	folderSvc := NewFolderService(folders, naming, nil, blobs, tx)
+	fileSvc := NewFileService(files, naming, nil, blobs, tx)
+	return &bulkFixture{folders: folders, files: files, blobs: blobs, bulk: NewBulkService(folderSvc, fileSvc, naming, blobs, tx)}
+}
+
+func TestCopyStoresContentsAfterTheTransaction(t *testing.T) {
+	f := newBulkFixture(t)
+
+	result, err := f.bulk.Copy(context.Background(), 7, BulkItems{FolderIds: []uint{2}}, 3, ConflictFail, BulkAtomic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Succeeded() || result.Items[0].Folder == nil {
+		t.Fatalf("expected the folder to be copied, got %+v", result.Items[0])
+	}
+
+	copied, _ := f.files.GetFilesByFolderId(context.Background(), 7, result.Items[0].Folder.ID)
+	if len(copied) != 2 {
+		t.Fatalf("expected both files to be copied, got %v", copied)
+	}
+	for _, file := range copied {
+		expected := map[string]string{"a.txt": "content", "b.txt": "second"}[file.Name]
+		if string(f.blobs.contents[file.ID]) != expected {
+			t.Errorf("expected the content %q for the copy of %s, got %q", expected, file.Name, f.blobs.contents[file.ID])
+		}
+	}
+}
+
+func TestCopyStoresNoContentForRolledBackCopies(t *testing.T) {
+	f := newBulkFixture(t)
+
+	result, err := f.bulk.Copy(context.Background(), 7, BulkItems{FileIds: []uint{1, 99}}, 3, ConflictFail, BulkAtomic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(result.Items[0].Err, ErrRolledBack) || !errors.Is(result.Items[1].Err, ErrNotFound) {
+		t.Fatalf("expected the copy to be rolled back, got %v and %v", result.Items[0].Err, result.Items[1].Err)
+	}
+	if f.blobs.puts != 0 {
+		t.Fatalf("expected no content to be stored, got %d", f.blobs.puts)
+	}
+}
+
+func TestCopyRemovesCopiesWhoseContentFails(t *testing.T) {
+	tests := []struct {
+		mode  BulkMode
+		first error
+	}{
+		{mode: BulkBestEffort},
+		{mode: BulkAtomic, first: ErrRolledBack},
+	}
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			f := newBulkFixture(t)
+			f.blobs.contents[2] = []byte("broken")
+
+			result, err := f.bulk.Copy(context.Background(), 7, BulkItems{FileIds: []uint{1, 2}}, 3, ConflictFail, test.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(result.Items[0].Err, test.first) || result.Items[1].Err == nil {
+				t.Fatalf("expected %v and a failure, got %v and %v", test.first, result.Items[0].Err, result.Items[1].Err)
+			}
+
+			kept, _ := f.files.GetFilesByFolderId(context.Background(), 7, 3)
+			if test.first == nil && (len(kept) != 1 || kept[0].Name != "a.txt" || string(f.blobs.contents[kept[0].ID]) != "content") {
+				t.Fatalf("expected only the copy of a.txt to be kept, got %v", kept)
+			} else if test.first != nil && len(kept) != 0 {
+				t.Fatalf("expected every copy to be removed, got %v", kept)
+			}
+			if len(f.blobs.contents) != 2+len(kept) {
+				t.Fatalf("expected the contents of removed copies to be deleted, got %v", f.blobs.contents)
+			}
+		})
+	}
+}
+
+func TestCopyRejectsReplacingTheSource(t *testing.T) {
+	f := newBulkFixture(t)
+	nested := f.folders.add("docs", 2)
+
+	// into its own parent, and into the parent of the folder of the same name holding it
+	for _, id := range []uint{2, nested.ID} {
+		result, err := f.bulk.Copy(context.Background(), 7, BulkItems{FolderIds: []uint{id}}, 1, ConflictReplace, BulkAtomic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(result.Items[0].Err, ErrInvalidMove) {
+			t.Fatalf("expected ErrInvalidMove for folder %v, got %v", id, result.Items[0].Err)
+		}
+	}
+	if f.folders.deleted[2] || f.files.deleted[1] {
+		t.Fatal("the source was deleted")
+	}
+}
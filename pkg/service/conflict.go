@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"path"
+	"strings"
+)
+
+// ConflictStrategy decides what happens when an item of the same name already exists
+type ConflictStrategy string
+
+const (
+	// ConflictFail fails with repository.ErrConflict
+	ConflictFail ConflictStrategy = "FAIL"
+	// ConflictRename stores the new item as "name (1).ext", "name (2).ext", ...
+	ConflictRename ConflictStrategy = "RENAME"
+	// ConflictReplace replaces the existing item, which is kept soft-deleted
+	ConflictReplace ConflictStrategy = "REPLACE"
+	// ConflictKeepBoth stores the new item under the name and moves the existing one to "name (1).ext", ...
+	ConflictKeepBoth ConflictStrategy = "KEEP_BOTH"
+
+	// conflictReuse returns the existing folder instead of creating one, used to merge into folders
+	conflictReuse ConflictStrategy = "REUSE"
+)
+
+// number of generated names tried before giving up, each attempt that lost a race moves on to the next
+const maxRenameAttempts = 100
+
+// numberedName() returns "name (n).ext" for files and "name (n)" for folders
+func numberedName(name string, n int, keepExtension bool) string {
+	ext := ""
+	if keepExtension {
+		ext = path.Ext(name)
+		// a leading dot marks a hidden file, not an extension
+		if ext == name {
+			ext = ""
+		}
+	}
+
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// createRenaming() calls create with name and then with numbered names until one is free.
+// Names known to be taken are skipped, a name taken concurrently is detected by its unique index and skipped as well.
+func createRenaming[T any](name string, taken func(name string) bool, keepExtension bool, create func(name string) (T, error)) (T, error) {
+	var created T
+	var err error
+
+	candidate := name
+	for n := 1; n <= maxRenameAttempts; n++ {
+		if !taken(candidate) {
+			created, err = create(candidate)
+			// a stale version is no name conflict, another name does not resolve it
+			if !errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrStaleVersion) {
+				return created, err
+			}
+		}
+		candidate = numberedName(name, n, keepExtension)
+	}
+
+	return created, fmt.Errorf("%w: no free name found for %q", repository.ErrConflict, name)
+}
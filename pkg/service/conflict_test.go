@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"testing"
+)
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepExtension bool
+		expected      string
+	}{
+		{"report.pdf", true, "report (2).pdf"},
+		{"archive.tar.gz", true, "archive.tar (2).gz"},
+		{"notes", true, "notes (2)"},
+		{".profile", true, ".profile (2)"},
+		{"release.v1", false, "release.v1 (2)"},
+	}
+	for _, test := range tests {
+		if name := numberedName(test.name, 2, test.keepExtension); name != test.expected {
+			t.Errorf("expected %q for %q, got %q", test.expected, test.name, name)
+		}
+	}
+}
+
+func TestCreateRenamingSkipsTakenNames(t *testing.T) {
+	taken := func(name string) bool { return name == "a.txt" || name == "a (1).txt" }
+	var tried []string
+	created, err := createRenaming("a.txt", taken, true, func(name string) (string, error) {
+		tried = append(tried, name)
+		return name, nil
+	})
+	if err != nil || created != "a (2).txt" || len(tried) != 1 {
+		t.Fatalf("expected a (2).txt on the first attempt, got %q after %v: %v", created, tried, err)
+	}
+}
+
+func TestCreateRenamingRetriesConcurrentlyTakenNames(t *testing.T) {
+	free := func(name string) bool { return false }
+	created, err := createRenaming("a", free, false, func(name string) (string, error) {
+		// taken by a concurrent write since the siblings were read
+		if name != "a (2)" {
+			return "", fmt.Errorf("%w: duplicate key", repository.ErrConflict)
+		}
+		return name, nil
+	})
+	if err != nil || created != "a (2)" {
+		t.Fatalf("expected a (2), got %q: %v", created, err)
+	}
+}
+
+func TestCreateRenamingGivesUp(t *testing.T) {
+	var attempts int
+	_, err := createRenaming("a", func(name string) bool { return false }, false, func(name string) (string, error) {
+		attempts++
+		return "", repository.ErrConflict
+	})
+	if !errors.Is(err, repository.ErrConflict) || attempts != maxRenameAttempts {
+		t.Fatalf("expected a conflict after %d attempts, got %v after %d", maxRenameAttempts, err, attempts)
+	}
+}
+
+func TestCreateRenamingReturnsOtherErrors(t *testing.T) {
+	failures := []error{errors.New("database is down"), repository.ErrStaleVersion}
+	for _, failure := range failures {
+		var attempts int
+		_, err := createRenaming("a", func(name string) bool { return false }, false, func(name string) (string, error) {
+			attempts++
+			return "", failure
+		})
+		if !errors.Is(err, failure) || attempts != 1 {
+			t.Fatalf("expected %v on the first attempt, got %v after %d", failure, err, attempts)
+		}
+	}
+}
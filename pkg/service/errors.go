@@ -0,0 +1,16 @@
+package service
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotFound is wrapped by errors of items that do not exist or belong to another user
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidMove is wrapped by errors of moves and copies that would break the folder tree
+	ErrInvalidMove = errors.New("invalid move")
+	// ErrInvalidTag is wrapped by errors of tag names and colours that are rejected
+	ErrInvalidTag = errors.New("invalid tag")
+	// ErrInvalidVersion is wrapped by errors of expected versions that no item can have
+	ErrInvalidVersion = errors.New("invalid version")
+)
@@ -1,38 +1,234 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/potatowhite/books/file-service/pkg/repository"
 	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/storage"
 )
 
-func NewFileService(repo repository.FileRepository) FileService {
-	return &fileService{repo: repo}
+func NewFileService(repo repository.FileRepository, naming *NamingPolicy, metadata *MetadataPolicy, blobs storage.BlobStore, tx repository.Transactor) FileService {
+	return &fileService{repo: repo, naming: naming, metadata: metadata, blobs: blobs, tx: tx}
 }
 
 type FileService interface {
-	CreateFile(userId uint, name string, folderId uint) (*entity.File, error)
-	PatchFile(userId uint, id uint, name *string, fileType *string, fileExtension *string, size *uint64) (*entity.File, error)
+	CreateFile(ctx context.Context, userId uint, name string, folderId uint, strategy ConflictStrategy) (*entity.File, error)
+	MoveFile(ctx context.Context, userId uint, id uint, folderId uint, strategy ConflictStrategy, expectedVersion *uint) (*entity.File, error)
+	MoveAndRenameFile(ctx context.Context, userId uint, id uint, folderId uint, name string, strategy ConflictStrategy, expectedVersion *uint) (*entity.File, error)
+	CopyFile(ctx context.Context, userId uint, id uint, folderId uint, strategy ConflictStrategy) (*entity.File, error)
+	PatchFile(ctx context.Context, userId uint, id uint, name *string, fileType *string, fileExtension *string, size *uint64, expectedVersion *uint) (*entity.File, error)
 
-	GetFile(userId uint, id uint) (*entity.File, error)
-	GetChildren(userId uint, folderId uint) ([]*entity.File, error)
-	DeleteFile(userId uint, id uint) (bool, error)
+	GetFile(ctx context.Context, userId uint, id uint) (*entity.File, error)
+	GetChildren(ctx context.Context, userId uint, folderId uint) ([]*entity.File, error)
+	GetChildrenPage(ctx context.Context, userId uint, folderId uint, afterId uint, limit int) ([]*entity.File, error)
+	DeleteFile(ctx context.Context, userId uint, id uint) (bool, error)
+	DiscardFile(ctx context.Context, userId uint, id uint) error
+	DeleteAllFiles(ctx context.Context, userId uint) (int64, error)
+	GetUsage(ctx context.Context, userId uint) (count int64, bytes int64, err error)
+
+	SetMetadata(ctx context.Context, userId uint, id uint, metadata map[string]interface{}, merge bool, expectedVersion *uint) (*entity.File, error)
+	FindByMetadata(ctx context.Context, userId uint, filters []repository.MetadataFilter, limit int) ([]*entity.File, error)
+
+	GetVersions(ctx context.Context, userId uint, id uint) ([]*entity.File, error)
+	RestoreVersion(ctx context.Context, userId uint, id uint, versionId uint, expectedVersion *uint) (*entity.File, error)
 }
 
 type fileService struct {
-	repo repository.FileRepository
+	repo     repository.FileRepository
+	naming   *NamingPolicy
+	metadata *MetadataPolicy
+	blobs    storage.BlobStore
+	tx       repository.Transactor
+}
+
+// DeleteFile() deletes the file together with its prior versions
+func (f *fileService) DeleteFile(ctx context.Context, userId uint, id uint) (bool, error) {
+	versions, err := f.repo.GetFileVersions(ctx, userId, id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := f.repo.DeleteFile(ctx, userId, id)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+
+	ids := []uint{id}
+	for _, version := range versions {
+		ids = append(ids, version.ID)
+	}
+	deleteContents(ctx, f.blobs, ids)
+	return true, nil
+}
+
+// DiscardFile() deletes a file that was just created together with its content,
+// the file it replaced with ConflictReplace takes its place again
+func (f *fileService) DiscardFile(ctx context.Context, userId uint, id uint) error {
+	return f.tx.Transaction(ctx, func(ctx context.Context) error {
+		versions, err := f.repo.GetFileVersions(ctx, userId, id)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := f.repo.DeleteFile(ctx, userId, id)
+		if err != nil {
+			return err
+		} else if !deleted {
+			return fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+		}
+		deleteContents(ctx, f.blobs, []uint{id})
+
+		// the most recently replaced file comes back, the other files it replaced become its versions
+		var previous *entity.File
+		for _, version := range versions {
+			if version.ReplacedById == nil || *version.ReplacedById != id {
+				continue
+			} else if previous == nil {
+				previous = version
+			} else if err := f.repo.MarkReplaced(ctx, userId, version.ID, previous.ID); err != nil {
+				return err
+			}
+		}
+		if previous == nil {
+			return nil
+		}
+		return f.repo.RestoreFile(ctx, userId, previous.ID)
+	})
 }
 
-func (f *fileService) DeleteFile(userId uint, id uint) (bool, error) {
-	return f.repo.DeleteFile(userId, id)
+// deleteContents() removes the contents of deleted files once the deletion committed.
+// Content left behind by a failure is removed with the soft-deleted row when the user is erased.
+func deleteContents(ctx context.Context, blobs storage.BlobStore, ids []uint) {
+	repository.AfterCommit(ctx, func() {
+		for _, id := range ids {
+			if err := blobs.Delete(context.WithoutCancel(ctx), id); err != nil {
+				logger.WarnContext(ctx, "failed to delete file content", "file_id", id, "error", err)
+			}
+		}
+	})
 }
 
-func (f *fileService) PatchFile(userId uint, id uint, name *string, fileType *string, fileExtension *string, size *uint64) (*entity.File, error) {
-	file, err := f.repo.GetFile(userId, id)
+// GetVersions() returns the files the file replaced, the most recently replaced first
+func (f *fileService) GetVersions(ctx context.Context, userId uint, id uint) ([]*entity.File, error) {
+	file, err := f.repo.GetFile(ctx, userId, id)
 	if err != nil {
 		return nil, err
 	} else if file == nil {
-		return nil, fmt.Errorf("file with id %v not found", id)
+		return nil, fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+	}
+
+	return f.repo.GetFileVersions(ctx, userId, id)
+}
+
+// RestoreVersion() makes a prior version the current file under its name and folder, the current file becomes
+// a prior version of it. It fails with repository.ErrStaleVersion when the file is not at expectedVersion.
+func (f *fileService) RestoreVersion(ctx context.Context, userId uint, id uint, versionId uint, expectedVersion *uint) (*entity.File, error) {
+	var restored *entity.File
+	err := f.tx.Transaction(ctx, func(ctx context.Context) error {
+		current, err := f.repo.GetFile(ctx, userId, id)
+		if err != nil {
+			return err
+		} else if current == nil {
+			return fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+		}
+		if expectedVersion != nil && *expectedVersion != current.Version {
+			return repository.ErrStaleVersion
+		}
+
+		versions, err := f.repo.GetFileVersions(ctx, userId, id)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			if version.ID == versionId {
+				restored = version
+			}
+		}
+		if restored == nil {
+			return fmt.Errorf("%w: version %v of file %v", ErrNotFound, versionId, id)
+		}
+
+		// the current file makes room first, the name is never taken twice
+		if _, err := f.repo.DeleteFile(ctx, userId, current.ID); err != nil {
+			return err
+		}
+		if err := f.repo.MarkReplaced(ctx, userId, current.ID, restored.ID); err != nil {
+			return err
+		}
+		if err := f.repo.RestoreFile(ctx, userId, restored.ID); err != nil {
+			return err
+		}
+
+		restored.Name, restored.FolderId, restored.ReplacedById = current.Name, current.FolderId, nil
+		return f.repo.UpdateFile(ctx, userId, restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+func (f *fileService) GetUsage(ctx context.Context, userId uint) (int64, int64, error) {
+	return f.repo.GetUsage(ctx, userId)
+}
+
+// DeleteAllFiles permanently removes every file of the user and its content in batches.
+// It is safe to call again after an interruption, it continues with whatever is left.
+func (f *fileService) DeleteAllFiles(ctx context.Context, userId uint) (int64, error) {
+	var total int64
+	for {
+		// stop between batches when the caller gave up, the next call continues
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		ids, err := f.repo.GetPurgeableFileIds(ctx, userId, deleteBatchSize)
+		if err != nil {
+			return total, err
+		} else if len(ids) == 0 {
+			return total, nil
+		}
+
+		// the rows go last, content is never left without a row pointing to it
+		for _, id := range ids {
+			if err := f.blobs.Delete(ctx, id); err != nil {
+				return total, fmt.Errorf("failed to delete content of file %v: %w", id, err)
+			}
+		}
+
+		deleted, err := f.repo.PurgeFiles(ctx, userId, ids)
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+	}
+}
+
+// PatchFile() fails with repository.ErrStaleVersion when the file is not at expectedVersion,
+// or was changed concurrently
+func (f *fileService) PatchFile(ctx context.Context, userId uint, id uint, name *string, fileType *string, fileExtension *string, size *uint64, expectedVersion *uint) (*entity.File, error) {
+	name, err := f.naming.normalizeOptional(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.repo.GetFile(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	} else if file == nil {
+		return nil, fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+	}
+
+	if expectedVersion != nil && *expectedVersion != file.Version {
+		return nil, repository.ErrStaleVersion
+	}
+
+	if name != nil {
+		if err := f.checkCaseCollision(ctx, userId, *name, file.FolderId, file.ID); err != nil {
+			return nil, err
+		}
 	}
 
 	updateField(&file.Name, name)
@@ -40,31 +236,235 @@ func (f *fileService) PatchFile(userId uint, id uint, name *string, fileType *st
 	updateField(&file.Extension, fileExtension)
 	updateSize(&file.Size, size)
 
-	if err = f.repo.UpdateFile(userId, file); err != nil {
+	if err = f.repo.UpdateFile(ctx, userId, file); err != nil {
 		return nil, err
 	}
 
 	return file, nil
 }
 
-func (f *fileService) GetChildren(userId uint, folderId uint) ([]*entity.File, error) {
-	return f.repo.GetFilesByFolderId(userId, folderId)
+// SetMetadata() replaces or merges the metadata document of the file, see MetadataPolicy.Apply()
+func (f *fileService) SetMetadata(ctx context.Context, userId uint, id uint, metadata map[string]interface{}, merge bool, expectedVersion *uint) (*entity.File, error) {
+	file, err := f.repo.GetFile(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	} else if file == nil {
+		return nil, fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+	}
+
+	if expectedVersion != nil && *expectedVersion != file.Version {
+		return nil, repository.ErrStaleVersion
+	}
+
+	if file.Metadata, err = f.metadata.Apply(file.Metadata, metadata, merge); err != nil {
+		return nil, err
+	}
+
+	if err = f.repo.UpdateFile(ctx, userId, file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
 }
 
-func (f *fileService) GetFile(userId uint, id uint) (*entity.File, error) {
-	return f.repo.GetFile(userId, id)
+func (f *fileService) FindByMetadata(ctx context.Context, userId uint, filters []repository.MetadataFilter, limit int) ([]*entity.File, error) {
+	limit, err := f.metadata.CheckQuery(filters, limit)
+	if err != nil {
+		return nil, err
+	}
+	return f.repo.FindByMetadata(ctx, userId, filters, limit)
+}
+
+func (f *fileService) GetChildren(ctx context.Context, userId uint, folderId uint) ([]*entity.File, error) {
+	return f.repo.GetFilesByFolderId(ctx, userId, folderId)
+}
+
+// GetChildrenPage() returns up to limit files of the folder following the file afterId, 0 starts with the first
+func (f *fileService) GetChildrenPage(ctx context.Context, userId uint, folderId uint, afterId uint, limit int) ([]*entity.File, error) {
+	return f.repo.GetFilesPageByFolderId(ctx, userId, folderId, afterId, limit)
+}
+
+func (f *fileService) GetFile(ctx context.Context, userId uint, id uint) (*entity.File, error) {
+	return f.repo.GetFile(ctx, userId, id)
+}
+
+// CreateFile() resolves a file of the same name in the folder by the strategy,
+// ConflictFail fails with repository.ErrConflict
+func (f *fileService) CreateFile(ctx context.Context, userId uint, name string, folderId uint, strategy ConflictStrategy) (*entity.File, error) {
+	name, err := f.naming.Normalize(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.place(ctx, userId, name, folderId, 0, strategy, func(ctx context.Context, name string) (*entity.File, error) {
+		return f.repo.CreateFile(ctx, userId, name, folderId)
+	})
+}
+
+// MoveFile() moves the file into the folder, resolving a file of the same name there by the strategy.
+// It fails with repository.ErrStaleVersion when the file is not at expectedVersion, or was changed concurrently.
+func (f *fileService) MoveFile(ctx context.Context, userId uint, id uint, folderId uint, strategy ConflictStrategy, expectedVersion *uint) (*entity.File, error) {
+	return f.move(ctx, userId, id, folderId, nil, strategy, expectedVersion)
+}
+
+// MoveAndRenameFile() moves the file into the folder under the name in one step, see MoveFile().
+// The strategy resolves a file of the new name, the old name does not matter in the folder.
+func (f *fileService) MoveAndRenameFile(ctx context.Context, userId uint, id uint, folderId uint, name string, strategy ConflictStrategy, expectedVersion *uint) (*entity.File, error) {
+	name, err := f.naming.Normalize(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.move(ctx, userId, id, folderId, &name, strategy, expectedVersion)
+}
+
+// move() moves the file into the folder, under the name unless it is nil
+func (f *fileService) move(ctx context.Context, userId uint, id uint, folderId uint, name *string, strategy ConflictStrategy, expectedVersion *uint) (*entity.File, error) {
+	file, err := f.repo.GetFile(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	} else if file == nil {
+		return nil, fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+	}
+
+	if expectedVersion != nil && *expectedVersion != file.Version {
+		return nil, repository.ErrStaleVersion
+	}
+
+	if name == nil {
+		name = &file.Name
+	}
+	if file.FolderId == folderId && file.Name == *name {
+		return file, nil
+	}
+
+	return f.place(ctx, userId, *name, folderId, file.ID, strategy, func(ctx context.Context, name string) (*entity.File, error) {
+		moved := *file
+		moved.Name = name
+		moved.FolderId = folderId
+		if err := f.repo.UpdateFile(ctx, userId, &moved); err != nil {
+			return nil, err
+		}
+		return &moved, nil
+	})
 }
 
-func (f *fileService) CreateFile(userId uint, name string, folderId uint) (*entity.File, error) {
-	// unique name in folder
-	file, err := f.repo.GetFileByNameAndFolderId(userId, name, folderId)
+// CopyFile() copies the file into the folder, resolving a file of the same name there by the strategy
+func (f *fileService) CopyFile(ctx context.Context, userId uint, id uint, folderId uint, strategy ConflictStrategy) (*entity.File, error) {
+	file, err := f.repo.GetFile(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	} else if file == nil {
+		return nil, fmt.Errorf("%w: file with id %v", ErrNotFound, id)
+	}
+
+	return f.place(ctx, userId, file.Name, folderId, 0, strategy, func(ctx context.Context, name string) (*entity.File, error) {
+		return f.repo.CopyFile(ctx, userId, file, name, folderId)
+	})
+}
+
+// place() writes a file named name into the folder, resolving a sibling of the same name by the strategy.
+// Every write runs in its own transaction, or savepoint, so a rejected name does not abort an outer transaction.
+func (f *fileService) place(ctx context.Context, userId uint, name string, folderId uint, id uint, strategy ConflictStrategy,
+	write func(ctx context.Context, name string) (*entity.File, error)) (*entity.File, error) {
+	attempt := func(name string) (*entity.File, error) {
+		var file *entity.File
+		err := f.tx.Transaction(ctx, func(ctx context.Context) error {
+			if err := f.checkCaseCollision(ctx, userId, name, folderId, id); err != nil {
+				return err
+			}
+
+			// the unique index rejects a file of the same name, also when written concurrently
+			var err error
+			file, err = write(ctx, name)
+			return err
+		})
+		return file, err
+	}
+
+	// a stale version is no name conflict, no strategy resolves it
+	file, err := attempt(name)
+	if !errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrStaleVersion) {
+		return file, err
+	}
+
+	switch strategy {
+	case ConflictRename, ConflictReplace, ConflictKeepBoth:
+	default:
+		return nil, err
+	}
+
+	siblings, err := f.repo.GetFilesByFolderId(ctx, userId, folderId)
 	if err != nil {
 		return nil, err
-	} else if file != nil {
-		return nil, fmt.Errorf("file with name %v already exists in folder %v", name, folderId)
 	}
 
-	return f.repo.CreateFile(userId, name, folderId)
+	var existing *entity.File
+	names := make([]string, len(siblings))
+	for i, sibling := range siblings {
+		names[i] = sibling.Name
+		if f.naming.SameName(sibling.Name, name) {
+			existing = sibling
+		}
+	}
+	taken := f.naming.takenBy(names)
+
+	if strategy == ConflictRename {
+		return createRenaming(name, taken, true, attempt)
+	} else if existing == nil {
+		// the existing file was removed meanwhile
+		return attempt(name)
+	}
+
+	// the existing file makes room in the same transaction, the name is never free for a concurrent write in between
+	err = f.tx.Transaction(ctx, func(ctx context.Context) error {
+		if strategy == ConflictReplace {
+			if _, err := f.repo.DeleteFile(ctx, userId, existing.ID); err != nil {
+				return err
+			}
+		} else if err := f.moveAside(ctx, userId, existing, taken); err != nil {
+			return err
+		}
+
+		var err error
+		if file, err = write(ctx, name); err != nil || strategy != ConflictReplace {
+			return err
+		}
+		// the replaced file stays soft-deleted with its content, as a prior version of the new one
+		return f.repo.MarkReplaced(ctx, userId, existing.ID, file.ID)
+	})
+	return file, err
+}
+
+// moveAside() renames the existing file to the first free numbered name, keeping both files for ConflictKeepBoth
+func (f *fileService) moveAside(ctx context.Context, userId uint, existing *entity.File, taken func(name string) bool) error {
+	_, err := createRenaming(existing.Name, taken, true, func(name string) (*entity.File, error) {
+		renamed := *existing
+		renamed.Name = name
+		err := f.tx.Transaction(ctx, func(ctx context.Context) error {
+			if err := f.checkCaseCollision(ctx, userId, name, existing.FolderId, existing.ID); err != nil {
+				return err
+			}
+			return f.repo.UpdateFile(ctx, userId, &renamed)
+		})
+		return &renamed, err
+	})
+	return err
+}
+
+// checkCaseCollision() rejects a name differing from a sibling only in case when the naming policy asks for it,
+// with a clearer error than the unique index on the name key.
+func (f *fileService) checkCaseCollision(ctx context.Context, userId uint, name string, folderId uint, id uint) error {
+	if !f.naming.CaseInsensitive() {
+		return nil
+	}
+
+	exists, err := f.repo.ExistsFileNameFold(ctx, userId, name, folderId, id)
+	if err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%w: a file with this name in different case already exists in the folder", repository.ErrConflict)
+	}
+	return nil
 }
 
 func updateField(field *string, value *string) {
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"io"
+	"sort"
+	"testing"
+)
+
+// fakeFileRepository keeps the files of one user in memory, deleted ones stay as soft-deleted
+type fakeFileRepository struct {
+	repository.FileRepository
+	files   map[uint]*entity.File
+	deleted map[uint]bool
+	// deletions lists the deleted ids in the order they were deleted
+	deletions []uint
+}
+
+func newFakeFileRepository(ids ...uint) *fakeFileRepository {
+	repo := &fakeFileRepository{files: make(map[uint]*entity.File), deleted: make(map[uint]bool)}
+	for _, id := range ids {
+		file := &entity.File{Name: "file"}
+		file.ID = id
+		repo.files[id] = file
+	}
+	return repo
+}
+
+// CreateFile() rejects a live file of the same name in the folder as the unique index does
+func (r *fakeFileRepository) CreateFile(ctx context.Context, userId uint, name string, folderId uint) (*entity.File, error) {
+	for id, file := range r.files {
+		if !r.deleted[id] && file.FolderId == folderId && file.Name == name {
+			return nil, repository.ErrConflict
+		}
+	}
+	file := &entity.File{Name: name, FolderId: folderId}
+	file.ID = uint(len(r.files) + 1)
+	r.files[file.ID] = file
+	return file, nil
+}
+
+func (r *fakeFileRepository) CopyFile(ctx context.Context, userId uint, source *entity.File, name string, folderId uint) (*entity.File, error) {
+	return r.CreateFile(ctx, userId, name, folderId)
+}
+
+func (r *fakeFileRepository) GetFilesByFolderId(ctx context.Context, userId uint, folderId uint) ([]*entity.File, error) {
+	var files []*entity.File
+	for id, file := range r.files {
+		if !r.deleted[id] && file.FolderId == folderId {
+			copied := *file
+			files = append(files, &copied)
+		}
+	}
+	return files, nil
+}
+
+func (r *fakeFileRepository) UpdateFile(ctx context.Context, userId uint, file *entity.File) error {
+	if stored := r.files[file.ID]; stored == nil || r.deleted[file.ID] || stored.Version != file.Version {
+		return repository.ErrStaleVersion
+	}
+	for id, other := range r.files {
+		if !r.deleted[id] && id != file.ID && other.FolderId == file.FolderId && other.Name == file.Name {
+			return repository.ErrConflict
+		}
+	}
+	file.Version++
+	updated := *file
+	r.files[file.ID] = &updated
+	return nil
+}
+
+func (r *fakeFileRepository) DeleteFile(ctx context.Context, userId uint, id uint) (bool, error) {
+	if r.files[id] == nil || r.deleted[id] {
+		return false, nil
+	}
+	r.deleted[id] = true
+	r.deletions = append(r.deletions, id)
+	return true, nil
+}
+
+func (r *fakeFileRepository) MarkReplaced(ctx context.Context, userId uint, id uint, replacedById uint) error {
+	r.files[id].ReplacedById = &replacedById
+	return nil
+}
+
+func (r *fakeFileRepository) GetFileVersions(ctx context.Context, userId uint, id uint) ([]*entity.File, error) {
+	var versions []*entity.File
+	for i := len(r.deletions) - 1; i >= 0; i-- {
+		file := r.files[r.deletions[i]]
+		if r.deleted[file.ID] && file.ReplacedById != nil && r.isVersionOf(file, id) {
+			copied := *file
+			versions = append(versions, &copied)
+		}
+	}
+	return versions, nil
+}
+
+func (r *fakeFileRepository) isVersionOf(file *entity.File, id uint) bool {
+	for file.ReplacedById != nil {
+		if *file.ReplacedById == id {
+			return true
+		}
+		file = r.files[*file.ReplacedById]
+	}
+	return false
+}
+
+func (r *fakeFileRepository) RestoreFile(ctx context.Context, userId uint, id uint) error {
+	if !r.deleted[id] {
+		return repository.ErrStaleVersion
+	}
+	r.deleted[id] = false
+	r.files[id].ReplacedById = nil
+	return nil
+}
+
+// GetFile() returns no file and no error for a missing id, like the repository
+func (r *fakeFileRepository) GetFile(ctx context.Context, userId uint, id uint) (*entity.File, error) {
+	if r.files[id] == nil || r.deleted[id] {
+		return nil, nil
+	}
+	return r.files[id], nil
+}
+
+func (r *fakeFileRepository) GetPurgeableFileIds(ctx context.Context, userId uint, limit int) ([]uint, error) {
+	var ids []uint
+	for id := range r.files {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (r *fakeFileRepository) PurgeFiles(ctx context.Context, userId uint, ids []uint) (int64, error) {
+	for _, id := range ids {
+		delete(r.files, id)
+	}
+	return int64(len(ids)), nil
+}
+
+// fakeTransactor runs fn without a transaction
+type fakeTransactor struct{}
+
+func (fakeTransactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// fakeBlobStore keeps contents in memory and fails deleting the ids in failing
+type fakeBlobStore struct {
+	contents map[uint][]byte
+	failing  map[uint]bool
+}
+
+func newFakeBlobStore(ids ...uint) *fakeBlobStore {
+	blobs := &fakeBlobStore{contents: make(map[uint][]byte), failing: make(map[uint]bool)}
+	for _, id := range ids {
+		blobs.contents[id] = []byte("content")
+	}
+	return blobs
+}
+
+func (b *fakeBlobStore) Open(ctx context.Context, id uint) (io.ReadCloser, int64, error) {
+	content, ok := b.contents[id]
+	if !ok {
+		return nil, 0, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+}
+
+func (b *fakeBlobStore) Put(ctx context.Context, id uint, content io.Reader) (int64, error) {
+	data, err := io.ReadAll(content)
+	b.contents[id] = data
+	return int64(len(data)), err
+}
+
+func (b *fakeBlobStore) Delete(ctx context.Context, id uint) error {
+	if b.failing[id] {
+		return errors.New("disk is gone")
+	}
+	delete(b.contents, id)
+	return nil
+}
+
+func TestDeleteFileDeletesItsContent(t *testing.T) {
+	repo := newFakeFileRepository(1, 2)
+	blobs := newFakeBlobStore(1, 2)
+	files := NewFileService(repo, nil, nil, blobs, nil)
+
+	if deleted, err := files.DeleteFile(context.Background(), 7, 1); err != nil || !deleted {
+		t.Fatalf("expected the file to be deleted, got %v, %v", deleted, err)
+	}
+	if _, ok := blobs.contents[1]; ok {
+		t.Fatal("the content of the deleted file was kept")
+	}
+	if _, ok := blobs.contents[2]; !ok {
+		t.Fatal("the content of another file was deleted")
+	}
+
+	// deleting again finds nothing and keeps the content of others
+	if deleted, err := files.DeleteFile(context.Background(), 7, 1); err != nil || deleted {
+		t.Fatalf("expected nothing to be deleted, got %v, %v", deleted, err)
+	}
+}
+
+func TestDeleteAllFilesDeletesContentBeforeTheRows(t *testing.T) {
+	var ids []uint
+	for id := uint(1); id <= deleteBatchSize+10; id++ {
+		ids = append(ids, id)
+	}
+	repo := newFakeFileRepository(ids...)
+	repo.deleted[3] = true
+	blobs := newFakeBlobStore(ids...)
+	files := NewFileService(repo, nil, nil, blobs, nil)
+
+	// a failing content keeps its row, and every row after it, for the next attempt
+	blobs.failing[deleteBatchSize+5] = true
+	total, err := files.DeleteAllFiles(context.Background(), 7)
+	if err == nil {
+		t.Fatal("expected the failure to be returned")
+	}
+	if total != deleteBatchSize || len(repo.files) != 10 {
+		t.Fatalf("expected the first batch to be purged, got %d with %d rows left", total, len(repo.files))
+	}
+	if _, ok := repo.files[deleteBatchSize+5]; !ok {
+		t.Fatal("the row of content that failed to delete was purged")
+	}
+
+	delete(blobs.failing, deleteBatchSize+5)
+	total, err = files.DeleteAllFiles(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 10 || len(repo.files) != 0 || len(blobs.contents) != 0 {
+		t.Fatalf("expected everything to be removed, got %d with %d rows and %d contents left", total, len(repo.files), len(blobs.contents))
+	}
+}
+
+func TestMoveFileChecksTheExpectedVersion(t *testing.T) {
+	repo := newFakeFileRepository(1)
+	repo.files[1].Version = 3
+	files := NewFileService(repo, nil, nil, newFakeBlobStore(), nil)
+
+	stale := uint(2)
+	if _, err := files.MoveFile(context.Background(), 7, 1, 9, ConflictFail, &stale); !errors.Is(err, repository.ErrStaleVersion) {
+		t.Fatalf("expected ErrStaleVersion, got %v", err)
+	}
+	if repo.files[1].FolderId != 0 {
+		t.Fatal("a stale move was applied")
+	}
+}
+
+func TestCreateFileKeepBothMovesTheExistingFileAside(t *testing.T) {
+	repo := newFakeFileRepository(1)
+	repo.files[1].Name = "a.txt"
+	files := NewFileService(repo, NewNamingPolicy(config.Naming{}), nil, newFakeBlobStore(1), fakeTransactor{})
+
+	created, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictKeepBoth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 1 || created.Name != "a.txt" {
+		t.Fatalf("expected a new file under the name, got %+v", created)
+	}
+	if existing := repo.files[1]; existing.Name != "a (1).txt" || repo.deleted[1] {
+		t.Fatalf("expected the existing file to be kept as a (1).txt, got %+v", existing)
+	}
+}
+
+func TestCreateFileReplaceKeepsTheExistingFileAsAVersion(t *testing.T) {
+	repo := newFakeFileRepository(1)
+	repo.files[1].Name = "a.txt"
+	blobs := newFakeBlobStore(1)
+	files := NewFileService(repo, NewNamingPolicy(config.Naming{}), nil, blobs, fakeTransactor{})
+
+	created, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 1 || created.Name != "a.txt" || !repo.deleted[1] {
+		t.Fatalf("expected the existing file to be replaced, got %+v", created)
+	}
+	if string(blobs.contents[1]) != "content" {
+		t.Fatal("the content of the replaced file is gone")
+	}
+
+	versions, err := files.GetVersions(context.Background(), 7, created.ID)
+	if err != nil || len(versions) != 1 || versions[0].ID != 1 {
+		t.Fatalf("expected the replaced file as the only version, got %v: %v", versions, err)
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	repo := newFakeFileRepository(1)
+	repo.files[1].Name = "a.txt"
+	blobs := newFakeBlobStore(1)
+	files := NewFileService(repo, NewNamingPolicy(config.Naming{}), nil, blobs, fakeTransactor{})
+
+	second, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := files.GetVersions(context.Background(), 7, third.ID); len(versions) != 2 || versions[0].ID != second.ID || versions[1].ID != 1 {
+		t.Fatalf("expected both prior files, the latest first, got %v", versions)
+	}
+
+	stale := third.Version + 1
+	if _, err := files.RestoreVersion(context.Background(), 7, third.ID, 1, &stale); !errors.Is(err, repository.ErrStaleVersion) {
+		t.Fatalf("expected ErrStaleVersion, got %v", err)
+	}
+	if _, err := files.RestoreVersion(context.Background(), 7, third.ID, 9, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a file that is no version, got %v", err)
+	}
+
+	restored, err := files.RestoreVersion(context.Background(), 7, third.ID, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != 1 || repo.deleted[1] || !repo.deleted[third.ID] || restored.Name != "a.txt" {
+		t.Fatalf("expected the first file to be current again, got %+v", restored)
+	}
+	// nothing is lost, the replaced files are versions of the restored one
+	if versions, _ := files.GetVersions(context.Background(), 7, 1); len(versions) != 2 {
+		t.Fatalf("expected the other files as versions, got %v", versions)
+	}
+}
+
+func TestDeleteFileDeletesTheContentOfItsVersions(t *testing.T) {
+	repo := newFakeFileRepository(1)
+	repo.files[1].Name = "a.txt"
+	blobs := newFakeBlobStore(1)
+	files := NewFileService(repo, NewNamingPolicy(config.Naming{}), nil, blobs, fakeTransactor{})
+
+	created, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs.contents[created.ID] = []byte("new")
+
+	if _, err := files.DeleteFile(context.Background(), 7, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs.contents) != 0 {
+		t.Fatalf("expected the contents of the file and its version to be deleted, got %v", blobs.contents)
+	}
+}
+
+func TestSetMetadataOfAMissingFile(t *testing.T) {
+	files := NewFileService(newFakeFileRepository(1), nil, NewMetadataPolicy(config.Metadata{}), nil, nil)
+
+	_, err := files.SetMetadata(context.Background(), 7, 2, map[string]interface{}{"page": 1}, false, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDiscardFileBringsBackTheReplacedFile(t *testing.T) {
+	repo := newFakeFileRepository(1)
+	repo.files[1].Name = "a.txt"
+	blobs := newFakeBlobStore(1)
+	files := NewFileService(repo, NewNamingPolicy(config.Naming{}), nil, blobs, fakeTransactor{})
+
+	second, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := files.CreateFile(context.Background(), 7, "a.txt", 0, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs.contents[third.ID] = []byte("partial")
+
+	if err := files.DiscardFile(context.Background(), 7, third.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.deleted[third.ID] || repo.deleted[second.ID] || repo.files[second.ID].ReplacedById != nil {
+		t.Fatal("expected the replaced file to be current again")
+	}
+	if _, ok := blobs.contents[third.ID]; ok {
+		t.Fatal("the content of the discarded file was kept")
+	}
+	if versions, _ := files.GetVersions(context.Background(), 7, second.ID); len(versions) != 1 || versions[0].ID != 1 {
+		t.Fatalf("expected the first file to stay a version, got %v", versions)
+	}
+
+	if err := files.DiscardFile(context.Background(), 7, third.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMoveAndRenameFileOnlyConflictsWithTheNewName(t *testing.T) {
+	repo := newFakeFileRepository(1, 2)
+	repo.files[1].Name, repo.files[1].FolderId = "x", 1
+	repo.files[2].Name, repo.files[2].FolderId = "x", 2
+	files := NewFileService(repo, NewNamingPolicy(config.Naming{}), nil, nil, fakeTransactor{})
+
+	moved, err := files.MoveAndRenameFile(context.Background(), 7, 1, 2, "y", ConflictFail, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Name != "y" || moved.FolderId != 2 || repo.files[1].Name != "y" || repo.files[1].FolderId != 2 {
+		t.Fatalf("expected the file to be moved as y, got %+v", moved)
+	}
+
+	if _, err := files.MoveAndRenameFile(context.Background(), 7, 1, 2, "x", ConflictFail, nil); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("expected ErrConflict for the name of a sibling, got %v", err)
+	}
+}
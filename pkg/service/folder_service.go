@@ -1,109 +1,393 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/potatowhite/books/file-service/logging"
 	"github.com/potatowhite/books/file-service/pkg/repository"
 	"github.com/potatowhite/books/file-service/pkg/repository/entity"
-	"gorm.io/gorm"
-	"log"
-	"os"
+	"github.com/potatowhite/books/file-service/pkg/storage"
 )
 
 var (
-	// log with method name and line number
-	logger = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
+	logger = logging.For("service")
 )
 
+// number of rows removed per statement when erasing a users data
+const deleteBatchSize = 500
+
 type FolderService interface {
-	CreateFolder(userId uint, name string, parentId uint) (*entity.Folder, error)
-	RenameFolder(userId uint, id uint, newName string) (*entity.Folder, error)
-	DeleteFolder(userId uint, id uint) (bool, error)
-	GetFolder(userId uint, id uint) (*entity.Folder, error)
-	GetChildren(userId uint, parentID uint) ([]*entity.Folder, error)
-	CreateRootFolder(userId uint) (*entity.Folder, error)
-	GetRootFolder(userId uint) (*entity.Folder, error)
-	GetPathOrNil(userId uint, id uint) (*string, error)
-	DeleteAllFolders(userId uint) (int64, error)
+	CreateFolder(ctx context.Context, userId uint, name string, parentId uint, strategy ConflictStrategy) (*entity.Folder, error)
+	EnsureFolder(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error)
+	MoveFolder(ctx context.Context, userId uint, id uint, parentId uint, strategy ConflictStrategy, expectedVersion *uint) (*entity.Folder, error)
+	MoveAndRenameFolder(ctx context.Context, userId uint, id uint, parentId uint, name string, strategy ConflictStrategy, expectedVersion *uint) (*entity.Folder, error)
+	CheckPlacement(ctx context.Context, userId uint, id uint, parentId uint) error
+	RenameFolder(ctx context.Context, userId uint, id uint, newName string, expectedVersion *uint) (*entity.Folder, error)
+	DeleteFolder(ctx context.Context, userId uint, id uint) (bool, error)
+	GetFolder(ctx context.Context, userId uint, id uint) (*entity.Folder, error)
+	GetChildren(ctx context.Context, userId uint, parentID uint) ([]*entity.Folder, error)
+	GetChildrenPage(ctx context.Context, userId uint, parentID uint, afterId uint, limit int) ([]*entity.Folder, error)
+	CreateRootFolder(ctx context.Context, userId uint) (*entity.Folder, error)
+	GetRootFolder(ctx context.Context, userId uint) (*entity.Folder, error)
+	GetPathOrNil(ctx context.Context, userId uint, id uint) (*string, error)
+	DeleteAllFolders(ctx context.Context, userId uint) (int64, error)
+	CountFolders(ctx context.Context, userId uint) (int64, error)
+
+	SetMetadata(ctx context.Context, userId uint, id uint, metadata map[string]interface{}, merge bool, expectedVersion *uint) (*entity.Folder, error)
+	FindByMetadata(ctx context.Context, userId uint, filters []repository.MetadataFilter, limit int) ([]*entity.Folder, error)
 }
 
 type folderService struct {
-	repo repository.FolderRepository
+	repo     repository.FolderRepository
+	naming   *NamingPolicy
+	metadata *MetadataPolicy
+	blobs    storage.BlobStore
+	tx       repository.Transactor
 }
 
-func (f *folderService) DeleteAllFolders(userId uint) (int64, error) {
-	return f.repo.DeleteAllFolders(userId)
+// DeleteAllFolders permanently removes every folder of the user in batches, leaves first.
+// It is safe to call again after an interruption, it continues with whatever is left.
+func (f *folderService) DeleteAllFolders(ctx context.Context, userId uint) (int64, error) {
+	var total int64
+	for {
+		// stop between batches when the caller gave up, the next call continues
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		deleted, err := f.repo.PurgeLeafFolders(ctx, userId, deleteBatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		total += deleted
+		if deleted == 0 {
+			return total, nil
+		}
+	}
 }
 
-func (f *folderService) GetPathOrNil(userId uint, id uint) (*string, error) {
-	return f.repo.GetPathOrNil(userId, id)
+func (f *folderService) GetPathOrNil(ctx context.Context, userId uint, id uint) (*string, error) {
+	return f.repo.GetPathOrNil(ctx, userId, id)
 }
 
-func (f *folderService) GetRootFolder(userId uint) (*entity.Folder, error) {
-	return f.repo.GetRootFolder(userId)
+func (f *folderService) GetRootFolder(ctx context.Context, userId uint) (*entity.Folder, error) {
+	return f.repo.GetRootFolder(ctx, userId)
 }
 
-func (f *folderService) RenameFolder(userId uint, id uint, newName string) (*entity.Folder, error) {
-	folder, err := f.repo.GetFolder(userId, id)
+// RenameFolder() fails with repository.ErrStaleVersion when the folder is not at expectedVersion,
+// or was changed concurrently
+func (f *folderService) RenameFolder(ctx context.Context, userId uint, id uint, newName string, expectedVersion *uint) (*entity.Folder, error) {
+	newName, err := f.naming.Normalize(newName)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := f.repo.GetFolder(ctx, userId, id)
 	if err != nil {
 		return nil, err
 	}
 
+	if expectedVersion != nil && *expectedVersion != folder.Version {
+		return nil, repository.ErrStaleVersion
+	}
+
+	if folder.ParentId != nil {
+		if err := f.checkCaseCollision(ctx, userId, newName, *folder.ParentId, folder.ID); err != nil {
+			return nil, err
+		}
+	}
+
 	folder.Name = newName
-	err = f.repo.UpdateFolder(userId, folder)
+	err = f.repo.UpdateFolder(ctx, userId, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// CreateRootFolder() fails with repository.ErrConflict when the user already has a root folder
+func (f *folderService) CreateRootFolder(ctx context.Context, userId uint) (*entity.Folder, error) {
+	folder, err := f.repo.CreateRootFolder(ctx, userId)
+	if errors.Is(err, repository.ErrConflict) {
+		logger.WarnContext(ctx, "root folder already exists", "user_id", userId)
+	}
+	return folder, err
+}
+
+func (f *folderService) GetFolder(ctx context.Context, userId uint, id uint) (*entity.Folder, error) {
+	return f.repo.GetFolder(ctx, userId, id)
+}
+
+// SetMetadata() replaces or merges the metadata document of the folder, see MetadataPolicy.Apply()
+func (f *folderService) SetMetadata(ctx context.Context, userId uint, id uint, metadata map[string]interface{}, merge bool, expectedVersion *uint) (*entity.Folder, error) {
+	folder, err := f.repo.GetFolder(ctx, userId, id)
 	if err != nil {
 		return nil, err
 	}
 
+	if expectedVersion != nil && *expectedVersion != folder.Version {
+		return nil, repository.ErrStaleVersion
+	}
+
+	if folder.Metadata, err = f.metadata.Apply(folder.Metadata, metadata, merge); err != nil {
+		return nil, err
+	}
+
+	if err = f.repo.UpdateFolder(ctx, userId, folder); err != nil {
+		return nil, err
+	}
+
 	return folder, nil
 }
 
-func (f *folderService) CreateRootFolder(userId uint) (*entity.Folder, error) {
-	// must not exist a root folder for the users
-	folder, err := f.repo.GetRootFolder(userId)
-	if err != nil && err != gorm.ErrRecordNotFound {
+func (f *folderService) FindByMetadata(ctx context.Context, userId uint, filters []repository.MetadataFilter, limit int) ([]*entity.Folder, error) {
+	limit, err := f.metadata.CheckQuery(filters, limit)
+	if err != nil {
+		return nil, err
+	}
+	return f.repo.FindByMetadata(ctx, userId, filters, limit)
+}
+
+func (f *folderService) CountFolders(ctx context.Context, userId uint) (int64, error) {
+	return f.repo.CountFolders(ctx, userId)
+}
+
+func (f *folderService) GetChildren(ctx context.Context, userId uint, parentID uint) ([]*entity.Folder, error) {
+	return f.repo.GetChildren(ctx, userId, parentID)
+}
+
+// GetChildrenPage() returns up to limit child folders following the folder afterId, 0 starts with the first
+func (f *folderService) GetChildrenPage(ctx context.Context, userId uint, parentID uint, afterId uint, limit int) ([]*entity.Folder, error) {
+	return f.repo.GetChildrenPage(ctx, userId, parentID, afterId, limit)
+}
+
+// CreateFolder() resolves a folder of the same name in the parent by the strategy,
+// ConflictFail fails with repository.ErrConflict
+func (f *folderService) CreateFolder(ctx context.Context, userId uint, name string, parentId uint, strategy ConflictStrategy) (*entity.Folder, error) {
+	name, err := f.naming.Normalize(name)
+	if err != nil {
 		return nil, err
 	}
 
-	if folder != nil {
-		logger.Printf("Root folder already exists for users %v", userId)
-		return nil, fmt.Errorf("root folder already exists for users %v", userId)
+	// the parent must exist
+	parent, err := f.repo.GetFolder(ctx, userId, parentId)
+	if err != nil {
+		return nil, err
 	}
 
-	return f.repo.CreateRootFolder(userId)
+	return f.place(ctx, userId, name, parent.ID, 0, strategy, func(ctx context.Context, name string) (*entity.Folder, error) {
+		return f.repo.CreateFolder(ctx, userId, name, parent.ID)
+	})
+}
+
+// EnsureFolder() returns the folder of the name in the parent, creating it when there is none
+func (f *folderService) EnsureFolder(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error) {
+	return f.CreateFolder(ctx, userId, name, parentId, conflictReuse)
+}
+
+// MoveFolder() moves the folder into the parent, resolving a folder of the same name there by the strategy.
+// It fails with repository.ErrStaleVersion when the folder is not at expectedVersion, or was changed concurrently.
+func (f *folderService) MoveFolder(ctx context.Context, userId uint, id uint, parentId uint, strategy ConflictStrategy, expectedVersion *uint) (*entity.Folder, error) {
+	return f.move(ctx, userId, id, parentId, nil, strategy, expectedVersion)
 }
 
-func (f *folderService) GetFolder(userId uint, id uint) (*entity.Folder, error) {
-	return f.repo.GetFolder(userId, id)
+// MoveAndRenameFolder() moves the folder into the parent under the name in one step, see MoveFolder().
+// The strategy resolves a folder of the new name, the old name does not matter in the parent.
+func (f *folderService) MoveAndRenameFolder(ctx context.Context, userId uint, id uint, parentId uint, name string, strategy ConflictStrategy, expectedVersion *uint) (*entity.Folder, error) {
+	name, err := f.naming.Normalize(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.move(ctx, userId, id, parentId, &name, strategy, expectedVersion)
 }
 
-func (f *folderService) GetChildren(userId uint, parentID uint) ([]*entity.Folder, error) {
-	return f.repo.GetChildren(userId, parentID)
+// move() moves the folder into the parent, under the name unless it is nil
+func (f *folderService) move(ctx context.Context, userId uint, id uint, parentId uint, name *string, strategy ConflictStrategy, expectedVersion *uint) (*entity.Folder, error) {
+	folder, err := f.repo.GetFolder(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedVersion != nil && *expectedVersion != folder.Version {
+		return nil, repository.ErrStaleVersion
+	}
+
+	if name == nil {
+		name = &folder.Name
+	}
+	if folder.ParentId == nil {
+		return nil, fmt.Errorf("%w: the root folder cannot be moved", ErrInvalidMove)
+	} else if *folder.ParentId == parentId && folder.Name == *name {
+		return folder, nil
+	}
+
+	if err := f.CheckPlacement(ctx, userId, id, parentId); err != nil {
+		return nil, err
+	}
+
+	return f.place(ctx, userId, *name, parentId, folder.ID, strategy, func(ctx context.Context, name string) (*entity.Folder, error) {
+		moved := *folder
+		moved.Name = name
+		moved.ParentId = &parentId
+		if err := f.repo.UpdateFolder(ctx, userId, &moved); err != nil {
+			return nil, err
+		}
+		return &moved, nil
+	})
 }
 
-func (f *folderService) CreateFolder(userId uint, name string, parentId uint) (*entity.Folder, error) {
-	// must not exist a folder with the same name for the users
-	folder, err := f.repo.GetFolder(userId, parentId)
+// CheckPlacement() fails with ErrInvalidMove when the folder would end up inside itself
+func (f *folderService) CheckPlacement(ctx context.Context, userId uint, id uint, parentId uint) error {
+	if _, err := f.repo.GetFolder(ctx, userId, parentId); err != nil {
+		return err
+	}
+
+	inside, err := f.repo.IsDescendant(ctx, userId, id, parentId)
 	if err != nil {
+		return err
+	} else if inside || id == parentId {
+		return fmt.Errorf("%w: folder %v cannot be placed inside itself", ErrInvalidMove, id)
+	}
+	return nil
+}
+
+// place() writes a folder named name into the parent, resolving a sibling of the same name by the strategy.
+// Every write runs in its own transaction, or savepoint, so a rejected name does not abort an outer transaction.
+func (f *folderService) place(ctx context.Context, userId uint, name string, parentId uint, id uint, strategy ConflictStrategy,
+	write func(ctx context.Context, name string) (*entity.Folder, error)) (*entity.Folder, error) {
+	attempt := func(name string) (*entity.Folder, error) {
+		var folder *entity.Folder
+		err := f.tx.Transaction(ctx, func(ctx context.Context) error {
+			if err := f.checkCaseCollision(ctx, userId, name, parentId, id); err != nil {
+				return err
+			}
+
+			// the unique index rejects a sibling of the same name, also when written concurrently
+			var err error
+			folder, err = write(ctx, name)
+			return err
+		})
+		return folder, err
+	}
+
+	// a stale version is no name conflict, no strategy resolves it
+	folder, err := attempt(name)
+	if !errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrStaleVersion) {
+		return folder, err
+	}
+
+	switch strategy {
+	case ConflictRename, ConflictReplace, ConflictKeepBoth, conflictReuse:
+	default:
 		return nil, err
 	}
 
-	// check if the folder already exists
-	_, err = f.repo.GetFolderByNameAndParentId(userId, name, folder.ID)
-	if err != nil && err != gorm.ErrRecordNotFound {
+	siblings, err := f.repo.GetChildren(ctx, userId, parentId)
+	if err != nil {
 		return nil, err
-	} else if err == gorm.ErrRecordNotFound {
-		return f.repo.CreateFolder(userId, name, parentId)
 	}
 
-	return nil, fmt.Errorf("folder with name %v already exists", name)
+	var existing *entity.Folder
+	names := make([]string, len(siblings))
+	for i, sibling := range siblings {
+		names[i] = sibling.Name
+		if f.naming.SameName(sibling.Name, name) {
+			existing = sibling
+		}
+	}
+	taken := f.naming.takenBy(names)
+
+	if strategy == ConflictRename {
+		return createRenaming(name, taken, false, attempt)
+	} else if existing == nil {
+		// the existing folder was removed meanwhile
+		return attempt(name)
+	} else if strategy == conflictReuse {
+		return existing, nil
+	}
+
+	// the existing folder makes room in the same transaction, the name is never free for a concurrent write in between
+	err = f.tx.Transaction(ctx, func(ctx context.Context) error {
+		if strategy == ConflictReplace {
+			if err := f.replace(ctx, userId, existing, id); err != nil {
+				return err
+			}
+		} else if err := f.moveAside(ctx, userId, existing, taken); err != nil {
+			return err
+		}
+
+		var err error
+		folder, err = write(ctx, name)
+		return err
+	})
+	return folder, err
+}
+
+// replace() deletes the existing folder with everything below it, for the folder id taking its place
+func (f *folderService) replace(ctx context.Context, userId uint, existing *entity.Folder, id uint) error {
+	// a folder moved out of the folder it replaces would be deleted with it
+	if id != 0 {
+		inside, err := f.repo.IsDescendant(ctx, userId, existing.ID, id)
+		if err != nil {
+			return err
+		} else if inside {
+			return fmt.Errorf("%w: folder %v cannot replace the folder %v it is in", ErrInvalidMove, id, existing.ID)
+		}
+	}
+
+	_, fileIds, err := f.repo.DeleteFolderTree(ctx, userId, existing.ID)
+	if err != nil {
+		return err
+	}
+	deleteContents(ctx, f.blobs, fileIds)
+	return nil
+}
+
+// moveAside() renames the existing folder to the first free numbered name, keeping both folders for ConflictKeepBoth
+func (f *folderService) moveAside(ctx context.Context, userId uint, existing *entity.Folder, taken func(name string) bool) error {
+	_, err := createRenaming(existing.Name, taken, false, func(name string) (*entity.Folder, error) {
+		renamed := *existing
+		renamed.Name = name
+		err := f.tx.Transaction(ctx, func(ctx context.Context) error {
+			if err := f.checkCaseCollision(ctx, userId, name, *existing.ParentId, existing.ID); err != nil {
+				return err
+			}
+			return f.repo.UpdateFolder(ctx, userId, &renamed)
+		})
+		return &renamed, err
+	})
+	return err
+}
+
+// checkCaseCollision() rejects a name differing from a sibling only in case when the naming policy asks for it,
+// with a clearer error than the unique index on the name key.
+func (f *folderService) checkCaseCollision(ctx context.Context, userId uint, name string, parentId uint, id uint) error {
+	if !f.naming.CaseInsensitive() {
+		return nil
+	}
+
+	exists, err := f.repo.ExistsFolderNameFold(ctx, userId, name, parentId, id)
+	if err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%w: a folder with this name in different case already exists in the parent folder", repository.ErrConflict)
+	}
+	return nil
 }
 
-func (f *folderService) DeleteFolder(userId uint, id uint) (bool, error) {
-	return f.repo.DeleteFolder(userId, id)
+func (f *folderService) DeleteFolder(ctx context.Context, userId uint, id uint) (bool, error) {
+	return f.repo.DeleteFolder(ctx, userId, id)
 }
 
-func NewFolderService(folderRepo repository.FolderRepository) FolderService {
+func NewFolderService(folderRepo repository.FolderRepository, naming *NamingPolicy, metadata *MetadataPolicy, blobs storage.BlobStore, tx repository.Transactor) FolderService {
 	return &folderService{
-		repo: folderRepo,
+		repo:     folderRepo,
+		naming:   naming,
+		metadata: metadata,
+		blobs:    blobs,
+		tx:       tx,
 	}
 }
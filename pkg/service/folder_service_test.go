@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// fakeFolderRepository keeps the folders of one user in memory, files holds the ids of the files in a folder
+type fakeFolderRepository struct {
+	repository.FolderRepository
+	folders map[uint]*entity.Folder
+	deleted map[uint]bool
+	files   map[uint][]uint
+}
+
+// newFakeFolderRepository() returns a repository with the root folder 1
+func newFakeFolderRepository() *fakeFolderRepository {
+	root := &entity.Folder{Name: "root"}
+	root.ID = 1
+	return &fakeFolderRepository{
+		folders: map[uint]*entity.Folder{1: root},
+		deleted: make(map[uint]bool),
+		files:   make(map[uint][]uint),
+	}
+}
+
+func (r *fakeFolderRepository) add(name string, parentId uint) *entity.Folder {
+	folder := &entity.Folder{Name: name, ParentId: &parentId}
+	folder.ID = uint(len(r.folders) + 1)
+	r.folders[folder.ID] = folder
+	return folder
+}
+
+func (r *fakeFolderRepository) taken(name string, parentId uint, excludeId uint) bool {
+	for id, folder := range r.folders {
+		if !r.deleted[id] && id != excludeId && folder.ParentId != nil && *folder.ParentId == parentId && folder.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeFolderRepository) CreateFolder(ctx context.Context, userId uint, name string, parentId uint) (*entity.Folder, error) {
+	if r.taken(name, parentId, 0) {
+		return nil, repository.ErrConflict
+	}
+	return r.add(name, parentId), nil
+}
+
+func (r *fakeFolderRepository) UpdateFolder(ctx context.Context, userId uint, folder *entity.Folder) error {
+	if r.taken(folder.Name, *folder.ParentId, folder.ID) {
+		return repository.ErrConflict
+	}
+	updated := *folder
+	r.folders[folder.ID] = &updated
+	return nil
+}
+
+func (r *fakeFolderRepository) GetFolder(ctx context.Context, userId uint, id uint) (*entity.Folder, error) {
+	folder, ok := r.folders[id]
+	if !ok || r.deleted[id] {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *folder
+	return &copied, nil
+}
+
+func (r *fakeFolderRepository) GetChildren(ctx context.Context, userId uint, id uint) ([]*entity.Folder, error) {
+	var children []*entity.Folder
+	for childId, folder := range r.folders {
+		if !r.deleted[childId] && folder.ParentId != nil && *folder.ParentId == id {
+			copied := *folder
+			children = append(children, &copied)
+		}
+	}
+	return children, nil
+}
+
+func (r *fakeFolderRepository) IsDescendant(ctx context.Context, userId uint, ancestorId uint, id uint) (bool, error) {
+	for folder := r.folders[id]; folder != nil && folder.ParentId != nil; folder = r.folders[*folder.ParentId] {
+		if *folder.ParentId == ancestorId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeFolderRepository) DeleteFolder(ctx context.Context, userId uint, id uint) (bool, error) {
+	if r.folders[id] == nil || r.deleted[id] {
+		return false, nil
+	}
+	r.deleted[id] = true
+	return true, nil
+}
+
+func (r *fakeFolderRepository) DeleteFolderTree(ctx context.Context, userId uint, id uint) (bool, []uint, error) {
+	var fileIds []uint
+	for folderId := range r.folders {
+		if inside, _ := r.IsDescendant(ctx, userId, id, folderId); (inside || folderId == id) && !r.deleted[folderId] {
+			r.deleted[folderId] = true
+			fileIds = append(fileIds, r.files[folderId]...)
+		}
+	}
+	return true, fileIds, nil
+}
+
+func TestCreateFolderReplaceDeletesTheExistingFolderWithItsContents(t *testing.T) {
+	repo := newFakeFolderRepository()
+	existing := repo.add("docs", 1)
+	nested := repo.add("nested", existing.ID)
+	repo.files[existing.ID] = []uint{1}
+	repo.files[nested.ID] = []uint{2}
+	blobs := newFakeBlobStore(1, 2, 3)
+	folders := NewFolderService(repo, NewNamingPolicy(config.Naming{}), nil, blobs, fakeTransactor{})
+
+	created, err := folders.CreateFolder(context.Background(), 7, "docs", 1, ConflictReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == existing.ID || !repo.deleted[existing.ID] || !repo.deleted[nested.ID] {
+		t.Fatalf("expected the existing folder to be deleted with its subfolders, got %+v", created)
+	}
+	if _, ok := blobs.contents[3]; len(blobs.contents) != 1 || !ok {
+		t.Fatalf("expected only the contents below the replaced folder to be deleted, got %v", blobs.contents)
+	}
+}
+
+func TestMoveFolderReplaceRejectsReplacingTheFolderItIsIn(t *testing.T) {
+	repo := newFakeFolderRepository()
+	outer := repo.add("docs", 1)
+	inner := repo.add("sub", outer.ID)
+	moved := repo.add("docs", inner.ID)
+	repo.files[outer.ID] = []uint{1}
+	blobs := newFakeBlobStore(1)
+	folders := NewFolderService(repo, NewNamingPolicy(config.Naming{}), nil, blobs, fakeTransactor{})
+
+	if _, err := folders.MoveFolder(context.Background(), 7, moved.ID, 1, ConflictReplace, nil); !errors.Is(err, ErrInvalidMove) {
+		t.Fatalf("expected ErrInvalidMove, got %v", err)
+	}
+	if repo.deleted[outer.ID] || len(blobs.contents) != 1 {
+		t.Fatal("the folder the moved folder is in was deleted")
+	}
+}
+
+func TestMoveAndRenameFolderOnlyConflictsWithTheNewName(t *testing.T) {
+	repo := newFakeFolderRepository()
+	source := repo.add("a", 1)
+	moved := repo.add("x", source.ID)
+	target := repo.add("b", 1)
+	repo.add("x", target.ID)
+	folders := NewFolderService(repo, NewNamingPolicy(config.Naming{}), nil, nil, fakeTransactor{})
+
+	renamed, err := folders.MoveAndRenameFolder(context.Background(), 7, moved.ID, target.ID, "y", ConflictFail, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "y" || *renamed.ParentId != target.ID || repo.folders[moved.ID].Name != "y" {
+		t.Fatalf("expected the folder to be moved as y, got %+v", renamed)
+	}
+
+	if _, err := folders.MoveAndRenameFolder(context.Background(), 7, moved.ID, target.ID, "x", ConflictFail, nil); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("expected ErrConflict for the name of a sibling, got %v", err)
+	}
+}
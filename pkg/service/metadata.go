@@ -0,0 +1,150 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"unicode/utf8"
+)
+
+// ErrInvalidMetadata is wrapped by errors of metadata documents and filters that are rejected
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+const (
+	// the longest metadata key in bytes
+	maxMetadataKeyLength = 256
+	// the deepest nesting of objects and arrays in a metadata document, the document itself is level 1
+	maxMetadataDepth = 16
+	// the largest number of filters of one metadata query
+	maxMetadataFilters = 20
+	// the largest number of items a metadata query returns
+	maxMetadataResults = 1000
+)
+
+// MetadataPolicy limits the metadata documents of folders and files
+type MetadataPolicy struct {
+	maxBytes int
+	maxKeys  int
+}
+
+func NewMetadataPolicy(cfg config.Metadata) *MetadataPolicy {
+	return &MetadataPolicy{maxBytes: cfg.MaxBytes, maxKeys: cfg.MaxKeys}
+}
+
+// Apply() returns the document replacing current. When merging, the top-level keys of update replace those of
+// current and keys set to null are removed, otherwise update replaces the whole document.
+func (p *MetadataPolicy) Apply(current entity.Metadata, update map[string]interface{}, merge bool) (entity.Metadata, error) {
+	applied := entity.Metadata{}
+	if merge {
+		for key, value := range current {
+			applied[key] = value
+		}
+	}
+	for key, value := range update {
+		if merge && value == nil {
+			delete(applied, key)
+			continue
+		}
+		applied[key] = value
+	}
+
+	if err := p.check(applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func (p *MetadataPolicy) check(metadata entity.Metadata) error {
+	if p.maxKeys > 0 && len(metadata) > p.maxKeys {
+		return fmt.Errorf("%w: at most %d keys are allowed, got %d", ErrInvalidMetadata, p.maxKeys, len(metadata))
+	}
+	for key := range metadata {
+		if err := checkMetadataKey(key); err != nil {
+			return err
+		}
+	}
+	if depth := metadataDepth(map[string]interface{}(metadata)); depth > maxMetadataDepth {
+		return fmt.Errorf("%w: the document must not be nested deeper than %d levels", ErrInvalidMetadata, maxMetadataDepth)
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if p.maxBytes > 0 && len(encoded) > p.maxBytes {
+		return fmt.Errorf("%w: the document must not be larger than %d bytes, got %d", ErrInvalidMetadata, p.maxBytes, len(encoded))
+	}
+	return nil
+}
+
+// CheckQuery() validates the filters and limit of a metadata query and returns the limit to apply,
+// a limit of 0 returns as many items as allowed
+func (p *MetadataPolicy) CheckQuery(filters []repository.MetadataFilter, limit int) (int, error) {
+	if len(filters) == 0 || len(filters) > maxMetadataFilters {
+		return 0, fmt.Errorf("%w: a query takes 1 to %d filters", ErrInvalidMetadata, maxMetadataFilters)
+	}
+	if limit == 0 {
+		limit = maxMetadataResults
+	} else if limit < 0 || limit > maxMetadataResults {
+		return 0, fmt.Errorf("%w: the limit must be between 1 and %d", ErrInvalidMetadata, maxMetadataResults)
+	}
+
+	for _, filter := range filters {
+		if err := checkMetadataKey(filter.Key); err != nil {
+			return 0, err
+		}
+
+		switch filter.Op {
+		case repository.MetadataExists:
+		case repository.MetadataEquals:
+			if _, err := json.Marshal(filter.Value); err != nil {
+				return 0, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+			}
+		case repository.MetadataGreater, repository.MetadataGreaterOrEqual, repository.MetadataLess, repository.MetadataLessOrEqual:
+			if !isRangeValue(filter.Value) {
+				return 0, fmt.Errorf("%w: %s compares numbers or strings, got %T", ErrInvalidMetadata, filter.Op, filter.Value)
+			}
+		default:
+			return 0, fmt.Errorf("%w: unknown operator %q", ErrInvalidMetadata, filter.Op)
+		}
+	}
+	return limit, nil
+}
+
+func checkMetadataKey(key string) error {
+	if key == "" || len(key) > maxMetadataKeyLength || !utf8.ValidString(key) {
+		return fmt.Errorf("%w: keys are valid UTF-8 of 1 to %d bytes, got %q", ErrInvalidMetadata, maxMetadataKeyLength, key)
+	}
+	return nil
+}
+
+// metadataDepth() returns how deeply objects and arrays are nested in value, 0 for scalars
+func metadataDepth(value interface{}) int {
+	deepest := 0
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			deepest = max(deepest, metadataDepth(child))
+		}
+	case []interface{}:
+		for _, child := range value {
+			deepest = max(deepest, metadataDepth(child))
+		}
+	default:
+		return 0
+	}
+	return deepest + 1
+}
+
+// isRangeValue() reports whether value encodes as a JSON number or string
+func isRangeValue(value interface{}) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil || len(encoded) == 0 {
+		return false
+	}
+	first := encoded[0]
+	return first == '"' || first == '-' || (first >= '0' && first <= '9')
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"strings"
+	"testing"
+)
+
+// nested() returns a document with objects nested depth levels deep
+func nested(depth int) map[string]interface{} {
+	document := map[string]interface{}{"leaf": 1}
+	for i := 1; i < depth; i++ {
+		document = map[string]interface{}{"child": document}
+	}
+	return document
+}
+
+func TestApplyMetadata(t *testing.T) {
+	policy := NewMetadataPolicy(config.Metadata{MaxBytes: 64, MaxKeys: 3})
+	current := entity.Metadata{"page": 12.0, "title": "Dune"}
+
+	merged, err := policy.Apply(current, map[string]interface{}{"page": 13.0, "title": nil, "done": false}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 || merged["page"] != 13.0 || merged["done"] != false {
+		t.Fatalf("expected the keys to be merged and null keys removed, got %v", merged)
+	}
+	if current["page"] != 12.0 || current["title"] != "Dune" {
+		t.Fatalf("the current document was changed: %v", current)
+	}
+
+	replaced, err := policy.Apply(current, map[string]interface{}{"done": true}, false)
+	if err != nil || len(replaced) != 1 || replaced["done"] != true {
+		t.Fatalf("expected the document to be replaced, got %v: %v", replaced, err)
+	}
+}
+
+func TestApplyMetadataLimits(t *testing.T) {
+	policy := NewMetadataPolicy(config.Metadata{MaxBytes: 64, MaxKeys: 3})
+	tests := []struct {
+		name   string
+		update map[string]interface{}
+		valid  bool
+	}{
+		{"within the limits", map[string]interface{}{"a": 1, "b": 2, "c": 3}, true},
+		{"too many keys", map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 4}, false},
+		{"too large", map[string]interface{}{"a": strings.Repeat("x", 64)}, false},
+		{"empty key", map[string]interface{}{"": 1}, false},
+		{"key too long", map[string]interface{}{strings.Repeat("k", maxMetadataKeyLength+1): 1}, false},
+		{"key not UTF-8", map[string]interface{}{"\xff": 1}, false},
+		{"not encodable", map[string]interface{}{"a": func() {}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := policy.Apply(nil, test.update, false)
+			if test.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidMetadata)) {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataDepth(t *testing.T) {
+	policy := NewMetadataPolicy(config.Metadata{})
+	if _, err := policy.Apply(nil, nested(maxMetadataDepth), false); err != nil {
+		t.Fatalf("expected %d levels to be accepted, got %v", maxMetadataDepth, err)
+	}
+	if _, err := policy.Apply(nil, nested(maxMetadataDepth+1), false); !errors.Is(err, ErrInvalidMetadata) {
+		t.Fatalf("expected %d levels to be rejected, got %v", maxMetadataDepth+1, err)
+	}
+
+	// arrays count as levels as well
+	deepArray := map[string]interface{}{"list": []interface{}{[]interface{}{nested(maxMetadataDepth - 2)}}}
+	if _, err := policy.Apply(nil, deepArray, false); !errors.Is(err, ErrInvalidMetadata) {
+		t.Fatalf("expected nested arrays to be rejected, got %v", err)
+	}
+}
+
+func TestCheckMetadataQuery(t *testing.T) {
+	policy := NewMetadataPolicy(config.Metadata{})
+	filter := func(op repository.MetadataOp, value interface{}) []repository.MetadataFilter {
+		return []repository.MetadataFilter{{Key: "page", Op: op, Value: value}}
+	}
+	tests := []struct {
+		name     string
+		filters  []repository.MetadataFilter
+		limit    int
+		expected int
+	}{
+		{"equals", filter(repository.MetadataEquals, map[string]interface{}{"a": 1}), 10, 10},
+		{"exists without a limit", filter(repository.MetadataExists, nil), 0, maxMetadataResults},
+		{"number range", filter(repository.MetadataGreater, 12), maxMetadataResults, maxMetadataResults},
+		{"string range", filter(repository.MetadataLessOrEqual, "m"), 1, 1},
+		{"no filters", nil, 10, -1},
+		{"too many filters", make([]repository.MetadataFilter, maxMetadataFilters+1), 10, -1},
+		{"negative limit", filter(repository.MetadataExists, nil), -1, -1},
+		{"limit too large", filter(repository.MetadataExists, nil), maxMetadataResults + 1, -1},
+		{"empty key", []repository.MetadataFilter{{Op: repository.MetadataExists}}, 10, -1},
+		{"range over a boolean", filter(repository.MetadataLess, true), 10, -1},
+		{"range over an object", filter(repository.MetadataGreaterOrEqual, map[string]interface{}{}), 10, -1},
+		{"unknown operator", filter("LIKE", "a"), 10, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limit, err := policy.CheckQuery(test.filters, test.limit)
+			if test.expected < 0 {
+				if !errors.Is(err, ErrInvalidMetadata) {
+					t.Fatalf("expected the query to be rejected, got %d, %v", limit, err)
+				}
+			} else if err != nil || limit != test.expected {
+				t.Fatalf("expected limit %d, got %d: %v", test.expected, limit, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/config"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidName is wrapped by every error of a name rejected by the naming policy
+var ErrInvalidName = errors.New("invalid name")
+
+// NamingPolicy normalizes and validates the names of folders and files
+type NamingPolicy struct {
+	maxLength       int
+	forbidden       string
+	reserved        map[string]bool
+	caseInsensitive bool
+}
+
+func NewNamingPolicy(cfg config.Naming) *NamingPolicy {
+	reserved := make(map[string]bool, len(cfg.ReservedNames))
+	for _, name := range cfg.ReservedNames {
+		reserved[strings.ToUpper(name)] = true
+	}
+
+	return &NamingPolicy{
+		maxLength:       cfg.MaxLength,
+		forbidden:       cfg.ForbiddenCharacters,
+		reserved:        reserved,
+		caseInsensitive: cfg.CaseInsensitive,
+	}
+}
+
+// CaseInsensitive() reports whether names differing only in case collide
+func (p *NamingPolicy) CaseInsensitive() bool {
+	return p.caseInsensitive
+}
+
+// Normalize() returns the NFC form of name, or an error wrapping ErrInvalidName when the policy rejects it
+func (p *NamingPolicy) Normalize(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", invalidName(name, "is not valid UTF-8")
+	}
+
+	name = norm.NFC.String(name)
+
+	switch {
+	case name == "":
+		return "", invalidName(name, "must not be empty")
+	case name == "." || name == "..":
+		return "", invalidName(name, "is reserved")
+	case strings.TrimSpace(name) != name:
+		return "", invalidName(name, "must not start or end with whitespace")
+	case p.maxLength > 0 && utf8.RuneCountInString(name) > p.maxLength:
+		return "", invalidName(name, fmt.Sprintf("must not be longer than %d characters", p.maxLength))
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", invalidName(name, "must not contain control characters")
+		}
+		if strings.ContainsRune(p.forbidden, r) {
+			return "", invalidName(name, fmt.Sprintf("must not contain %q", r))
+		}
+	}
+
+	// reserved names stay reserved with any extension, e.g. CON.txt
+	base, _, _ := strings.Cut(name, ".")
+	if p.reserved[strings.ToUpper(base)] {
+		return "", invalidName(name, "is reserved")
+	}
+
+	return name, nil
+}
+
+// SameName() reports whether two normalized names collide under the policy
+func (p *NamingPolicy) SameName(a string, b string) bool {
+	return p.Key(a) == p.Key(b)
+}
+
+// takenBy() returns whether a name collides with one of names under the policy
+func (p *NamingPolicy) takenBy(names []string) func(name string) bool {
+	taken := make(map[string]bool, len(names))
+	for _, name := range names {
+		taken[p.Key(name)] = true
+	}
+	return func(name string) bool {
+		return taken[p.Key(name)]
+	}
+}
+
+// Key() maps a name to what it is compared by, the repositories keep it unique among siblings
+func (p *NamingPolicy) Key(name string) string {
+	if p.caseInsensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// normalizeOptional() normalizes name unless it is nil
+func (p *NamingPolicy) normalizeOptional(name *string) (*string, error) {
+	if name == nil {
+		return nil, nil
+	}
+
+	normalized, err := p.Normalize(*name)
+	if err != nil {
+		return nil, err
+	}
+	return &normalized, nil
+}
+
+func invalidName(name string, reason string) error {
+	return fmt.Errorf("%w: %q %s", ErrInvalidName, name, reason)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"github.com/potatowhite/books/file-service/config"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	policy := NewNamingPolicy(config.Naming{MaxLength: 8, ForbiddenCharacters: `/\:`, ReservedNames: []string{"CON", "nul"}})
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"report", "report"},
+		{"café", "café"},
+		{"12345678", "12345678"},
+		{"cafééééé", "cafééééé"},
+		{"console", "console"},
+		{"a.con", "a.con"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{" report", ""},
+		{"report ", ""},
+		{"123456789", ""},
+		{"a/b", ""},
+		{"a\\b", ""},
+		{"c:", ""},
+		{"a\tb", ""},
+		{"a\x00b", ""},
+		{"\xff", ""},
+		{"CON", ""},
+		{"con.txt", ""},
+		{"Nul.tar.gz", ""},
+	}
+	for _, test := range tests {
+		normalized, err := policy.Normalize(test.name)
+		if test.expected == "" {
+			if !errors.Is(err, ErrInvalidName) {
+				t.Errorf("expected %q to be rejected, got %q: %v", test.name, normalized, err)
+			}
+		} else if err != nil || normalized != test.expected {
+			t.Errorf("expected %q for %q, got %q: %v", test.expected, test.name, normalized, err)
+		}
+	}
+}
+
+func TestNormalizeWithoutLimits(t *testing.T) {
+	policy := NewNamingPolicy(config.Naming{})
+	for _, name := range []string{"a:b", "CON", "a name with spaces inside", strings.Repeat("a", 300)} {
+		if _, err := policy.Normalize(name); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", name, err)
+		}
+	}
+}
+
+func TestSameName(t *testing.T) {
+	sensitive := NewNamingPolicy(config.Naming{})
+	insensitive := NewNamingPolicy(config.Naming{CaseInsensitive: true})
+	tests := []struct {
+		a, b        string
+		sensitive   bool
+		insensitive bool
+	}{
+		{"Report.pdf", "Report.pdf", true, true},
+		{"Report.pdf", "report.PDF", false, true},
+		{"Été", "été", false, true},
+		{"report", "report (1)", false, false},
+	}
+	for _, test := range tests {
+		if same := sensitive.SameName(test.a, test.b); same != test.sensitive {
+			t.Errorf("case-sensitive SameName(%q, %q) = %v", test.a, test.b, same)
+		}
+		if same := insensitive.SameName(test.a, test.b); same != test.insensitive {
+			t.Errorf("case-insensitive SameName(%q, %q) = %v", test.a, test.b, same)
+		}
+	}
+
+	taken := insensitive.takenBy([]string{"Report.pdf", "notes"})
+	if !taken("REPORT.pdf") || !taken("Notes") || taken("report (1).pdf") {
+		t.Fatal("takenBy does not follow the policy")
+	}
+	if insensitive.Key("Report.PDF") != "report.pdf" || sensitive.Key("Report.PDF") != "Report.PDF" {
+		t.Fatal("the key does not follow the policy")
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidSearch is wrapped by errors of search queries that are rejected
+var ErrInvalidSearch = errors.New("invalid search")
+
+const (
+	// the longest search text in characters
+	maxSearchTextLength = 256
+	// the largest page of search hits
+	maxSearchLimit = 200
+	// the deepest page of search hits, narrower queries find items further down
+	maxSearchOffset = 10000
+	// the largest number of extensions or types of a search
+	maxSearchValues = 50
+)
+
+// SearchResult is a page of search hits, HasMore tells whether hits follow the page
+type SearchResult struct {
+	Hits    []*repository.SearchHit
+	HasMore bool
+}
+
+type SearchService interface {
+	Search(ctx context.Context, userId uint, query repository.SearchQuery) (*SearchResult, error)
+}
+
+func NewSearchService(repo repository.SearchRepository, folderSvc FolderService) SearchService {
+	return &searchService{repo: repo, folderSvc: folderSvc}
+}
+
+type searchService struct {
+	repo      repository.SearchRepository
+	folderSvc FolderService
+}
+
+// Search() searches below query.FolderId, or the root folder when it is 0. A limit of 0 returns as many hits as allowed.
+func (s *searchService) Search(ctx context.Context, userId uint, query repository.SearchQuery) (*SearchResult, error) {
+	query, err := normalizeSearch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := s.folderSvc.GetRootFolder(ctx, userId)
+	if query.FolderId != 0 {
+		scope, err = s.folderSvc.GetFolder(ctx, userId, query.FolderId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := s.folderSvc.GetPathOrNil(ctx, userId, scope.ID)
+	if err != nil {
+		return nil, err
+	} else if path == nil {
+		return nil, fmt.Errorf("no path resolved for folder %d", scope.ID)
+	}
+	query.FolderId, query.FolderPath = scope.ID, *path
+
+	// one more hit than requested tells whether another page follows
+	limit := query.Limit
+	query.Limit++
+	hits, err := s.repo.Search(ctx, userId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hits) > limit {
+		return &SearchResult{Hits: hits[:limit], HasMore: true}, nil
+	}
+	return &SearchResult{Hits: hits}, nil
+}
+
+func normalizeSearch(query repository.SearchQuery) (repository.SearchQuery, error) {
+	if !utf8.ValidString(query.Text) {
+		return query, fmt.Errorf("%w: the text is not valid UTF-8", ErrInvalidSearch)
+	}
+	query.Text = strings.TrimSpace(norm.NFC.String(query.Text))
+	if utf8.RuneCountInString(query.Text) > maxSearchTextLength {
+		return query, fmt.Errorf("%w: the text must not be longer than %d characters", ErrInvalidSearch, maxSearchTextLength)
+	}
+
+	if query.Limit == 0 {
+		query.Limit = maxSearchLimit
+	} else if query.Limit < 0 || query.Limit > maxSearchLimit {
+		return query, fmt.Errorf("%w: the limit must be between 1 and %d", ErrInvalidSearch, maxSearchLimit)
+	}
+	if query.Offset < 0 || query.Offset > maxSearchOffset {
+		return query, fmt.Errorf("%w: the offset must be between 0 and %d", ErrInvalidSearch, maxSearchOffset)
+	}
+
+	if len(query.Extensions) > maxSearchValues || len(query.Types) > maxSearchValues {
+		return query, fmt.Errorf("%w: at most %d extensions and types are allowed", ErrInvalidSearch, maxSearchValues)
+	}
+	extensions := make([]string, len(query.Extensions))
+	for i, extension := range query.Extensions {
+		extensions[i] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+	}
+	query.Extensions = extensions
+
+	if query.MinSize != nil && query.MaxSize != nil && *query.MinSize > *query.MaxSize {
+		return query, fmt.Errorf("%w: minSize must not be larger than maxSize", ErrInvalidSearch)
+	}
+	if query.UpdatedAfter != nil && query.UpdatedBefore != nil && !query.UpdatedAfter.Before(*query.UpdatedBefore) {
+		return query, fmt.Errorf("%w: updatedAfter must be before updatedBefore", ErrInvalidSearch)
+	}
+
+	if query.Order == "" {
+		query.Order = repository.SearchByRelevance
+	}
+	if !query.Folders && !query.Files {
+		query.Folders, query.Files = true, true
+	}
+	return query, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSearchDefaults(t *testing.T) {
+	query, err := normalizeSearch(repository.SearchQuery{Text: "  café ", Extensions: []string{" .PDF", "epub"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Text != "café" {
+		t.Fatalf("expected the text trimmed and composed, got %q", query.Text)
+	}
+	if query.Limit != maxSearchLimit || query.Order != repository.SearchByRelevance || !query.Folders || !query.Files {
+		t.Fatalf("expected the defaults, got %+v", query)
+	}
+	if len(query.Extensions) != 2 || query.Extensions[0] != "pdf" || query.Extensions[1] != "epub" {
+		t.Fatalf("expected the extensions lower-cased without dots, got %v", query.Extensions)
+	}
+
+	query, err = normalizeSearch(repository.SearchQuery{Files: true, Limit: 10})
+	if err != nil || query.Folders || !query.Files || query.Limit != 10 {
+		t.Fatalf("expected the given kinds and limit to stay, got %+v: %v", query, err)
+	}
+}
+
+func TestNormalizeSearchRejects(t *testing.T) {
+	small, large := uint64(1), uint64(2)
+	earlier := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+	tests := []struct {
+		name  string
+		query repository.SearchQuery
+	}{
+		{"text not UTF-8", repository.SearchQuery{Text: "\xff"}},
+		{"text too long", repository.SearchQuery{Text: strings.Repeat("é", maxSearchTextLength+1)}},
+		{"negative limit", repository.SearchQuery{Limit: -1}},
+		{"limit too large", repository.SearchQuery{Limit: maxSearchLimit + 1}},
+		{"negative offset", repository.SearchQuery{Offset: -1}},
+		{"offset too deep", repository.SearchQuery{Offset: maxSearchOffset + 1}},
+		{"too many extensions", repository.SearchQuery{Extensions: make([]string, maxSearchValues+1)}},
+		{"too many types", repository.SearchQuery{Types: make([]string, maxSearchValues+1)}},
+		{"sizes reversed", repository.SearchQuery{MinSize: &large, MaxSize: &small}},
+		{"dates reversed", repository.SearchQuery{UpdatedAfter: &later, UpdatedBefore: &earlier}},
+		{"empty date range", repository.SearchQuery{UpdatedAfter: &earlier, UpdatedBefore: &earlier}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := normalizeSearch(test.query); !errors.Is(err, ErrInvalidSearch) {
+				t.Fatalf("expected the query to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNormalizeSearchAcceptsTheBounds(t *testing.T) {
+	size := uint64(5)
+	queries := []repository.SearchQuery{
+		{Text: strings.Repeat("é", maxSearchTextLength)},
+		{Limit: maxSearchLimit, Offset: maxSearchOffset},
+		{Extensions: make([]string, maxSearchValues), Types: make([]string, maxSearchValues)},
+		{MinSize: &size, MaxSize: &size},
+	}
+	for _, query := range queries {
+		if _, err := normalizeSearch(query); err != nil {
+			t.Errorf("expected %+v to be accepted, got %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the longest tag name in characters
+const maxTagNameLength = 64
+
+// colours are stored as lower case #rrggbb, an empty colour leaves the choice to clients
+var tagColor = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+type TagService interface {
+	CreateTag(ctx context.Context, userId uint, name string, color string) (*entity.Tag, error)
+	UpdateTag(ctx context.Context, userId uint, id uint, name *string, color *string) (*entity.Tag, error)
+	DeleteTag(ctx context.Context, userId uint, id uint) (bool, error)
+	DeleteAllTags(ctx context.Context, userId uint) (int64, error)
+	GetTags(ctx context.Context, userId uint) ([]*entity.Tag, error)
+
+	AddTags(ctx context.Context, userId uint, tagIds []uint, items BulkItems) error
+	RemoveTags(ctx context.Context, userId uint, tagIds []uint, items BulkItems) error
+
+	GetFileTags(ctx context.Context, userId uint, fileId uint) ([]*entity.Tag, error)
+	GetFolderTags(ctx context.Context, userId uint, folderId uint) ([]*entity.Tag, error)
+	GetTagged(ctx context.Context, userId uint, tagId uint) ([]*entity.Folder, []*entity.File, error)
+}
+
+func NewTagService(repo repository.TagRepository, tx repository.Transactor) TagService {
+	return &tagService{repo: repo, tx: tx}
+}
+
+type tagService struct {
+	repo repository.TagRepository
+	tx   repository.Transactor
+}
+
+// CreateTag() fails with repository.ErrConflict when the user has a tag of the same name, ignoring case
+func (t *tagService) CreateTag(ctx context.Context, userId uint, name string, color string) (*entity.Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	color, err = normalizeTagColor(color)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.repo.CreateTag(ctx, userId, name, color)
+}
+
+// UpdateTag() renames or recolours the tag, nil leaves a field as it is
+func (t *tagService) UpdateTag(ctx context.Context, userId uint, id uint, name *string, color *string) (*entity.Tag, error) {
+	tag, err := t.repo.GetTag(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != nil {
+		if tag.Name, err = normalizeTagName(*name); err != nil {
+			return nil, err
+		}
+	}
+	if color != nil {
+		if tag.Color, err = normalizeTagColor(*color); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.repo.UpdateTag(ctx, userId, tag); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (t *tagService) DeleteTag(ctx context.Context, userId uint, id uint) (bool, error) {
+	return t.repo.DeleteTag(ctx, userId, id)
+}
+
+func (t *tagService) DeleteAllTags(ctx context.Context, userId uint) (int64, error) {
+	return t.repo.DeleteAllTags(ctx, userId)
+}
+
+func (t *tagService) GetTags(ctx context.Context, userId uint) ([]*entity.Tag, error) {
+	return t.repo.GetTags(ctx, userId)
+}
+
+// AddTags() attaches every tag to every item, items that already carry a tag keep it.
+// Nothing is tagged when one of the tags or items does not exist.
+func (t *tagService) AddTags(ctx context.Context, userId uint, tagIds []uint, items BulkItems) error {
+	return t.tx.Transaction(ctx, func(ctx context.Context) error {
+		if err := t.checkExisting(ctx, userId, tagIds, items); err != nil {
+			return err
+		}
+		if err := t.repo.TagFiles(ctx, userId, tagIds, items.FileIds); err != nil {
+			return err
+		}
+		return t.repo.TagFolders(ctx, userId, tagIds, items.FolderIds)
+	})
+}
+
+// RemoveTags() detaches every tag from every item, items without a tag are left as they are
+func (t *tagService) RemoveTags(ctx context.Context, userId uint, tagIds []uint, items BulkItems) error {
+	return t.tx.Transaction(ctx, func(ctx context.Context) error {
+		if err := t.checkExisting(ctx, userId, tagIds, items); err != nil {
+			return err
+		}
+		if err := t.repo.UntagFiles(ctx, userId, tagIds, items.FileIds); err != nil {
+			return err
+		}
+		return t.repo.UntagFolders(ctx, userId, tagIds, items.FolderIds)
+	})
+}
+
+// checkExisting() fails with ErrNotFound naming the tags and items the user does not have
+func (t *tagService) checkExisting(ctx context.Context, userId uint, tagIds []uint, items BulkItems) error {
+	if count := len(items.FileIds) + len(items.FolderIds); count > maxBulkItems || len(tagIds) > maxBulkItems {
+		return fmt.Errorf("tags are changed on at most %d items with at most %d tags at once", maxBulkItems, maxBulkItems)
+	}
+
+	checks := []struct {
+		kind  string
+		ids   []uint
+		exist func(ctx context.Context, userId uint, ids []uint) ([]uint, error)
+	}{
+		{"tags", tagIds, t.repo.GetExistingTagIds},
+		{"files", items.FileIds, t.repo.GetExistingFileIds},
+		{"folders", items.FolderIds, t.repo.GetExistingFolderIds},
+	}
+	for _, check := range checks {
+		existing, err := check.exist(ctx, userId, check.ids)
+		if err != nil {
+			return err
+		}
+		if missing := missingIds(check.ids, existing); len(missing) > 0 {
+			return fmt.Errorf("%w: %s %v", ErrNotFound, check.kind, missing)
+		}
+	}
+	return nil
+}
+
+func (t *tagService) GetFileTags(ctx context.Context, userId uint, fileId uint) ([]*entity.Tag, error) {
+	return t.repo.GetFileTags(ctx, userId, fileId)
+}
+
+func (t *tagService) GetFolderTags(ctx context.Context, userId uint, folderId uint) ([]*entity.Tag, error) {
+	return t.repo.GetFolderTags(ctx, userId, folderId)
+}
+
+// GetTagged() returns the folders and files carrying the tag across the whole tree of the user
+func (t *tagService) GetTagged(ctx context.Context, userId uint, tagId uint) ([]*entity.Folder, []*entity.File, error) {
+	if _, err := t.repo.GetTag(ctx, userId, tagId); err != nil {
+		return nil, nil, err
+	}
+
+	folders, err := t.repo.GetTaggedFolders(ctx, userId, tagId)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := t.repo.GetTaggedFiles(ctx, userId, tagId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, files, nil
+}
+
+// missingIds() returns the ids that are not in existing, each once
+func missingIds(ids []uint, existing []uint) []uint {
+	found := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+
+	var missing []uint
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+			found[id] = true
+		}
+	}
+	return missing
+}
+
+func normalizeTagName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: the name is not valid UTF-8", ErrInvalidTag)
+	}
+
+	name = strings.TrimSpace(norm.NFC.String(name))
+	switch {
+	case name == "":
+		return "", fmt.Errorf("%w: the name must not be empty", ErrInvalidTag)
+	case utf8.RuneCountInString(name) > maxTagNameLength:
+		return "", fmt.Errorf("%w: the name must not be longer than %d characters", ErrInvalidTag, maxTagNameLength)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "", fmt.Errorf("%w: the name must not contain control characters", ErrInvalidTag)
+	}
+	return name, nil
+}
+
+func normalizeTagColor(color string) (string, error) {
+	color = strings.ToLower(strings.TrimSpace(color))
+	if color != "" && !tagColor.MatchString(color) {
+		return "", fmt.Errorf("%w: the colour %q is not of the form #rrggbb", ErrInvalidTag, color)
+	}
+	return color, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTagName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"work", "work"},
+		{"  work  ", "work"},
+		{"café", "café"},
+		{strings.Repeat("é", maxTagNameLength), strings.Repeat("é", maxTagNameLength)},
+		{"", ""},
+		{"   ", ""},
+		{strings.Repeat("a", maxTagNameLength+1), ""},
+		{"to\ndo", ""},
+		{"\xff", ""},
+	}
+	for _, test := range tests {
+		normalized, err := normalizeTagName(test.name)
+		if test.expected == "" {
+			if !errors.Is(err, ErrInvalidTag) {
+				t.Errorf("expected %q to be rejected, got %q: %v", test.name, normalized, err)
+			}
+		} else if err != nil || normalized != test.expected {
+			t.Errorf("expected %q for %q, got %q: %v", test.expected, test.name, normalized, err)
+		}
+	}
+}
+
+func TestNormalizeTagColor(t *testing.T) {
+	tests := []struct {
+		color    string
+		expected string
+		valid    bool
+	}{
+		{"", "", true},
+		{"#a1b2c3", "#a1b2c3", true},
+		{" #A1B2C3 ", "#a1b2c3", true},
+		{"#abc", "", false},
+		{"a1b2c3", "", false},
+		{"#a1b2c3ff", "", false},
+		{"#g1b2c3", "", false},
+		{"red", "", false},
+	}
+	for _, test := range tests {
+		normalized, err := normalizeTagColor(test.color)
+		if test.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidTag)) || normalized != test.expected {
+			t.Errorf("normalizeTagColor(%q) = %q, %v", test.color, normalized, err)
+		}
+	}
+}
+
+func TestMissingIds(t *testing.T) {
+	tests := []struct {
+		ids      []uint
+		existing []uint
+		expected []uint
+	}{
+		{[]uint{1, 2, 3}, []uint{1, 2, 3}, nil},
+		{[]uint{1, 2, 3}, []uint{2}, []uint{1, 3}},
+		{[]uint{3, 1, 3, 1}, nil, []uint{3, 1}},
+		{nil, []uint{1}, nil},
+	}
+	for _, test := range tests {
+		missing := missingIds(test.ids, test.existing)
+		if len(missing) != len(test.expected) {
+			t.Errorf("missingIds(%v, %v) = %v, expected %v", test.ids, test.existing, missing, test.expected)
+			continue
+		}
+		for i := range missing {
+			if missing[i] != test.expected[i] {
+				t.Errorf("missingIds(%v, %v) = %v, expected %v", test.ids, test.existing, missing, test.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/health"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrNotFound is returned when no content is stored for a file
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps the content of files, keyed by file id
+type BlobStore interface {
+	// Open() returns the content of the file and its size, or ErrNotFound
+	Open(ctx context.Context, id uint) (io.ReadCloser, int64, error)
+	// Put() replaces the content of the file and returns the number of bytes stored
+	Put(ctx context.Context, id uint, content io.Reader) (int64, error)
+	// Delete() removes the content of the file, deleting missing content is not an error
+	Delete(ctx context.Context, id uint) error
+}
+
+// NewLocalBlobStore() stores contents as files below dir
+func NewLocalBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory %s: %w", dir, err)
+	}
+	return &localBlobStore{dir: dir}, nil
+}
+
+type localBlobStore struct {
+	dir string
+}
+
+func (s *localBlobStore) Open(ctx context.Context, id uint) (io.ReadCloser, int64, error) {
+	file, err := os.Open(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrNotFound
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, id uint, content io.Reader) (int64, error) {
+	path := s.path(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, err
+	}
+
+	// readers never see partially written content, the complete file replaces the old one
+	temp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+
+	written, err := io.Copy(temp, readerWithContext{ctx: ctx, reader: content})
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return written, os.Rename(temp.Name(), path)
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, id uint) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// HealthCheck() writes and removes a probe file below dir, failing when the storage is unmounted,
+// read-only or full
+func HealthCheck(dir string) health.Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		details := map[string]interface{}{"dir": dir}
+
+		probe, err := os.CreateTemp(dir, ".probe-*")
+		if err != nil {
+			return details, err
+		}
+		defer os.Remove(probe.Name())
+
+		_, err = probe.WriteString("ok")
+		if closeErr := probe.Close(); err == nil {
+			err = closeErr
+		}
+		return details, err
+	}
+}
+
+// path() spreads the blobs over 256 directories
+func (s *localBlobStore) path(id uint) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%02x", id%256), strconv.FormatUint(uint64(id), 10))
+}
+
+// readerWithContext stops reading once the context is done
+type readerWithContext struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r readerWithContext) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
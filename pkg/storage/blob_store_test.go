@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStoreDeleteIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := blobs.Put(ctx, 300, strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	content, size, err := blobs.Open(ctx, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if size != 7 || string(data) != "content" {
+		t.Fatalf("expected the stored content, got %q of %d bytes", data, size)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := blobs.Delete(ctx, 300); err != nil {
+			t.Fatalf("delete %d failed: %v", i+1, err)
+		}
+	}
+	if _, _, err := blobs.Open(ctx, 300); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after the delete, got %v", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := HealthCheck(dir)(context.Background()); err != nil {
+		t.Fatalf("expected a writable directory to be healthy, got %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal("the probe file was left behind")
+	}
+
+	if _, err := HealthCheck(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Fatal("expected a missing directory to be unhealthy")
+	}
+}
@@ -1,6 +1,10 @@
 package util
 
-import "strconv"
+import (
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"strconv"
+)
 
 // AtoIOrNil converts a string to an int or returns nil if the string is empty or nil.
 func AtoIOrNil(s *string) *int {
@@ -26,6 +30,31 @@ func AtoUIOrNil(s *string) *uint {
 	return &ui
 }
 
+// ToExpectedVersion converts an expected version, nil skips the check and negative versions are rejected.
+func ToExpectedVersion(version *int) (*uint, error) {
+	if version == nil {
+		return nil, nil
+	}
+	if *version < 0 {
+		return nil, fmt.Errorf("%w: expectedVersion %d is negative", service.ErrInvalidVersion, *version)
+	}
+	converted := uint(*version)
+	return &converted, nil
+}
+
+// AtoUIs converts a list of ids, failing on the first one that is not a number.
+func AtoUIs(ids []string) ([]uint, error) {
+	converted := make([]uint, len(ids))
+	for i, id := range ids {
+		value, err := strconv.ParseUint(id, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", id)
+		}
+		converted[i] = uint(value)
+	}
+	return converted, nil
+}
+
 func ItoAOrNil(id *int) *string {
 	if id == nil {
 		return nil
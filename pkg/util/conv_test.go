@@ -0,0 +1,53 @@
+package util
+
+import (
+	"errors"
+	"github.com/potatowhite/books/file-service/graph/model"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"testing"
+)
+
+func TestToExpectedVersion(t *testing.T) {
+	if version, err := ToExpectedVersion(nil); version != nil || err != nil {
+		t.Fatalf("expected no check, got %v, %v", version, err)
+	}
+
+	zero := 0
+	if version, err := ToExpectedVersion(&zero); err != nil || version == nil || *version != 0 {
+		t.Fatalf("expected version 0, got %v, %v", version, err)
+	}
+
+	negative := -1
+	if _, err := ToExpectedVersion(&negative); !errors.Is(err, service.ErrInvalidVersion) || ErrorCode(err) != "INVALID_VERSION" {
+		t.Fatalf("expected a negative version to be rejected, got %v", err)
+	}
+}
+
+func TestAddExpectedVersions(t *testing.T) {
+	items := service.BulkItems{FileIds: []uint{1, 2}, FolderIds: []uint{1}}
+	err := AddExpectedVersions(&items, []*model.ExpectedVersion{
+		{ID: "2", Kind: model.ItemKindFile, Version: 4},
+		{ID: "1", Kind: model.ItemKindFolder, Version: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items.FileVersions) != 1 || items.FileVersions[2] != 4 {
+		t.Fatalf("unexpected file versions %v", items.FileVersions)
+	}
+	if version, ok := items.FolderVersions[1]; !ok || version != 0 {
+		t.Fatalf("unexpected folder versions %v", items.FolderVersions)
+	}
+
+	tests := map[string]*model.ExpectedVersion{
+		"not an item":  {ID: "3", Kind: model.ItemKindFile, Version: 1},
+		"other kind":   {ID: "2", Kind: model.ItemKindFolder, Version: 1},
+		"negative":     {ID: "1", Kind: model.ItemKindFile, Version: -1},
+		"not a number": {ID: "one", Kind: model.ItemKindFile, Version: 1},
+	}
+	for name, expected := range tests {
+		if err := AddExpectedVersions(&items, []*model.ExpectedVersion{expected}); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
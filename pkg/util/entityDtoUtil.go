@@ -1,8 +1,16 @@
 package util
 
 import (
+	"fmt"
 	"github.com/potatowhite/books/file-service/graph/model"
+	"github.com/potatowhite/books/file-service/pkg/importer"
+	"github.com/potatowhite/books/file-service/pkg/repository"
 	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func ToFolderDto(folder *entity.Folder) *model.Folder {
@@ -14,6 +22,8 @@ func ToFolderDto(folder *entity.Folder) *model.Folder {
 		ParentID: parentID,
 		UserID:   *UItoAOrNil(&folder.UserId),
 		Path:     &folder.Path,
+		Version:  int(folder.Version),
+		Metadata: toMetadataDto(folder.Metadata),
 	}
 }
 
@@ -29,5 +39,244 @@ func ToFileDto(file *entity.File) *model.File {
 		Modified:  &file.Modified,
 		UserID:    *UItoAOrNil(&file.UserId),
 		Path:      &file.Path,
+		Version:   int(file.Version),
+		Metadata:  toMetadataDto(file.Metadata),
 	}
 }
+
+// toMetadataDto() returns an empty document for items read without their metadata
+func toMetadataDto(metadata entity.Metadata) map[string]interface{} {
+	if metadata == nil {
+		return map[string]interface{}{}
+	}
+	return metadata
+}
+
+func ToMetadataFilters(filters []*model.MetadataFilter) []repository.MetadataFilter {
+	converted := make([]repository.MetadataFilter, len(filters))
+	for i, filter := range filters {
+		converted[i] = repository.MetadataFilter{Key: filter.Key, Op: repository.MetadataOp(filter.Op), Value: filter.Value}
+	}
+	return converted
+}
+
+func ToTagDto(tag *entity.Tag) *model.Tag {
+	dto := &model.Tag{
+		ID:     *UItoAOrNil(&tag.ID),
+		Name:   tag.Name,
+		UserID: *UItoAOrNil(&tag.UserId),
+	}
+	if tag.Color != "" {
+		dto.Color = &tag.Color
+	}
+	return dto
+}
+
+func ToTagDtos(tags []*entity.Tag) []*model.Tag {
+	dtos := make([]*model.Tag, len(tags))
+	for i, tag := range tags {
+		dtos[i] = ToTagDto(tag)
+	}
+	return dtos
+}
+
+// ToConflictStrategy converts the GraphQL enum, an omitted strategy fails on conflicts.
+func ToConflictStrategy(strategy *model.ConflictStrategy) service.ConflictStrategy {
+	if strategy == nil {
+		return service.ConflictFail
+	}
+	return service.ConflictStrategy(*strategy)
+}
+
+// ToBulkItems converts the ids of a bulk operation, failing on ids that are not numbers.
+func ToBulkItems(items model.BulkItems) (service.BulkItems, error) {
+	fileIds, err := AtoUIs(items.FileIds)
+	if err != nil {
+		return service.BulkItems{}, err
+	}
+	folderIds, err := AtoUIs(items.FolderIds)
+	if err != nil {
+		return service.BulkItems{}, err
+	}
+	return service.BulkItems{FileIds: fileIds, FolderIds: folderIds}, nil
+}
+
+// AddExpectedVersions sets the expected versions of the items, failing on items that are not part of them.
+func AddExpectedVersions(items *service.BulkItems, versions []*model.ExpectedVersion) error {
+	for _, expected := range versions {
+		id, err := strconv.ParseUint(expected.ID, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", expected.ID)
+		}
+		version, err := ToExpectedVersion(&expected.Version)
+		if err != nil {
+			return err
+		}
+
+		ids, versions := items.FileIds, &items.FileVersions
+		if expected.Kind == model.ItemKindFolder {
+			ids, versions = items.FolderIds, &items.FolderVersions
+		}
+		if !slices.Contains(ids, uint(id)) {
+			return fmt.Errorf("expected version of %s %v, which is not one of the items", strings.ToLower(string(expected.Kind)), id)
+		}
+		if *versions == nil {
+			*versions = make(map[uint]uint)
+		}
+		(*versions)[uint(id)] = *version
+	}
+	return nil
+}
+
+// ToBulkMode converts the GraphQL enum, an omitted mode is atomic.
+func ToBulkMode(mode *model.BulkMode) service.BulkMode {
+	if mode == nil {
+		return service.BulkAtomic
+	}
+	return service.BulkMode(*mode)
+}
+
+func ToBulkResultDto(result *service.BulkResult) *model.BulkResult {
+	items := make([]*model.BulkItemResult, len(result.Items))
+	for i, item := range result.Items {
+		dto := &model.BulkItemResult{
+			ID:   *UItoAOrNil(&item.Id),
+			Kind: model.ItemKindFile,
+			Ok:   item.Err == nil,
+		}
+		if item.IsFolder {
+			dto.Kind = model.ItemKindFolder
+		}
+		if item.File != nil {
+			dto.File = ToFileDto(item.File)
+		}
+		if item.Folder != nil {
+			dto.Folder = ToFolderDto(item.Folder)
+		}
+		if item.Err != nil {
+			message := item.Err.Error()
+			dto.Error = &message
+			if code := ErrorCode(item.Err); code != "" {
+				dto.Code = &code
+			}
+		}
+		items[i] = dto
+	}
+
+	return &model.BulkResult{Ok: result.Succeeded(), Items: items}
+}
+
+func ToImportJobDto(job *importer.Job) *model.ImportJob {
+	failures := make([]*model.ImportFailure, len(job.Failures))
+	for i, failure := range job.Failures {
+		failures[i] = &model.ImportFailure{Path: failure.Path, Error: failure.Error}
+	}
+
+	dto := &model.ImportJob{
+		ID:               job.ID,
+		FolderID:         *UItoAOrNil(&job.FolderId),
+		Status:           model.ImportJobStatus(job.Status),
+		EntriesProcessed: job.EntriesProcessed,
+		FilesImported:    job.FilesImported,
+		FoldersImported:  job.FoldersImported,
+		BytesExtracted:   int(job.BytesExtracted),
+		Failures:         failures,
+		StartedAt:        job.StartedAt.Format(time.RFC3339),
+	}
+	if job.Error != "" {
+		dto.Error = &job.Error
+	}
+	if job.FinishedAt != nil {
+		finishedAt := job.FinishedAt.Format(time.RFC3339)
+		dto.FinishedAt = &finishedAt
+	}
+	return dto
+}
+
+// ToSearchQuery converts the arguments of a search, failing on scopes, sizes and timestamps that cannot be read.
+func ToSearchQuery(text *string, filters *model.SearchFilters, scope *string, order *model.SearchOrder, limit *int, offset *int) (repository.SearchQuery, error) {
+	var query repository.SearchQuery
+	if text != nil {
+		query.Text = *text
+	}
+	if scope != nil {
+		ids, err := AtoUIs([]string{*scope})
+		if err != nil {
+			return query, fmt.Errorf("%w: %v", service.ErrInvalidSearch, err)
+		}
+		query.FolderId = ids[0]
+	}
+	if order != nil {
+		query.Order = repository.SearchOrder(*order)
+	}
+	// a null limit returns as many hits as allowed
+	if limit != nil {
+		query.Limit = *limit
+	}
+	if offset != nil {
+		query.Offset = *offset
+	}
+	if filters == nil {
+		return query, nil
+	}
+
+	for _, kind := range filters.Kinds {
+		query.Folders = query.Folders || kind == model.ItemKindFolder
+		query.Files = query.Files || kind == model.ItemKindFile
+	}
+	query.Extensions, query.Types = filters.Extensions, filters.Types
+
+	var err error
+	if query.MinSize, err = toSearchSize("minSize", filters.MinSize); err != nil {
+		return query, err
+	}
+	if query.MaxSize, err = toSearchSize("maxSize", filters.MaxSize); err != nil {
+		return query, err
+	}
+	if query.UpdatedAfter, err = toSearchTime("updatedAfter", filters.UpdatedAfter); err != nil {
+		return query, err
+	}
+	if query.UpdatedBefore, err = toSearchTime("updatedBefore", filters.UpdatedBefore); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+func toSearchSize(name string, size *int) (*uint64, error) {
+	if size == nil {
+		return nil, nil
+	}
+	if *size < 0 {
+		return nil, fmt.Errorf("%w: %s must not be negative", service.ErrInvalidSearch, name)
+	}
+	converted := uint64(*size)
+	return &converted, nil
+}
+
+func toSearchTime(name string, value *string) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not an RFC 3339 timestamp", service.ErrInvalidSearch, name)
+	}
+	return &parsed, nil
+}
+
+func ToSearchResultDto(result *service.SearchResult) *model.SearchResult {
+	hits := make([]*model.SearchHit, len(result.Hits))
+	for i, hit := range result.Hits {
+		dto := &model.SearchHit{Kind: model.ItemKindFile, Path: hit.Path, Score: hit.Score}
+		if hit.Folder != nil {
+			dto.Kind = model.ItemKindFolder
+			dto.Folder = ToFolderDto(hit.Folder)
+		}
+		if hit.File != nil {
+			dto.File = ToFileDto(hit.File)
+		}
+		hits[i] = dto
+	}
+
+	return &model.SearchResult{Hits: hits, HasMore: result.HasMore}
+}
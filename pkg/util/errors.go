@@ -0,0 +1,34 @@
+package util
+
+import (
+	"errors"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"gorm.io/gorm"
+)
+
+// ErrorCode returns the code telling clients how to resolve err, or an empty string for unexpected errors.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, repository.ErrConflict):
+		return "CONFLICT"
+	case errors.Is(err, service.ErrInvalidName):
+		return "INVALID_NAME"
+	case errors.Is(err, service.ErrInvalidMove):
+		return "INVALID_MOVE"
+	case errors.Is(err, service.ErrInvalidVersion):
+		return "INVALID_VERSION"
+	case errors.Is(err, service.ErrInvalidTag):
+		return "INVALID_TAG"
+	case errors.Is(err, service.ErrInvalidMetadata):
+		return "INVALID_METADATA"
+	case errors.Is(err, service.ErrInvalidSearch):
+		return "INVALID_SEARCH"
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, service.ErrRolledBack):
+		return "ROLLED_BACK"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,79 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// putCondition is what the If-Match and If-None-Match headers of a PUT demand of the file it writes,
+// the webdav handler does not evaluate them for PUT
+type putCondition struct {
+	// fileId is the file If-Match matched, its content is only replaced while it is still at version
+	fileId  uint
+	version uint
+	// create is set by If-None-Match: *, the PUT must not overwrite an existing file
+	create bool
+}
+
+type putConditionKey struct{}
+
+func putConditionOf(ctx context.Context) *putCondition {
+	condition, _ := ctx.Value(putConditionKey{}).(*putCondition)
+	return condition
+}
+
+// checkPut() evaluates the preconditions of a PUT of name against the current file,
+// failing with errPreconditionFailed. The returned context carries them on to OpenFile().
+func (fs *fileSystem) checkPut(ctx context.Context, r *http.Request, name string) (context.Context, error) {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return ctx, nil
+	}
+
+	n, err := fs.resolve(ctx, name)
+	if err != nil && !isNotExist(err) {
+		return ctx, err
+	}
+	current := ""
+	if n != nil && n.file != nil {
+		current = etag(n.file)
+	}
+
+	condition := &putCondition{}
+	if ifMatch != "" {
+		// a folder has no ETag a PUT could match
+		if current == "" || !matchETag(ifMatch, current, false) {
+			return ctx, errPreconditionFailed
+		}
+		condition.fileId, condition.version = n.file.ID, n.file.Version
+	}
+	if ifNoneMatch != "" {
+		if n != nil && strings.TrimSpace(ifNoneMatch) == "*" {
+			return ctx, errPreconditionFailed
+		}
+		if current != "" && matchETag(ifNoneMatch, current, true) {
+			return ctx, errPreconditionFailed
+		}
+		condition.create = strings.TrimSpace(ifNoneMatch) == "*"
+	}
+
+	return context.WithValue(ctx, putConditionKey{}, condition), nil
+}
+
+// matchETag() reports whether the header lists the etag or is *, weak tags only match with weak comparison
+func matchETag(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package webdav
+
+import "testing"
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header   string
+		weak     bool
+		expected bool
+	}{
+		{`"1-2"`, false, true},
+		{`"1-1", "1-2"`, false, true},
+		{`*`, false, true},
+		{`"1-3"`, false, false},
+		{`W/"1-2"`, false, false},
+		{`W/"1-2"`, true, true},
+		{`"2-2"`, true, false},
+	}
+	for _, test := range tests {
+		if matched := matchETag(test.header, `"1-2"`, test.weak); matched != test.expected {
+			t.Errorf("matchETag(%s, weak %v) = %v, expected %v", test.header, test.weak, matched, test.expected)
+		}
+	}
+}
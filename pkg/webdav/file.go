@@ -0,0 +1,308 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"golang.org/x/net/webdav"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	errReadOnly  = errors.New("the file is open for reading")
+	errWriteOnly = errors.New("the file is open for writing")
+	errNotDir    = errors.New("not a folder")
+)
+
+// fileInfo describes a folder or file, the content type is the one stored with the file
+type fileInfo struct {
+	name        string
+	size        int64
+	dir         bool
+	modified    time.Time
+	contentType string
+	// file is read for the ETag when it is asked for, after a write completed
+	file *entity.File
+}
+
+func newFolderInfo(folder *entity.Folder) *fileInfo {
+	name := folder.Name
+	if folder.ParentId == nil {
+		name = "/"
+	}
+	return &fileInfo{name: name, dir: true, modified: folder.UpdatedAt}
+}
+
+func newFileInfo(file *entity.File) *fileInfo {
+	return &fileInfo{name: file.Name, size: int64(file.Size), modified: file.UpdatedAt, contentType: file.Type, file: file}
+}
+
+// etag() changes with every write of the file and differs between files that had the same path
+func etag(file *entity.File) string {
+	return fmt.Sprintf(`"%d-%d"`, file.ID, file.Version)
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modified }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+func (i *fileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// ETag() is the one If-Match and If-None-Match of a PUT are compared with, folders use the default
+func (i *fileInfo) ETag(ctx context.Context) (string, error) {
+	if i.file == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return etag(i.file), nil
+}
+
+// ContentType() spares PROPFIND from reading the content to sniff the type
+func (i *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if i.contentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.contentType, nil
+}
+
+// folderHandle lists the folders and files of a folder, the listing is read on the first Readdir()
+type folderHandle struct {
+	ctx     context.Context
+	fs      *fileSystem
+	folder  *entity.Folder
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (h *folderHandle) Readdir(count int) ([]os.FileInfo, error) {
+	if !h.listed {
+		folders, err := h.fs.folderSvc.GetChildren(h.ctx, h.fs.userId, h.folder.ID)
+		if err != nil {
+			return nil, err
+		}
+		files, err := h.fs.fileSvc.GetChildren(h.ctx, h.fs.userId, h.folder.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, folder := range folders {
+			h.entries = append(h.entries, newFolderInfo(folder))
+		}
+		for _, file := range files {
+			h.entries = append(h.entries, newFileInfo(file))
+		}
+		h.listed = true
+	}
+
+	if count <= 0 {
+		entries := h.entries
+		h.entries = nil
+		return entries, nil
+	}
+	if len(h.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(h.entries))
+	entries := h.entries[:count]
+	h.entries = h.entries[count:]
+	return entries, nil
+}
+
+func (h *folderHandle) Stat() (os.FileInfo, error)                   { return newFolderInfo(h.folder), nil }
+func (h *folderHandle) Read(p []byte) (int, error)                   { return 0, errIsFolder }
+func (h *folderHandle) Write(p []byte) (int, error)                  { return 0, errIsFolder }
+func (h *folderHandle) Seek(offset int64, whence int) (int64, error) { return 0, errIsFolder }
+func (h *folderHandle) Close() error                                 { return nil }
+
+// readHandle reads the stored content of a file, opening it on first use
+type readHandle struct {
+	ctx  context.Context
+	fs   *fileSystem
+	file *entity.File
+
+	content io.ReadCloser
+	size    int64
+	// pos is where the next Read() starts, at is where content currently is
+	pos int64
+	at  int64
+}
+
+func (h *readHandle) open() error {
+	if h.content != nil {
+		return nil
+	}
+
+	content, size, err := h.fs.blobs.Open(h.ctx, h.file.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// the file has metadata only, it reads as empty
+		content, size = io.NopCloser(strings.NewReader("")), 0
+	} else if err != nil {
+		return err
+	}
+
+	h.content, h.size, h.at = content, size, 0
+	return nil
+}
+
+func (h *readHandle) Read(p []byte) (int, error) {
+	if err := h.open(); err != nil {
+		return 0, err
+	}
+
+	if h.pos != h.at {
+		if seeker, ok := h.content.(io.Seeker); ok {
+			if _, err := seeker.Seek(h.pos, io.SeekStart); err != nil {
+				return 0, err
+			}
+		} else {
+			// content that cannot seek is read again from the start
+			h.content.Close()
+			h.content = nil
+			if err := h.open(); err != nil {
+				return 0, err
+			}
+			if _, err := io.CopyN(io.Discard, h.content, h.pos); err != nil && err != io.EOF {
+				return 0, err
+			}
+		}
+		h.at = h.pos
+	}
+
+	n, err := h.content.Read(p)
+	h.pos += int64(n)
+	h.at = h.pos
+	return n, err
+}
+
+func (h *readHandle) Seek(offset int64, whence int) (int64, error) {
+	if err := h.open(); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.pos
+	case io.SeekEnd:
+		offset += h.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+
+	h.pos = offset
+	return offset, nil
+}
+
+func (h *readHandle) Close() error {
+	if h.content == nil {
+		return nil
+	}
+	return h.content.Close()
+}
+
+func (h *readHandle) Stat() (os.FileInfo, error)         { return newFileInfo(h.file), nil }
+func (h *readHandle) Readdir(int) ([]os.FileInfo, error) { return nil, errNotDir }
+func (h *readHandle) Write(p []byte) (int, error)        { return 0, errReadOnly }
+
+// writeHandle streams the written bytes into the blob store and updates the file metadata on Close().
+// With an expectedVersion the content is only replaced while the file is still at that version.
+type writeHandle struct {
+	ctx             context.Context
+	fs              *fileSystem
+	file            *entity.File
+	expectedVersion *uint
+	// created is set when the file was created for this write, it is removed again when the write fails
+	created bool
+	pipe    *io.PipeWriter
+	stored  chan error
+	written int64
+}
+
+func newWriteHandle(ctx context.Context, fs *fileSystem, file *entity.File, expectedVersion *uint, created bool) *writeHandle {
+	reader, writer := io.Pipe()
+	h := &writeHandle{ctx: ctx, fs: fs, file: file, expectedVersion: expectedVersion, created: created, pipe: writer, stored: make(chan error, 1)}
+
+	go func() {
+		_, err := fs.blobs.Put(ctx, file.ID, reader)
+		reader.CloseWithError(err)
+		h.stored <- err
+	}()
+
+	return h
+}
+
+func (h *writeHandle) Write(p []byte) (int, error) {
+	n, err := h.pipe.Write(p)
+	h.written += int64(n)
+	return n, err
+}
+
+func (h *writeHandle) Close() error {
+	err := h.store()
+	if err != nil && h.created {
+		// as if the PUT never happened, also when the client is gone
+		if _, deleteErr := h.fs.fileSvc.DeleteFile(context.WithoutCancel(h.ctx), h.fs.userId, h.file.ID); deleteErr != nil {
+			logger.WarnContext(h.ctx, "failed to remove the file of a failed webdav write", "file_id", h.file.ID, "error", deleteErr)
+		}
+	}
+	return err
+}
+
+// store() completes the content and the metadata of the file, both or neither
+func (h *writeHandle) store() error {
+	// the handler closes the file also when the body broke off, the previous content stays
+	if err := bodyError(h.ctx); err != nil {
+		h.pipe.CloseWithError(err)
+		<-h.stored
+		return err
+	}
+
+	size := uint64(h.written)
+	ext := strings.TrimPrefix(path.Ext(h.file.Name), ".")
+	fileType := mime.TypeByExtension(path.Ext(h.file.Name))
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+
+	// the updated row stays locked until the content is in place, a concurrent write of the file waits for it
+	// and then finds the version changed
+	return h.fs.tx.Transaction(h.ctx, func(ctx context.Context) error {
+		file, err := h.fs.fileSvc.PatchFile(ctx, h.fs.userId, h.file.ID, nil, &fileType, &ext, &size, h.expectedVersion)
+		if err != nil {
+			// the previous content stays
+			h.pipe.CloseWithError(err)
+			<-h.stored
+			return err
+		}
+
+		h.pipe.Close()
+		if err := <-h.stored; err != nil {
+			return err
+		}
+		*h.file = *file
+		return nil
+	})
+}
+
+// Stat() describes the file as it is once Close() completed, the handler asks for the ETag only then
+func (h *writeHandle) Stat() (os.FileInfo, error) {
+	return &fileInfo{name: h.file.Name, size: h.written, modified: time.Now(), file: h.file}, nil
+}
+
+func (h *writeHandle) Read(p []byte) (int, error)                   { return 0, errWriteOnly }
+func (h *writeHandle) Seek(offset int64, whence int) (int64, error) { return 0, errWriteOnly }
+func (h *writeHandle) Readdir(int) ([]os.FileInfo, error)           { return nil, errNotDir }
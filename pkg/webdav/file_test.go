@@ -0,0 +1,115 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"io"
+	"testing"
+)
+
+// fakeFiles records the files patched and deleted, the embedded interface panics on other calls
+type fakeFiles struct {
+	service.FileService
+	patched []uint
+	deleted []uint
+}
+
+func (f *fakeFiles) PatchFile(ctx context.Context, userId uint, id uint, name *string, fileType *string, extension *string, size *uint64, expectedVersion *uint) (*entity.File, error) {
+	f.patched = append(f.patched, id)
+	file := &entity.File{Name: "a.txt", Size: *size}
+	file.ID = id
+	return file, nil
+}
+
+func (f *fakeFiles) DeleteFile(ctx context.Context, userId uint, id uint) (bool, error) {
+	f.deleted = append(f.deleted, id)
+	return true, nil
+}
+
+// fakeBlobs replaces a content only once it was read completely
+type fakeBlobs struct {
+	storage.BlobStore
+	contents map[uint][]byte
+}
+
+func (b *fakeBlobs) Put(ctx context.Context, id uint, content io.Reader) (int64, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return 0, err
+	}
+	b.contents[id] = data
+	return int64(len(data)), nil
+}
+
+type fakeTransactor struct{}
+
+func (fakeTransactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// brokenBody returns content and then fails like a client that went away
+type brokenBody struct {
+	content io.Reader
+}
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	n, err := b.content.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (b *brokenBody) Close() error { return nil }
+
+// put() writes the body to a file the way the webdav handler does and returns the error of Close()
+func put(t *testing.T, body io.ReadCloser, created bool) (*fakeFiles, *fakeBlobs, error) {
+	t.Helper()
+	files := &fakeFiles{}
+	blobs := &fakeBlobs{contents: map[uint][]byte{1: []byte("old")}}
+	fs := &fileSystem{userId: 7, fileSvc: files, blobs: blobs, tx: fakeTransactor{}}
+
+	request := &requestBody{ReadCloser: body}
+	ctx := context.WithValue(context.Background(), requestBodyKey{}, request)
+	file := &entity.File{Name: "a.txt"}
+	file.ID = 1
+
+	h := newWriteHandle(ctx, fs, file, nil, created)
+	// the handler closes the file whatever the copy returned
+	_, _ = io.Copy(h, request)
+	return files, blobs, h.Close()
+}
+
+func TestWriteHandleStoresTheBody(t *testing.T) {
+	files, blobs, err := put(t, io.NopCloser(bytes.NewReader([]byte("new"))), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blobs.contents[1]) != "new" || len(files.patched) != 1 || len(files.deleted) != 0 {
+		t.Fatalf("expected the content to be replaced, got %q, patched %v, deleted %v", blobs.contents[1], files.patched, files.deleted)
+	}
+}
+
+func TestWriteHandleKeepsTheContentWhenTheBodyBreaksOff(t *testing.T) {
+	files, blobs, err := put(t, &brokenBody{content: bytes.NewReader([]byte("ne"))}, false)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected the body error, got %v", err)
+	}
+	if string(blobs.contents[1]) != "old" || len(files.patched) != 0 || len(files.deleted) != 0 {
+		t.Fatalf("expected the file to stay as it was, got %q, patched %v, deleted %v", blobs.contents[1], files.patched, files.deleted)
+	}
+}
+
+func TestWriteHandleRemovesTheFileCreatedForABrokenBody(t *testing.T) {
+	files, _, err := put(t, &brokenBody{content: bytes.NewReader([]byte("ne"))}, true)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(files.deleted) != 1 || files.deleted[0] != 1 {
+		t.Fatalf("expected the created file to be removed, got %v", files.deleted)
+	}
+}
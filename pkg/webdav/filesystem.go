@@ -0,0 +1,226 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/repository/entity"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"golang.org/x/net/webdav"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"strings"
+)
+
+// fileSystem is the tree of one user, names are the cleaned slash separated paths of the webdav handler
+type fileSystem struct {
+	userId    uint
+	folderSvc service.FolderService
+	fileSvc   service.FileService
+	blobs     storage.BlobStore
+	naming    *service.NamingPolicy
+	tx        repository.Transactor
+}
+
+var _ webdav.FileSystem = (*fileSystem)(nil)
+
+// node is either a folder or a file of the tree
+type node struct {
+	folder *entity.Folder
+	file   *entity.File
+}
+
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if name == "/" {
+		return pathError("mkdir", name, os.ErrExist)
+	}
+
+	parent, err := fs.resolveFolder(ctx, path.Dir(name))
+	if err != nil {
+		return pathError("mkdir", name, err)
+	}
+
+	_, err = fs.folderSvc.CreateFolder(ctx, fs.userId, path.Base(name), parent.ID, service.ConflictFail)
+	return pathError("mkdir", name, err)
+}
+
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	condition := putConditionOf(ctx)
+
+	n, err := fs.resolve(ctx, name)
+	if isNotExist(err) && flag&os.O_CREATE != 0 {
+		parent, err := fs.resolveFolder(ctx, path.Dir(name))
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+		file, err := fs.fileSvc.CreateFile(ctx, fs.userId, path.Base(name), parent.ID, service.ConflictFail)
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+		return newWriteHandle(ctx, fs, file, nil, true), nil
+	} else if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	switch {
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL, writing && condition != nil && condition.create:
+		return nil, pathError("open", name, os.ErrExist)
+	case n.folder != nil && writing:
+		return nil, pathError("open", name, errIsFolder)
+	case n.folder != nil:
+		return &folderHandle{ctx: ctx, fs: fs, folder: n.folder}, nil
+	case writing && condition != nil && condition.fileId != 0:
+		// the path was taken by another file since the precondition matched
+		if condition.fileId != n.file.ID {
+			return nil, pathError("open", name, repository.ErrStaleVersion)
+		}
+		return newWriteHandle(ctx, fs, n.file, &condition.version, false), nil
+	case writing:
+		// the content is replaced as a whole, writes never land in the middle of a file
+		return newWriteHandle(ctx, fs, n.file, nil, false), nil
+	default:
+		return &readHandle{ctx: ctx, fs: fs, file: n.file}, nil
+	}
+}
+
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	n, err := fs.resolve(ctx, name)
+	if isNotExist(err) {
+		return nil
+	} else if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	if n.folder != nil {
+		if n.folder.ParentId == nil {
+			return pathError("remove", name, os.ErrPermission)
+		}
+		_, err = fs.folderSvc.DeleteFolder(ctx, fs.userId, n.folder.ID)
+	} else {
+		_, err = fs.fileSvc.DeleteFile(ctx, fs.userId, n.file.ID)
+	}
+	return pathError("remove", name, err)
+}
+
+// Rename() moves and renames a folder or file in one transaction, the handler removed an overwritten target before
+func (fs *fileSystem) Rename(ctx context.Context, oldName string, newName string) error {
+	if oldName == "/" || newName == "/" {
+		return pathError("rename", oldName, os.ErrPermission)
+	}
+
+	source, err := fs.resolve(ctx, oldName)
+	if err != nil {
+		return pathError("rename", oldName, err)
+	}
+	parent, err := fs.resolveFolder(ctx, path.Dir(newName))
+	if err != nil {
+		return pathError("rename", newName, err)
+	}
+	name := path.Base(newName)
+
+	// moved and renamed in one step, only an item of the new name in the new parent is a conflict
+	if folder := source.folder; folder != nil {
+		_, err = fs.folderSvc.MoveAndRenameFolder(ctx, fs.userId, folder.ID, parent.ID, name, service.ConflictFail, nil)
+	} else {
+		_, err = fs.fileSvc.MoveAndRenameFile(ctx, fs.userId, source.file.ID, parent.ID, name, service.ConflictFail, nil)
+	}
+	return pathError("rename", oldName, err)
+}
+
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	n, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	if n.folder != nil {
+		return newFolderInfo(n.folder), nil
+	}
+	return newFileInfo(n.file), nil
+}
+
+// resolve() walks the path down from the root folder of the user
+func (fs *fileSystem) resolve(ctx context.Context, name string) (*node, error) {
+	root, err := fs.folderSvc.GetRootFolder(ctx, fs.userId)
+	if err != nil {
+		return nil, err
+	}
+
+	current := &node{folder: root}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" {
+			continue
+		}
+		if current.folder == nil {
+			return nil, os.ErrNotExist
+		}
+		if current, err = fs.child(ctx, current.folder.ID, segment); err != nil {
+			return nil, err
+		}
+	}
+
+	return current, nil
+}
+
+func (fs *fileSystem) resolveFolder(ctx context.Context, name string) (*entity.Folder, error) {
+	n, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if n.folder == nil {
+		return nil, os.ErrNotExist
+	}
+	return n.folder, nil
+}
+
+// child() finds the folder or file of the name in the folder, names are compared as the naming policy stores them
+func (fs *fileSystem) child(ctx context.Context, folderId uint, name string) (*node, error) {
+	name, err := fs.naming.Normalize(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	folders, err := fs.folderSvc.GetChildren(ctx, fs.userId, folderId)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if fs.naming.SameName(folder.Name, name) {
+			return &node{folder: folder}, nil
+		}
+	}
+
+	files, err := fs.fileSvc.GetChildren(ctx, fs.userId, folderId)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if fs.naming.SameName(file.Name, name) {
+			return &node{file: file}, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+var errIsFolder = errors.New("is a folder")
+
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, service.ErrNotFound)
+}
+
+// pathError() translates service errors into the os errors the webdav handler chooses its status codes by
+func pathError(op string, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isNotExist(err):
+		err = os.ErrNotExist
+	case errors.Is(err, repository.ErrConflict):
+		err = os.ErrExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
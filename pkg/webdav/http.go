@@ -0,0 +1,142 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/potatowhite/books/file-service/pkg/repository"
+	"github.com/potatowhite/books/file-service/pkg/service"
+	"github.com/potatowhite/books/file-service/pkg/storage"
+	"github.com/potatowhite/books/file-service/pkg/util"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	logger = logging.For("webdav")
+)
+
+// Handler serves the tree of every user below prefix, as prefix/{userId}/path.
+// Every change goes through the folder and file services, so WebDAV clients follow the same rules as the API.
+func Handler(prefix string, folderSvc service.FolderService, fileSvc service.FileService, blobs storage.BlobStore, naming *service.NamingPolicy, tx repository.Transactor) http.Handler {
+	return &handler{
+		prefix:    strings.TrimSuffix(prefix, "/"),
+		folderSvc: folderSvc,
+		fileSvc:   fileSvc,
+		blobs:     blobs,
+		naming:    naming,
+		tx:        tx,
+		locks:     make(map[uint]webdav.LockSystem),
+	}
+}
+
+type handler struct {
+	prefix    string
+	folderSvc service.FolderService
+	fileSvc   service.FileService
+	blobs     storage.BlobStore
+	naming    *service.NamingPolicy
+	tx        repository.Transactor
+
+	// locks are kept in memory per user, as lock names are paths within the tree of a user
+	mu    sync.Mutex
+	locks map[uint]webdav.LockSystem
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userId, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, h.prefix), "/"), "/")
+	userIdInt := util.AtoUIOrNil(&userId)
+	if userIdInt == nil {
+		http.Error(w, "the path must start with the user id", http.StatusNotFound)
+		return
+	}
+
+	// the handler lists a copied folder after creating the copy, a copy into the folder itself would never end
+	if r.Method == "COPY" {
+		if destination, err := url.Parse(r.Header.Get("Destination")); err == nil &&
+			strings.HasPrefix(path.Clean(destination.Path), path.Clean(r.URL.Path)+"/") {
+			http.Error(w, "a folder cannot be copied into itself", http.StatusForbidden)
+			return
+		}
+	}
+
+	ctx := logging.WithUserID(r.Context(), userId)
+	prefix := h.prefix + "/" + userId
+	fs := &fileSystem{
+		userId:    *userIdInt,
+		folderSvc: h.folderSvc,
+		fileSvc:   h.fileSvc,
+		blobs:     h.blobs,
+		naming:    h.naming,
+		tx:        h.tx,
+	}
+
+	if r.Method == http.MethodPut {
+		var err error
+		if ctx, err = fs.checkPut(ctx, r, strings.TrimPrefix(r.URL.Path, prefix)); errors.Is(err, errPreconditionFailed) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		} else if err != nil {
+			logger.ErrorContext(ctx, "failed to check the preconditions of a webdav request", "path", r.URL.Path, "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		body := &requestBody{ReadCloser: r.Body}
+		r.Body = body
+		ctx = context.WithValue(ctx, requestBodyKey{}, body)
+	}
+
+	dav := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: fs,
+		LockSystem: h.lockSystem(*userIdInt),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logger.DebugContext(r.Context(), "webdav request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			}
+		},
+	}
+	dav.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requestBody remembers why reading the body of a PUT failed, the webdav handler closes the file regardless
+type requestBody struct {
+	io.ReadCloser
+	err error
+}
+
+type requestBodyKey struct{}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// bodyError() returns why the body of the PUT of ctx was not read completely, nil when it was
+func bodyError(ctx context.Context) error {
+	if body, ok := ctx.Value(requestBodyKey{}).(*requestBody); ok {
+		return body.err
+	}
+	return nil
+}
+
+func (h *handler) lockSystem(userId uint) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	locks, ok := h.locks[userId]
+	if !ok {
+		locks = webdav.NewMemLS()
+		h.locks[userId] = locks
+	}
+	return locks
+}
@@ -0,0 +1,529 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: proto/file/v1/file_tree.proto
+
+package filev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FileTree_GetRootFolder_FullMethodName = "/books.file.v1.FileTree/GetRootFolder"
+	FileTree_GetFolder_FullMethodName     = "/books.file.v1.FileTree/GetFolder"
+	FileTree_GetFile_FullMethodName       = "/books.file.v1.FileTree/GetFile"
+	FileTree_FileExists_FullMethodName    = "/books.file.v1.FileTree/FileExists"
+	FileTree_GetPath_FullMethodName       = "/books.file.v1.FileTree/GetPath"
+	FileTree_ListChildren_FullMethodName  = "/books.file.v1.FileTree/ListChildren"
+	FileTree_GetUsage_FullMethodName      = "/books.file.v1.FileTree/GetUsage"
+	FileTree_CreateFolder_FullMethodName  = "/books.file.v1.FileTree/CreateFolder"
+	FileTree_CreateFile_FullMethodName    = "/books.file.v1.FileTree/CreateFile"
+	FileTree_DeleteFolder_FullMethodName  = "/books.file.v1.FileTree/DeleteFolder"
+	FileTree_DeleteFile_FullMethodName    = "/books.file.v1.FileTree/DeleteFile"
+)
+
+// FileTreeClient is the client API for FileTree service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileTreeClient interface {
+	// GetRootFolder returns the root folder of the user.
+	GetRootFolder(ctx context.Context, in *GetRootFolderRequest, opts ...grpc.CallOption) (*Folder, error)
+	// GetFolder returns a folder of the user.
+	GetFolder(ctx context.Context, in *GetFolderRequest, opts ...grpc.CallOption) (*Folder, error)
+	// GetFile returns a file of the user.
+	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (*File, error)
+	// FileExists reports whether the user has the file, a missing file is not an error.
+	FileExists(ctx context.Context, in *FileExistsRequest, opts ...grpc.CallOption) (*FileExistsResponse, error)
+	// GetPath returns the path of a folder from the root folder.
+	GetPath(ctx context.Context, in *GetPathRequest, opts ...grpc.CallOption) (*GetPathResponse, error)
+	// ListChildren streams the folders and then the files directly in a folder.
+	ListChildren(ctx context.Context, in *ListChildrenRequest, opts ...grpc.CallOption) (FileTree_ListChildrenClient, error)
+	// GetUsage returns how many folders and files the user has and how large the files are.
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*Usage, error)
+	// CreateFolder creates a folder, resolving a name taken in the parent by the conflict strategy.
+	CreateFolder(ctx context.Context, in *CreateFolderRequest, opts ...grpc.CallOption) (*Folder, error)
+	// CreateFile creates a file, resolving a name taken in the folder by the conflict strategy.
+	CreateFile(ctx context.Context, in *CreateFileRequest, opts ...grpc.CallOption) (*File, error)
+	// DeleteFolder deletes a folder of the user.
+	DeleteFolder(ctx context.Context, in *DeleteFolderRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// DeleteFile deletes a file of the user.
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+}
+
+type fileTreeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileTreeClient(cc grpc.ClientConnInterface) FileTreeClient {
+	return &fileTreeClient{cc}
+}
+
+func (c *fileTreeClient) GetRootFolder(ctx context.Context, in *GetRootFolderRequest, opts ...grpc.CallOption) (*Folder, error) {
+	out := new(Folder)
+	err := c.cc.Invoke(ctx, FileTree_GetRootFolder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) GetFolder(ctx context.Context, in *GetFolderRequest, opts ...grpc.CallOption) (*Folder, error) {
+	out := new(Folder)
+	err := c.cc.Invoke(ctx, FileTree_GetFolder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (*File, error) {
+	out := new(File)
+	err := c.cc.Invoke(ctx, FileTree_GetFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) FileExists(ctx context.Context, in *FileExistsRequest, opts ...grpc.CallOption) (*FileExistsResponse, error) {
+	out := new(FileExistsResponse)
+	err := c.cc.Invoke(ctx, FileTree_FileExists_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) GetPath(ctx context.Context, in *GetPathRequest, opts ...grpc.CallOption) (*GetPathResponse, error) {
+	out := new(GetPathResponse)
+	err := c.cc.Invoke(ctx, FileTree_GetPath_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) ListChildren(ctx context.Context, in *ListChildrenRequest, opts ...grpc.CallOption) (FileTree_ListChildrenClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTree_ServiceDesc.Streams[0], FileTree_ListChildren_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTreeListChildrenClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTree_ListChildrenClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
+}
+
+type fileTreeListChildrenClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTreeListChildrenClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTreeClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*Usage, error) {
+	out := new(Usage)
+	err := c.cc.Invoke(ctx, FileTree_GetUsage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) CreateFolder(ctx context.Context, in *CreateFolderRequest, opts ...grpc.CallOption) (*Folder, error) {
+	out := new(Folder)
+	err := c.cc.Invoke(ctx, FileTree_CreateFolder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) CreateFile(ctx context.Context, in *CreateFileRequest, opts ...grpc.CallOption) (*File, error) {
+	out := new(File)
+	err := c.cc.Invoke(ctx, FileTree_CreateFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) DeleteFolder(ctx context.Context, in *DeleteFolderRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, FileTree_DeleteFolder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTreeClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, FileTree_DeleteFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileTreeServer is the server API for FileTree service.
+// All implementations must embed UnimplementedFileTreeServer
+// for forward compatibility
+type FileTreeServer interface {
+	// GetRootFolder returns the root folder of the user.
+	GetRootFolder(context.Context, *GetRootFolderRequest) (*Folder, error)
+	// GetFolder returns a folder of the user.
+	GetFolder(context.Context, *GetFolderRequest) (*Folder, error)
+	// GetFile returns a file of the user.
+	GetFile(context.Context, *GetFileRequest) (*File, error)
+	// FileExists reports whether the user has the file, a missing file is not an error.
+	FileExists(context.Context, *FileExistsRequest) (*FileExistsResponse, error)
+	// GetPath returns the path of a folder from the root folder.
+	GetPath(context.Context, *GetPathRequest) (*GetPathResponse, error)
+	// ListChildren streams the folders and then the files directly in a folder.
+	ListChildren(*ListChildrenRequest, FileTree_ListChildrenServer) error
+	// GetUsage returns how many folders and files the user has and how large the files are.
+	GetUsage(context.Context, *GetUsageRequest) (*Usage, error)
+	// CreateFolder creates a folder, resolving a name taken in the parent by the conflict strategy.
+	CreateFolder(context.Context, *CreateFolderRequest) (*Folder, error)
+	// CreateFile creates a file, resolving a name taken in the folder by the conflict strategy.
+	CreateFile(context.Context, *CreateFileRequest) (*File, error)
+	// DeleteFolder deletes a folder of the user.
+	DeleteFolder(context.Context, *DeleteFolderRequest) (*DeleteResponse, error)
+	// DeleteFile deletes a file of the user.
+	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteResponse, error)
+	mustEmbedUnimplementedFileTreeServer()
+}
+
+// UnimplementedFileTreeServer must be embedded to have forward compatible implementations.
+type UnimplementedFileTreeServer struct {
+}
+
+func (UnimplementedFileTreeServer) GetRootFolder(context.Context, *GetRootFolderRequest) (*Folder, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRootFolder not implemented")
+}
+func (UnimplementedFileTreeServer) GetFolder(context.Context, *GetFolderRequest) (*Folder, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFolder not implemented")
+}
+func (UnimplementedFileTreeServer) GetFile(context.Context, *GetFileRequest) (*File, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFile not implemented")
+}
+func (UnimplementedFileTreeServer) FileExists(context.Context, *FileExistsRequest) (*FileExistsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FileExists not implemented")
+}
+func (UnimplementedFileTreeServer) GetPath(context.Context, *GetPathRequest) (*GetPathResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPath not implemented")
+}
+func (UnimplementedFileTreeServer) ListChildren(*ListChildrenRequest, FileTree_ListChildrenServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListChildren not implemented")
+}
+func (UnimplementedFileTreeServer) GetUsage(context.Context, *GetUsageRequest) (*Usage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedFileTreeServer) CreateFolder(context.Context, *CreateFolderRequest) (*Folder, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateFolder not implemented")
+}
+func (UnimplementedFileTreeServer) CreateFile(context.Context, *CreateFileRequest) (*File, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateFile not implemented")
+}
+func (UnimplementedFileTreeServer) DeleteFolder(context.Context, *DeleteFolderRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFolder not implemented")
+}
+func (UnimplementedFileTreeServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedFileTreeServer) mustEmbedUnimplementedFileTreeServer() {}
+
+// UnsafeFileTreeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileTreeServer will
+// result in compilation errors.
+type UnsafeFileTreeServer interface {
+	mustEmbedUnimplementedFileTreeServer()
+}
+
+func RegisterFileTreeServer(s grpc.ServiceRegistrar, srv FileTreeServer) {
+	s.RegisterService(&FileTree_ServiceDesc, srv)
+}
+
+func _FileTree_GetRootFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRootFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).GetRootFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_GetRootFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).GetRootFolder(ctx, req.(*GetRootFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_GetFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).GetFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_GetFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).GetFolder(ctx, req.(*GetFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_GetFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).GetFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_GetFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).GetFile(ctx, req.(*GetFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_FileExists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileExistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).FileExists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_FileExists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).FileExists(ctx, req.(*FileExistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_GetPath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).GetPath(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_GetPath_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).GetPath(ctx, req.(*GetPathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_ListChildren_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListChildrenRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTreeServer).ListChildren(m, &fileTreeListChildrenServer{stream})
+}
+
+type FileTree_ListChildrenServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type fileTreeListChildrenServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTreeListChildrenServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTree_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_GetUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_CreateFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).CreateFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_CreateFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).CreateFolder(ctx, req.(*CreateFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_CreateFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).CreateFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_CreateFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).CreateFile(ctx, req.(*CreateFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_DeleteFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).DeleteFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_DeleteFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).DeleteFolder(ctx, req.(*DeleteFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTree_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTreeServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTree_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTreeServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FileTree_ServiceDesc is the grpc.ServiceDesc for FileTree service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileTree_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "books.file.v1.FileTree",
+	HandlerType: (*FileTreeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRootFolder",
+			Handler:    _FileTree_GetRootFolder_Handler,
+		},
+		{
+			MethodName: "GetFolder",
+			Handler:    _FileTree_GetFolder_Handler,
+		},
+		{
+			MethodName: "GetFile",
+			Handler:    _FileTree_GetFile_Handler,
+		},
+		{
+			MethodName: "FileExists",
+			Handler:    _FileTree_FileExists_Handler,
+		},
+		{
+			MethodName: "GetPath",
+			Handler:    _FileTree_GetPath_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _FileTree_GetUsage_Handler,
+		},
+		{
+			MethodName: "CreateFolder",
+			Handler:    _FileTree_CreateFolder_Handler,
+		},
+		{
+			MethodName: "CreateFile",
+			Handler:    _FileTree_CreateFile_Handler,
+		},
+		{
+			MethodName: "DeleteFolder",
+			Handler:    _FileTree_DeleteFolder_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _FileTree_DeleteFile_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListChildren",
+			Handler:       _FileTree_ListChildren_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/file/v1/file_tree.proto",
+}
@@ -0,0 +1,199 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/books/file-service/logging"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var (
+	logger = logging.For("schema")
+
+	// schema files are stored as <dir>/<subject>/v<version>.json
+	versionFile = regexp.MustCompile(`^v([0-9]+)\.json$`)
+)
+
+// VersionHeader is the message header carrying the schema version of the payload
+const VersionHeader = "schemaVersion"
+
+// Upcaster converts a decoded payload of one version into the shape of the next version
+type Upcaster func(payload map[string]interface{}) (map[string]interface{}, error)
+
+// ViolationError is returned when a payload does not match the schema of its subject and version
+type ViolationError struct {
+	Subject string
+	Version int
+	Err     error
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("payload violates schema %s v%d: %v", e.Subject, e.Version, e.Err)
+}
+
+func (e *ViolationError) Unwrap() error {
+	return e.Err
+}
+
+// IsViolation() reports whether err is caused by a payload not matching its schema
+func IsViolation(err error) bool {
+	var violation *ViolationError
+	return errors.As(err, &violation)
+}
+
+type subjectVersion struct {
+	subject string
+	version int
+}
+
+// Registry is a local, file based stand-in for a schema registry
+type Registry struct {
+	schemas   map[subjectVersion]*jsonschema.Schema
+	latest    map[string]int
+	upcasters map[subjectVersion]Upcaster
+}
+
+// LoadRegistry() compiles every schema found in dir
+func LoadRegistry(dir string) (*Registry, error) {
+	r := &Registry{
+		schemas:   make(map[subjectVersion]*jsonschema.Schema),
+		latest:    make(map[string]int),
+		upcasters: make(map[subjectVersion]Upcaster),
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+
+	subjects, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subject := range subjects {
+		if !subject.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(dir, subject.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			match := versionFile.FindStringSubmatch(file.Name())
+			if match == nil {
+				continue
+			}
+
+			version, _ := strconv.Atoi(match[1])
+			path := filepath.Join(dir, subject.Name(), file.Name())
+
+			compiled, err := compiler.Compile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile schema %s: %v", path, err)
+			}
+
+			r.schemas[subjectVersion{subject.Name(), version}] = compiled
+			if version > r.latest[subject.Name()] {
+				r.latest[subject.Name()] = version
+			}
+		}
+	}
+
+	logger.Info("loaded schemas", "count", len(r.schemas), "dir", dir)
+	return r, nil
+}
+
+// RegisterUpcaster() adds the conversion of a subject from version `from` to `from + 1`
+func (r *Registry) RegisterUpcaster(subject string, from int, upcaster Upcaster) {
+	r.upcasters[subjectVersion{subject, from}] = upcaster
+}
+
+// Latest() returns the newest known version of a subject, or 0 if the subject is unknown
+func (r *Registry) Latest(subject string) int {
+	return r.latest[subject]
+}
+
+// Validate() checks a raw payload against the schema of the given subject and version
+func (r *Registry) Validate(subject string, version int, payload []byte) error {
+	_, err := r.validate(subject, version, payload)
+	return err
+}
+
+// Decode() validates a payload, upcasts it to the latest version of the subject and unmarshals it into out.
+// An upcast payload is validated again against the latest version.
+func (r *Registry) Decode(subject string, version int, payload []byte, out interface{}) error {
+	doc, err := r.validate(subject, version, payload)
+	if err != nil {
+		return err
+	}
+
+	upcast := version < r.latest[subject]
+	for ; version < r.latest[subject]; version++ {
+		upcaster, ok := r.upcasters[subjectVersion{subject, version}]
+		if !ok {
+			return fmt.Errorf("no upcaster registered for %s v%d", subject, version)
+		}
+
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return &ViolationError{Subject: subject, Version: version, Err: errors.New("payload is not an object")}
+		}
+
+		if doc, err = upcaster(object); err != nil {
+			return &ViolationError{Subject: subject, Version: version, Err: fmt.Errorf("failed to upcast: %v", err)}
+		}
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	// an older version may lack what the latest one requires, handlers rely on the latest schema only
+	if upcast {
+		if _, err := r.validate(subject, version, raw); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(raw, out)
+}
+
+// Encode() marshals v and validates it against the latest version of the subject
+func (r *Registry) Encode(subject string, v interface{}) ([]byte, int, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version := r.latest[subject]
+	if _, err := r.validate(subject, version, payload); err != nil {
+		return nil, 0, err
+	}
+
+	return payload, version, nil
+}
+
+func (r *Registry) validate(subject string, version int, payload []byte) (interface{}, error) {
+	compiled, ok := r.schemas[subjectVersion{subject, version}]
+	if !ok {
+		return nil, &ViolationError{Subject: subject, Version: version, Err: errors.New("unknown schema")}
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return nil, &ViolationError{Subject: subject, Version: version, Err: err}
+	}
+
+	if err := compiled.Validate(doc); err != nil {
+		return nil, &ViolationError{Subject: subject, Version: version, Err: err}
+	}
+
+	return doc, nil
+}
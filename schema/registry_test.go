@@ -0,0 +1,188 @@
+package schema
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSchemas() stores the schemas of the map, keyed by <subject>/v<version>.json, in a new directory
+func writeSchemas(t *testing.T, schemas map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range schemas {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const (
+	bookV1 = `{"type": "object", "required": ["name"], "properties": {"name": {"type": "string", "minLength": 1}}}`
+	bookV2 = `{"type": "object", "required": ["title"], "properties": {"title": {"type": "string", "minLength": 1}, "pages": {"type": "integer"}}}`
+)
+
+type book struct {
+	Title string `json:"title"`
+	Pages int    `json:"pages"`
+}
+
+func loadBooks(t *testing.T) *Registry {
+	t.Helper()
+	registry, err := LoadRegistry(writeSchemas(t, map[string]string{
+		"Book/v1.json":  bookV1,
+		"Book/v2.json":  bookV2,
+		"Book/notes.md": "ignored",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestLoadRegistryFindsTheLatestVersion(t *testing.T) {
+	registry := loadBooks(t)
+	if registry.Latest("Book") != 2 {
+		t.Fatalf("expected version 2, got %d", registry.Latest("Book"))
+	}
+	if registry.Latest("Author") != 0 {
+		t.Fatal("expected 0 for an unknown subject")
+	}
+}
+
+func TestLoadRegistryRejectsInvalidSchemas(t *testing.T) {
+	if _, err := LoadRegistry(writeSchemas(t, map[string]string{"Book/v1.json": `{"type": 7}`})); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	registry := loadBooks(t)
+	tests := []struct {
+		name    string
+		version int
+		payload string
+		valid   bool
+	}{
+		{"valid", 1, `{"name": "Dune"}`, true},
+		{"missing field", 1, `{}`, false},
+		{"wrong type", 1, `{"name": 7}`, false},
+		{"empty string", 1, `{"name": ""}`, false},
+		{"not json", 1, `name=Dune`, false},
+		{"other version", 2, `{"name": "Dune"}`, false},
+		{"unknown version", 3, `{"title": "Dune"}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := registry.Validate("Book", test.version, []byte(test.payload))
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !test.valid && !IsViolation(err) {
+				t.Fatalf("expected a violation, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeUpcastsToTheLatestVersion(t *testing.T) {
+	registry := loadBooks(t)
+	registry.RegisterUpcaster("Book", 1, func(payload map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"title": payload["name"], "pages": 0}, nil
+	})
+
+	var decoded book
+	if err := registry.Decode("Book", 1, []byte(`{"name": "Dune"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != "Dune" {
+		t.Fatalf("expected the upcast title, got %+v", decoded)
+	}
+
+	decoded = book{}
+	if err := registry.Decode("Book", 2, []byte(`{"title": "Emma", "pages": 474}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != "Emma" || decoded.Pages != 474 {
+		t.Fatalf("expected the latest version unchanged, got %+v", decoded)
+	}
+}
+
+func TestDecodeValidatesBeforeUpcasting(t *testing.T) {
+	registry := loadBooks(t)
+	registry.RegisterUpcaster("Book", 1, func(payload map[string]interface{}) (map[string]interface{}, error) {
+		t.Fatal("upcast an invalid payload")
+		return nil, nil
+	})
+
+	if err := registry.Decode("Book", 1, []byte(`{}`), &book{}); !IsViolation(err) {
+		t.Fatalf("expected a violation, got %v", err)
+	}
+}
+
+func TestDecodeValidatesTheUpcastPayload(t *testing.T) {
+	registry, err := LoadRegistry(writeSchemas(t, map[string]string{
+		"UserDeletedEvent/v0.json": `{"type": "object", "properties": {"userID": {"type": ["string", "integer"]}}}`,
+		"UserDeletedEvent/v1.json": `{"type": "object", "required": ["userID"], "properties": {"userID": {"type": "string"}}}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.RegisterUpcaster("UserDeletedEvent", 0, func(payload map[string]interface{}) (map[string]interface{}, error) {
+		return payload, nil
+	})
+
+	var event struct {
+		UserID string `json:"userID"`
+	}
+	if err := registry.Decode("UserDeletedEvent", 0, []byte(`{"userID": "7"}`), &event); err != nil || event.UserID != "7" {
+		t.Fatalf("expected the v0 payload to decode, got %+v: %v", event, err)
+	}
+
+	// valid as v0, but the userID the latest version requires is missing
+	err = registry.Decode("UserDeletedEvent", 0, []byte(`{}`), &event)
+	var violation *ViolationError
+	if !errors.As(err, &violation) || violation.Version != 1 {
+		t.Fatalf("expected a violation of v1, got %v", err)
+	}
+}
+
+func TestDecodeFailures(t *testing.T) {
+	registry := loadBooks(t)
+
+	// a missing upcaster is a bug of the consumer, not of the payload
+	err := registry.Decode("Book", 1, []byte(`{"name": "Dune"}`), &book{})
+	if err == nil || IsViolation(err) || !strings.Contains(err.Error(), "no upcaster") {
+		t.Fatalf("expected a missing upcaster, got %v", err)
+	}
+
+	registry.RegisterUpcaster("Book", 1, func(payload map[string]interface{}) (map[string]interface{}, error) {
+		return nil, errors.New("name cannot be split")
+	})
+	if err := registry.Decode("Book", 1, []byte(`{"name": "Dune"}`), &book{}); !IsViolation(err) {
+		t.Fatalf("expected a failed upcast to be a violation, got %v", err)
+	}
+}
+
+func TestEncodeValidatesAgainstTheLatestVersion(t *testing.T) {
+	registry := loadBooks(t)
+
+	payload, version, err := registry.Encode("Book", book{Title: "Dune", Pages: 412})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || !strings.Contains(string(payload), `"title":"Dune"`) {
+		t.Fatalf("unexpected payload %s of version %d", payload, version)
+	}
+
+	if _, _, err := registry.Encode("Book", book{}); !IsViolation(err) {
+		t.Fatalf("expected a violation, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// GraphqlExtension starts a span for every GraphQL operation, resolvers run within it
+type GraphqlExtension struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = GraphqlExtension{}
+
+func (GraphqlExtension) ExtensionName() string {
+	return "Tracing"
+}
+
+func (GraphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (GraphqlExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	operation := "anonymous"
+	if op := graphql.GetOperationContext(ctx); op != nil && op.OperationName != "" {
+		operation = op.OperationName
+	}
+
+	ctx, span := Start(ctx, "graphql "+operation)
+	defer span.End()
+	span.SetAttributes(attribute.String("graphql.operation.name", operation))
+
+	res := next(ctx)
+	if res != nil && len(res.Errors) > 0 {
+		span.SetStatus(codes.Error, res.Errors.Error())
+	}
+
+	return res
+}
@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/potatowhite/books/file-service/config"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// recorder receives every span the tests end, the tracer of the package binds to the first provider installed
+var recorder = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	if _, err := Init(config.Tracing{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// ended() returns the spans of the trace of ctx that ended since the test began
+func ended(t *testing.T, ctx context.Context, since int) []sdktrace.ReadOnlySpan {
+	t.Helper()
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended()[since:] {
+		if span.SpanContext().TraceID() == trace.SpanContextFromContext(ctx).TraceID() {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func attributeOf(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// shelf is stored in the table shelves
+type shelf struct {
+	ID   uint
+	Name string
+}
+
+func TestGormPluginStartsAClientSpanForEveryQuery(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	since := len(recorder.Ended())
+	ctx, parent := Start(context.Background(), "request")
+	db.WithContext(ctx).Find(&[]shelf{})
+	parent.End()
+
+	spans := ended(t, ctx, since)
+	if len(spans) != 2 {
+		t.Fatalf("expected the query and the request span, got %d spans", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "db.query shelves" || span.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("expected the client span db.query shelves, got %s %v", span.Name(), span.SpanKind())
+	}
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("expected the query span to be a child of the context span")
+	}
+	if table, _ := attributeOf(span, semconv.DBSQLTableKey); table.AsString() != "shelves" {
+		t.Fatalf("expected the table attribute shelves, got %q", table.AsString())
+	}
+	if statement, _ := attributeOf(span, semconv.DBStatementKey); statement.AsString() != `SELECT * FROM "shelves"` {
+		t.Fatalf("expected the statement attribute, got %q", statement.AsString())
+	}
+	if span.Status().Code == codes.Error {
+		t.Fatal("expected the query span to succeed")
+	}
+}
+
+func TestGraphqlExtensionStartsASpanForTheOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		errors    gqlerror.List
+		span      string
+		status    codes.Code
+	}{
+		{name: "success", operation: "CreateFile", span: "graphql CreateFile", status: codes.Unset},
+		{name: "errors", operation: "CreateFile", errors: gqlerror.List{gqlerror.Errorf("conflict")}, span: "graphql CreateFile", status: codes.Error},
+		{name: "anonymous", span: "graphql anonymous", status: codes.Unset},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			since := len(recorder.Ended())
+			ctx, parent := Start(context.Background(), "request")
+			ctx = graphql.WithOperationContext(ctx, &graphql.OperationContext{OperationName: test.operation})
+
+			var resolved trace.SpanContext
+			GraphqlExtension{}.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+				resolved = trace.SpanContextFromContext(ctx)
+				return &graphql.Response{Errors: test.errors}
+			})
+			parent.End()
+
+			spans := ended(t, ctx, since)
+			if len(spans) != 2 || spans[0].Name() != test.span {
+				t.Fatalf("expected the span %s, got %v", test.span, spans)
+			}
+			span := spans[0]
+			if span.Status().Code != test.status {
+				t.Fatalf("expected the status %v, got %v", test.status, span.Status().Code)
+			}
+			if name, _ := attributeOf(span, "graphql.operation.name"); name.AsString() != span.Name()[len("graphql "):] {
+				t.Fatalf("expected the operation name attribute, got %q", name.AsString())
+			}
+			if span.Parent().SpanID() != parent.SpanContext().SpanID() || resolved.SpanID() != span.SpanContext().SpanID() {
+				t.Fatal("expected the resolvers to run within the operation span")
+			}
+		})
+	}
+}
+
+func TestMiddlewareContinuesTheTraceOfTheRequest(t *testing.T) {
+	since := len(recorder.Ended())
+	ctx, parent := Start(context.Background(), "caller")
+	parent.End()
+
+	request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+	Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})).ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := ended(t, ctx, since)
+	if len(spans) != 2 {
+		t.Fatalf("expected the server span in the trace of the caller, got %d spans", len(spans))
+	}
+	span := spans[1]
+	if span.Name() != "GET /files/1" || span.SpanKind() != trace.SpanKindServer || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("expected the server span GET /files/1 as a child of the caller, got %s %v", span.Name(), span.SpanKind())
+	}
+	if status, _ := attributeOf(span, semconv.HTTPStatusCodeKey); status.AsInt64() != http.StatusBadGateway || span.Status().Code != codes.Error {
+		t.Fatalf("expected the failed status to be recorded, got %v %v", status.AsInt64(), span.Status().Code)
+	}
+}
+
+func TestInjectAndExtractKeepTheTrace(t *testing.T) {
+	ctx, span := Start(context.Background(), "producer")
+	defer span.End()
+
+	headers := make(map[string]string)
+	Inject(ctx, headers)
+	if headers["traceparent"] == "" {
+		t.Fatalf("expected a traceparent header, got %v", headers)
+	}
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), headers))
+	if !extracted.IsRemote() || extracted.TraceID() != span.SpanContext().TraceID() || extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("expected the span of the producer, got %v", extracted)
+	}
+}